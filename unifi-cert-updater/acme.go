@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// acmeAccountKey is the secret key the ACME account key is stored under,
+// alongside the issued tls.crt and tls.key.
+const acmeAccountKey = "acme-account.key"
+
+// ACMEConfig configures issuance from an ACME directory. The issued
+// certificate, its key and the account key are stored in the console's TLS
+// secret, which is created if it does not exist.
+type ACMEConfig struct {
+	DirectoryURL string             `json:"directoryURL"`
+	Email        string             `json:"email,omitempty"`
+	Domains      []string           `json:"domains"`
+	KeyType      certcrypto.KeyType `json:"keyType,omitempty"`     // P256 (default), P384, 2048, 3072, 4096 or 8192
+	RenewBefore  metav1.Duration    `json:"renewBefore,omitempty"` // Defaults to 30 days
+	CAFile       string             `json:"caFile,omitempty"`      // Extra CA to trust for the directory, e.g. Pebble's
+	DNS01        *RFC2136Config     `json:"dns01,omitempty"`
+	HTTP01       *HTTP01Config      `json:"http01,omitempty"`
+}
+
+// RFC2136Config solves DNS-01 challenges with dynamic updates, e.g. to bind9.
+type RFC2136Config struct {
+	Nameserver         string              `json:"nameserver"`
+	TSIGKeyName        string              `json:"tsigKeyName,omitempty"`
+	TSIGAlgorithm      string              `json:"tsigAlgorithm,omitempty"`
+	TSIGSecret         *SecretKeyReference `json:"tsigSecret,omitempty"`
+	Resolvers          []string            `json:"resolvers,omitempty"` // Resolvers used to check propagation
+	PropagationTimeout metav1.Duration     `json:"propagationTimeout,omitempty"`
+}
+
+// HTTP01Config solves HTTP-01 challenges with a built-in web server.
+type HTTP01Config struct {
+	Address string `json:"address,omitempty"` // Defaults to ":80"
+}
+
+// SecretKeyReference points at a single key of a Kubernetes secret.
+type SecretKeyReference struct {
+	SecretReference
+	Key string `json:"key"`
+}
+
+// validateACMEConfig checks an ACME configuration for missing settings.
+func validateACMEConfig(config *ACMEConfig) []error {
+	var errs []error
+	if config.DirectoryURL == "" {
+		errs = append(errs, fmt.Errorf("acme.directoryURL is required"))
+	}
+	if len(config.Domains) == 0 {
+		errs = append(errs, fmt.Errorf("acme.domains is required"))
+	}
+	if (config.DNS01 == nil) == (config.HTTP01 == nil) {
+		errs = append(errs, fmt.Errorf("exactly one of acme.dns01 and acme.http01 is required"))
+	}
+	if config.DNS01 != nil && config.DNS01.Nameserver == "" {
+		errs = append(errs, fmt.Errorf("acme.dns01.nameserver is required"))
+	}
+	if ref := config.DNS01; ref != nil && ref.TSIGSecret != nil && (ref.TSIGKeyName == "" || ref.TSIGSecret.Name == "" || ref.TSIGSecret.Key == "") {
+		errs = append(errs, fmt.Errorf("acme.dns01.tsigKeyName and tsigSecret name and key are required for TSIG"))
+	}
+	switch config.KeyType {
+	case "", certcrypto.EC256, certcrypto.EC384, certcrypto.RSA2048, certcrypto.RSA3072, certcrypto.RSA4096, certcrypto.RSA8192:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported acme.keyType %q", config.KeyType))
+	}
+	return errs
+}
+
+// acmeUser is the lego view of our ACME account.
+type acmeUser struct {
+	email        string
+	key          crypto.PrivateKey
+	registration *registration.Resource
+}
+
+func (u *acmeUser) GetEmail() string                        { return u.email }
+func (u *acmeUser) GetRegistration() *registration.Resource { return u.registration }
+func (u *acmeUser) GetPrivateKey() crypto.PrivateKey        { return u.key }
+
+// acmeSource issues certificates from an ACME directory and renews them once
+// they are within RenewBefore of expiring.
+type acmeSource struct {
+	config ACMEConfig
+	store  SecretReference
+	logger *logrus.Logger
+}
+
+func newACMESource(config ACMEConfig, store SecretReference, logger *logrus.Logger) *acmeSource {
+	if config.KeyType == "" {
+		config.KeyType = certcrypto.EC256
+	}
+	if config.RenewBefore.Duration == 0 {
+		config.RenewBefore.Duration = 30 * 24 * time.Hour
+	}
+	return &acmeSource{config: config, store: store, logger: logger}
+}
+
+func (s *acmeSource) Fetch(ctx context.Context, k8sClient client.Client) (string, string, error) {
+	secret, err := s.storedSecret(ctx, k8sClient)
+	if err != nil {
+		return "", "", err
+	}
+
+	cert, key := string(secret.Data["tls.crt"]), string(secret.Data["tls.key"])
+	reason := s.renewalReason(cert, time.Now())
+	if reason == "" {
+		s.logger.Debugf("ACME certificate in secret '%s/%s' is current.", s.store.Namespace, s.store.Name)
+		return cert, key, nil
+	}
+
+	s.logger.Infof("Requesting certificate for %v from %s: %s.", s.config.Domains, s.config.DirectoryURL, reason)
+	resource, accountKeyPEM, err := s.obtain(ctx, k8sClient, secret.Data[acmeAccountKey])
+	if err != nil {
+		return "", "", fmt.Errorf("failed to obtain ACME certificate: %w", err)
+	}
+
+	if err := s.storeCertificate(ctx, k8sClient, secret, resource, accountKeyPEM); err != nil {
+		return "", "", err
+	}
+	s.logger.Infof("Stored ACME certificate in secret '%s/%s'.", s.store.Namespace, s.store.Name)
+	return string(resource.Certificate), string(resource.PrivateKey), nil
+}
+
+func (s *acmeSource) FetchDryRun(ctx context.Context, k8sClient client.Client) (string, string, string, error) {
+	secret, err := s.storedSecret(ctx, k8sClient)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	cert, key := string(secret.Data["tls.crt"]), string(secret.Data["tls.key"])
+	reason := s.renewalReason(cert, time.Now())
+	if reason == "" {
+		return cert, key, "", nil
+	}
+	return "", "", fmt.Sprintf("request a new certificate for %v from %s (%s)", s.config.Domains, s.config.DirectoryURL, reason), nil
+}
+
+// storedSecret returns the secret holding the previously issued certificate,
+// or an empty secret if it does not exist yet.
+func (s *acmeSource) storedSecret(ctx context.Context, k8sClient client.Client) (*corev1.Secret, error) {
+	var secret corev1.Secret
+	err := k8sClient.Get(ctx, client.ObjectKey{Namespace: s.store.Namespace, Name: s.store.Name}, &secret)
+	if apierrors.IsNotFound(err) {
+		return &corev1.Secret{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ACME certificate secret: %w", err)
+	}
+	return &secret, nil
+}
+
+// renewalReason explains why a new certificate is needed, or returns an empty
+// string if the stored certificate can still be used.
+func (s *acmeSource) renewalReason(certPEM string, now time.Time) string {
+	if certPEM == "" {
+		return "no certificate issued yet"
+	}
+
+	chain, err := parseCertificateChain(certPEM)
+	if err != nil {
+		return fmt.Sprintf("stored certificate is unreadable: %v", err)
+	}
+	leaf := chain[0]
+
+	for _, domain := range s.config.Domains {
+		if err := leaf.VerifyHostname(domain); err != nil {
+			return fmt.Sprintf("stored certificate does not cover %s", domain)
+		}
+	}
+	if renewAt := leaf.NotAfter.Add(-s.config.RenewBefore.Duration); now.After(renewAt) {
+		return fmt.Sprintf("stored certificate expires at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// obtain registers (or recovers) the ACME account and requests a certificate
+// for the configured domains. It returns the account key so it can be stored.
+func (s *acmeSource) obtain(ctx context.Context, k8sClient client.Client, accountKeyPEM []byte) (*certificate.Resource, []byte, error) {
+	accountKey, accountKeyPEM, err := loadOrCreateAccountKey(accountKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	user := &acmeUser{email: s.config.Email, key: accountKey}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = s.config.DirectoryURL
+	legoConfig.Certificate.KeyType = s.config.KeyType
+	if s.config.CAFile != "" {
+		httpClient, err := httpClientWithCA(s.config.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		legoConfig.HTTPClient = httpClient
+	}
+
+	legoClient, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create ACME client: %w", err)
+	}
+
+	if err := s.setupChallenge(ctx, k8sClient, legoClient); err != nil {
+		return nil, nil, err
+	}
+
+	user.registration, err = legoClient.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	resource, err := legoClient.Certificate.Obtain(certificate.ObtainRequest{
+		Domains: s.config.Domains,
+		Bundle:  true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resource, accountKeyPEM, nil
+}
+
+func (s *acmeSource) setupChallenge(ctx context.Context, k8sClient client.Client, legoClient *lego.Client) error {
+	if cfg := s.config.HTTP01; cfg != nil {
+		address := cfg.Address
+		if address == "" {
+			address = ":80"
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid acme.http01.address: %w", err)
+		}
+		return legoClient.Challenge.SetHTTP01Provider(http01.NewProviderServer(host, port))
+	}
+
+	cfg := s.config.DNS01
+	providerConfig := rfc2136.NewDefaultConfig()
+	providerConfig.Nameserver = cfg.Nameserver
+	if cfg.TSIGAlgorithm != "" {
+		providerConfig.TSIGAlgorithm = cfg.TSIGAlgorithm
+	}
+	if cfg.PropagationTimeout.Duration != 0 {
+		providerConfig.PropagationTimeout = cfg.PropagationTimeout.Duration
+	}
+	if cfg.TSIGSecret != nil {
+		var secret corev1.Secret
+		ref := cfg.TSIGSecret
+		if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+			return fmt.Errorf("failed to fetch TSIG secret: %w", err)
+		}
+		tsigSecret, ok := secret.Data[ref.Key]
+		if !ok {
+			return fmt.Errorf("TSIG secret %s/%s is missing %s", ref.Namespace, ref.Name, ref.Key)
+		}
+		providerConfig.TSIGKey = cfg.TSIGKeyName
+		providerConfig.TSIGSecret = string(tsigSecret)
+	}
+
+	provider, err := rfc2136.NewDNSProviderConfig(providerConfig)
+	if err != nil {
+		return fmt.Errorf("failed to configure RFC2136 provider: %w", err)
+	}
+	return legoClient.Challenge.SetDNS01Provider(provider,
+		dns01.CondOption(len(cfg.Resolvers) > 0, dns01.AddRecursiveNameservers(cfg.Resolvers)))
+}
+
+// storeCertificate writes the issued certificate and account key to the TLS
+// secret, creating it if needed.
+func (s *acmeSource) storeCertificate(ctx context.Context, k8sClient client.Client, secret *corev1.Secret, resource *certificate.Resource, accountKeyPEM []byte) error {
+	data := map[string][]byte{
+		corev1.TLSCertKey:       resource.Certificate,
+		corev1.TLSPrivateKeyKey: resource.PrivateKey,
+		acmeAccountKey:          accountKeyPEM,
+	}
+
+	if secret.ResourceVersion == "" {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.store.Namespace, Name: s.store.Name},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		if err := k8sClient.Create(ctx, secret); err != nil {
+			return fmt.Errorf("failed to create ACME certificate secret: %w", err)
+		}
+		return nil
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range data {
+		secret.Data[k] = v
+	}
+	if err := k8sClient.Update(ctx, secret); err != nil {
+		return fmt.Errorf("failed to update ACME certificate secret: %w", err)
+	}
+	return nil
+}
+
+// loadOrCreateAccountKey parses a stored ACME account key or generates a new
+// one, returning the key and its PEM encoding.
+func loadOrCreateAccountKey(keyPEM []byte) (crypto.PrivateKey, []byte, error) {
+	if len(keyPEM) > 0 {
+		key, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse stored ACME account key: %w", err)
+		}
+		return key, keyPEM, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode ACME account key: %w", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// httpClientWithCA returns an HTTP client that also trusts the CA in caFile.
+func httpClientWithCA(caFile string) (*http.Client, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in CA file")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport, Timeout: 2 * time.Minute}, nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestACMERenewalReason(t *testing.T) {
+	now := time.Now()
+	source := newACMESource(ACMEConfig{Domains: []string{"unifi.example.com"}}, SecretReference{}, logrus.New())
+
+	current := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(60*24*time.Hour), nil)
+	expiring := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(10*24*time.Hour), nil)
+	otherHost := newTestCertificate(t, "other.example.com", []string{"other.example.com"}, now.Add(-time.Hour), now.Add(60*24*time.Hour), nil)
+
+	tests := []struct {
+		name     string
+		certPEM  string
+		expected string
+	}{
+		{name: "current", certPEM: current.certPEM},
+		{name: "none issued", certPEM: "", expected: "no certificate issued yet"},
+		{name: "within renewBefore", certPEM: expiring.certPEM, expected: "stored certificate expires at"},
+		{name: "wrong domain", certPEM: otherHost.certPEM, expected: "does not cover unifi.example.com"},
+		{name: "unreadable", certPEM: "garbage", expected: "unreadable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := source.renewalReason(tt.certPEM, now)
+			if tt.expected == "" {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateACMEConfig(t *testing.T) {
+	valid := ACMEConfig{
+		DirectoryURL: "https://acme.example.com/directory",
+		Domains:      []string{"unifi.example.com"},
+		HTTP01:       &HTTP01Config{},
+	}
+	assert.Empty(t, validateACMEConfig(&valid))
+
+	both := valid
+	both.DNS01 = &RFC2136Config{Nameserver: "ns.example.com:53"}
+	assert.Len(t, validateACMEConfig(&both), 1)
+
+	missing := ACMEConfig{KeyType: "ed25519"}
+	assert.Len(t, validateACMEConfig(&missing), 4)
+}
+
+// TestACMEIssuance runs against a local Pebble server, e.g.
+//
+//	pebble -config test/config/pebble-config.json -strict
+//	ACME_TEST_DIRECTORY_URL=https://localhost:14000/dir ACME_TEST_CA_FILE=test/certs/pebble.minica.pem go test -run TestACMEIssuance
+func TestACMEIssuance(t *testing.T) {
+	directoryURL := os.Getenv("ACME_TEST_DIRECTORY_URL")
+	if directoryURL == "" {
+		t.Skip("ACME_TEST_DIRECTORY_URL not set")
+	}
+	address := os.Getenv("ACME_TEST_HTTP01_ADDRESS")
+	if address == "" {
+		address = ":5002"
+	}
+
+	store := SecretReference{Namespace: "certs", Name: "unifi-tls"}
+	source := newACMESource(ACMEConfig{
+		DirectoryURL: directoryURL,
+		CAFile:       os.Getenv("ACME_TEST_CA_FILE"),
+		Domains:      []string{"unifi.example.com"},
+		HTTP01:       &HTTP01Config{Address: address},
+	}, store, logrus.New())
+	k8sClient := fake.NewClientBuilder().Build()
+	ctx := context.Background()
+
+	cert, key, err := source.Fetch(ctx, k8sClient)
+	require.NoError(t, err)
+	require.NoError(t, validateCertificate(cert, key, validationOptions{Now: time.Now(), Host: "unifi.example.com"}))
+
+	var secret corev1.Secret
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: store.Namespace, Name: store.Name}, &secret))
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Contains(t, string(secret.Data[acmeAccountKey]), "PRIVATE KEY")
+
+	// A current certificate is reused rather than issued again
+	again, _, err := source.Fetch(ctx, k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, cert, again)
+}
+
+func TestACMEFetchDryRun(t *testing.T) {
+	store := SecretReference{Namespace: "certs", Name: "unifi-tls"}
+	source := newACMESource(ACMEConfig{
+		DirectoryURL: "https://acme.example.com/directory",
+		Domains:      []string{"unifi.example.com"},
+		HTTP01:       &HTTP01Config{},
+	}, store, logrus.New())
+	k8sClient := fake.NewClientBuilder().Build()
+
+	cert, key, issue, err := source.FetchDryRun(context.Background(), k8sClient)
+	require.NoError(t, err)
+	assert.Empty(t, cert)
+	assert.Empty(t, key)
+	assert.Contains(t, issue, "no certificate issued yet")
+
+	var secret corev1.Secret
+	err = k8sClient.Get(context.Background(), client.ObjectKey{Namespace: store.Namespace, Name: store.Name}, &secret)
+	assert.True(t, apierrors.IsNotFound(err), "dry run must not create the secret")
+}
+
+// acmeStub is a minimal ACME server. It fetches the HTTP-01 key
+// authorization from the client's challenge server before issuing from its
+// own CA, but does not verify request signatures.
+type acmeStub struct {
+	t      *testing.T
+	server *httptest.Server
+	http01 string // Address the client serves HTTP-01 challenges on
+	ca     *testCertificate
+
+	mu       sync.Mutex
+	nonce    int
+	accounts map[string]string // JWK thumbprint -> account URL
+	orders   []*acmeStubOrder
+}
+
+type acmeStubOrder struct {
+	domain     string
+	token      string
+	thumbprint string
+	status     string // Of the order and its only authorization
+	cert       []byte
+}
+
+func newACMEStub(t *testing.T, http01 string) *acmeStub {
+	now := time.Now()
+	s := &acmeStub{
+		t:        t,
+		http01:   http01,
+		ca:       newTestCertificate(t, "ACME Stub CA", nil, now.Add(-time.Hour), now.Add(24*time.Hour), nil),
+		accounts: map[string]string{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *acmeStub) Orders() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.orders)
+}
+
+func (s *acmeStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", s.nonce))
+
+	base := s.server.URL
+	if r.URL.Path == "/directory" {
+		writeACMEJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	var header struct {
+		JWK map[string]string `json:"jwk"`
+		KID string            `json:"kid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil || decodeJWSPart(jws.Protected, &header) != nil {
+		writeACMEJSON(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var order *acmeStubOrder
+	index := -1
+	if len(path) > 1 {
+		if i, err := strconv.Atoi(path[1]); err == nil && i >= 0 && i < len(s.orders) {
+			order, index = s.orders[i], i
+		}
+	}
+
+	switch {
+	case path[0] == "account":
+		// Accounts are identified by their key, so a stored key finds its account again
+		thumbprint := jwkThumbprint(header.JWK)
+		url, ok := s.accounts[thumbprint]
+		status := http.StatusOK
+		if !ok {
+			url = fmt.Sprintf("%s/account/%s", base, thumbprint)
+			s.accounts[thumbprint] = url
+			status = http.StatusCreated
+		}
+		w.Header().Set("Location", url)
+		writeACMEJSON(w, status, map[string]string{"status": "valid"})
+	case path[0] == "order" && len(path) == 1:
+		var payload struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		_ = decodeJWSPart(jws.Payload, &payload)
+		order = &acmeStubOrder{
+			domain:     payload.Identifiers[0].Value,
+			token:      fmt.Sprintf("token-%d", len(s.orders)),
+			thumbprint: strings.TrimPrefix(header.KID, base+"/account/"),
+			status:     "pending",
+		}
+		s.orders = append(s.orders, order)
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", base, len(s.orders)-1))
+		writeACMEJSON(w, http.StatusCreated, s.orderJSON(len(s.orders)-1))
+	case order == nil:
+		writeACMEJSON(w, http.StatusNotFound, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+	case path[0] == "order" && len(path) == 2:
+		writeACMEJSON(w, http.StatusOK, s.orderJSON(index))
+	case path[0] == "authz":
+		writeACMEJSON(w, http.StatusOK, s.authzJSON(path[1], order))
+	case path[0] == "challenge":
+		if err := s.checkKeyAuthorization(order); err != nil {
+			writeACMEJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": err.Error()})
+			return
+		}
+		order.status = "ready"
+		writeACMEJSON(w, http.StatusOK, s.authzJSON(path[1], order)["challenges"].([]map[string]string)[0])
+	case path[0] == "order" && len(path) == 3 && path[2] == "finalize":
+		var payload struct {
+			CSR string `json:"csr"`
+		}
+		_ = decodeJWSPart(jws.Payload, &payload)
+		der, _ := base64.RawURLEncoding.DecodeString(payload.CSR)
+		order.cert = s.issue(der)
+		order.status = "valid"
+		writeACMEJSON(w, http.StatusOK, s.orderJSON(index))
+	case path[0] == "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(order.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *acmeStub) orderJSON(i int) map[string]any {
+	order := s.orders[i]
+	result := map[string]any{
+		"status":         order.status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": order.domain}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", s.server.URL, i)},
+		"finalize":       fmt.Sprintf("%s/order/%d/finalize", s.server.URL, i),
+	}
+	if order.status == "valid" {
+		result["certificate"] = fmt.Sprintf("%s/cert/%d", s.server.URL, i)
+	}
+	return result
+}
+
+func (s *acmeStub) authzJSON(id string, order *acmeStubOrder) map[string]any {
+	status := "valid"
+	if order.status == "pending" {
+		status = "pending"
+	}
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": order.domain},
+		"challenges": []map[string]string{{
+			"type":   "http-01",
+			"url":    fmt.Sprintf("%s/challenge/%s", s.server.URL, id),
+			"token":  order.token,
+			"status": status,
+		}},
+	}
+}
+
+// checkKeyAuthorization fetches the challenge response the way a CA would,
+// but from the client's challenge server rather than the domain.
+func (s *acmeStub) checkKeyAuthorization(order *acmeStubOrder) error {
+	req, err := http.NewRequest(http.MethodGet, "http://"+s.http01+"/.well-known/acme-challenge/"+order.token, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = order.domain
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if expected := order.token + "." + order.thumbprint; string(body) != expected {
+		return fmt.Errorf("key authorization is %q, expected %q", body, expected)
+	}
+	return nil
+}
+
+// issue signs the CSR with the stub's CA and returns the chain.
+func (s *acmeStub) issue(csrDER []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	require.NoError(s.t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.ca.cert, csr.PublicKey, s.ca.key)
+	require.NoError(s.t, err)
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), s.ca.certPEM...)
+}
+
+func writeACMEJSON(w http.ResponseWriter, status int, v any) {
+	contentType := "application/json"
+	if status >= 400 {
+		contentType = "application/problem+json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func decodeJWSPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint of an EC public key.
+func jwkThumbprint(jwk map[string]string) string {
+	canonical := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestACMEIssuanceStub(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	http01 := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	stub := newACMEStub(t, http01)
+
+	store := SecretReference{Namespace: "certs", Name: "unifi-tls"}
+	source := newACMESource(ACMEConfig{
+		DirectoryURL: stub.server.URL + "/directory",
+		Domains:      []string{"unifi.example.com"},
+		HTTP01:       &HTTP01Config{Address: http01},
+	}, store, logrus.New())
+	k8sClient := fake.NewClientBuilder().Build()
+	ctx := context.Background()
+
+	cert, key, err := source.Fetch(ctx, k8sClient)
+	require.NoError(t, err)
+	require.NoError(t, validateCertificate(cert, key, validationOptions{Now: time.Now(), Host: "unifi.example.com"}))
+	chain, err := parseCertificateChain(cert)
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+	assert.Equal(t, "ACME Stub CA", chain[1].Subject.CommonName)
+
+	var secret corev1.Secret
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: store.Namespace, Name: store.Name}, &secret))
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	accountKey := secret.Data[acmeAccountKey]
+	assert.Contains(t, string(accountKey), "PRIVATE KEY")
+
+	// A current certificate is reused rather than ordered again
+	again, _, err := source.Fetch(ctx, k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, cert, again)
+	assert.Equal(t, 1, stub.Orders())
+
+	// Within renewBefore a new one is ordered with the stored account
+	source.config.RenewBefore.Duration = 365 * 24 * time.Hour
+	renewed, _, err := source.Fetch(ctx, k8sClient)
+	require.NoError(t, err)
+	assert.NotEqual(t, cert, renewed)
+	assert.Equal(t, 2, stub.Orders())
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: store.Namespace, Name: store.Name}, &secret))
+	assert.Equal(t, accountKey, secret.Data[acmeAccountKey])
+	assert.Equal(t, renewed, string(secret.Data[corev1.TLSCertKey]))
+}
@@ -0,0 +1,327 @@
+// Command wifi-passphrase rotates the passphrase of guest WLANs. It generates
+// a new passphrase, applies it to the configured SSIDs and publishes it, with
+// a QR code payload for joining the first SSID, to a Kubernetes secret and/or
+// a file for the lobby display. It is meant to run on a schedule, e.g. as a
+// weekly CronJob.
+//
+// It is configured through the environment:
+//
+//	UNIFI_API_URL, UNIFI_USERNAME, UNIFI_PASSWORD  Console and credentials
+//	UNIFI_TOTP_SECRET                              For accounts with two-factor authentication
+//	UNIFI_SITE                                     Site of the WLANs, defaults to "default"
+//	WLAN_SSIDS                                     Comma-separated SSIDs to rotate
+//	PASSPHRASE_LENGTH                              Characters in the passphrase, defaults to 16
+//	SECRET_NAME, NAMESPACE                         Secret to publish to
+//	OUTPUT_FILE                                    JSON file to publish to
+//
+// The secret gets the keys ssid, passphrase, qr and rotated-at.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Config holds the command's configuration, read from the environment.
+type Config struct {
+	UniFiAPIURL      string
+	Username         string
+	Password         string
+	TOTPSecret       string
+	Site             string
+	SSIDs            []string // The first one is published with the QR code
+	PassphraseLength int      // Characters, not counting the dashes between groups
+	Namespace        string
+	SecretName       string
+	OutputFile       string
+}
+
+// Rotation is what gets published for the lobby display.
+type Rotation struct {
+	SSID       string    `json:"ssid"`
+	Passphrase string    `json:"passphrase"`
+	QRCode     string    `json:"qr"` // WIFI: payload to render as a QR code
+	RotatedAt  time.Time `json:"rotatedAt"`
+}
+
+func main() {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	if level, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL")); err == nil {
+		logger.SetLevel(level)
+	}
+
+	config, err := configFromEnv()
+	if err != nil {
+		logger.Fatalf("Error reading configuration: %v", err)
+	}
+	if missing := validateConfig(config); len(missing) > 0 {
+		logger.Fatalf("Missing required environment variables: %s", missing)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var opts []unifi.ClientOption
+	if config.TOTPSecret != "" {
+		opts = append(opts, unifi.WithTOTPSecret(config.TOTPSecret))
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	unifiClient, err := unifi.NewClient(config.UniFiAPIURL, config.Username, config.Password, httpClient, opts...)
+	if err != nil {
+		logger.Fatalf("Error creating UniFi client: %v", err)
+	}
+
+	var k8sClient client.Client
+	if config.SecretName != "" {
+		scheme := runtime.NewScheme()
+		if err := corev1.AddToScheme(scheme); err != nil {
+			logger.Fatalf("Error creating Kubernetes scheme: %v", err)
+		}
+		if k8sClient, err = client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme}); err != nil {
+			logger.Fatalf("Error creating Kubernetes client: %v", err)
+		}
+	}
+
+	if _, err := rotate(ctx, config, unifiClient, k8sClient, logger); err != nil {
+		logger.Fatalf("Error rotating passphrase: %v", err)
+	}
+	logger.Infof("Rotated the passphrase of %s.", strings.Join(config.SSIDs, ", "))
+}
+
+func configFromEnv() (Config, error) {
+	config := Config{
+		UniFiAPIURL: os.Getenv("UNIFI_API_URL"),
+		Username:    os.Getenv("UNIFI_USERNAME"),
+		Password:    os.Getenv("UNIFI_PASSWORD"),
+		TOTPSecret:  os.Getenv("UNIFI_TOTP_SECRET"),
+		Namespace:   os.Getenv("NAMESPACE"),
+		SecretName:  os.Getenv("SECRET_NAME"),
+		OutputFile:  os.Getenv("OUTPUT_FILE"),
+	}
+	if config.Site = os.Getenv("UNIFI_SITE"); config.Site == "" {
+		config.Site = "default"
+	}
+	for _, ssid := range strings.Split(os.Getenv("WLAN_SSIDS"), ",") {
+		if ssid = strings.TrimSpace(ssid); ssid != "" {
+			config.SSIDs = append(config.SSIDs, ssid)
+		}
+	}
+	config.PassphraseLength = 16
+	if length := os.Getenv("PASSPHRASE_LENGTH"); length != "" {
+		var err error
+		if config.PassphraseLength, err = strconv.Atoi(length); err != nil {
+			return Config{}, fmt.Errorf("PASSPHRASE_LENGTH must be a number, got %q", length)
+		}
+	}
+	return config, nil
+}
+
+func validateConfig(config Config) []string {
+	missing := []string{}
+	if config.UniFiAPIURL == "" {
+		missing = append(missing, "UNIFI_API_URL")
+	}
+	if config.Username == "" {
+		missing = append(missing, "UNIFI_USERNAME")
+	}
+	if config.Password == "" {
+		missing = append(missing, "UNIFI_PASSWORD")
+	}
+	if len(config.SSIDs) == 0 {
+		missing = append(missing, "WLAN_SSIDS")
+	}
+	// Without somewhere to publish it, nobody would know the new passphrase
+	if config.SecretName == "" && config.OutputFile == "" {
+		missing = append(missing, "SECRET_NAME or OUTPUT_FILE")
+	}
+	if config.SecretName != "" && config.Namespace == "" {
+		missing = append(missing, "NAMESPACE")
+	}
+	return missing
+}
+
+// rotate applies a new passphrase to every configured SSID and publishes it.
+// All SSIDs are looked up before any is changed, so that a typo does not
+// leave a half-done rotation. If only some SSIDs could be changed, the
+// passphrase is still published, since the first ones already use it.
+func rotate(ctx context.Context, config Config, unifiClient *unifi.UniFiClient, k8sClient client.Client, logger *logrus.Logger) (*Rotation, error) {
+	passphrase, err := generatePassphrase(config.PassphraseLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+
+	if err := unifiClient.Login(ctx); err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	defer func() {
+		if err := unifiClient.Logout(context.WithoutCancel(ctx)); err != nil {
+			logger.WithError(err).Warn("Failed to log out.")
+		}
+	}()
+
+	wlans, err := unifiClient.ListWLANs(ctx, config.Site)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WLANs: %w", err)
+	}
+	targets := make([]unifi.WLAN, 0, len(config.SSIDs))
+	for _, ssid := range config.SSIDs {
+		i := slices.IndexFunc(wlans, func(w unifi.WLAN) bool { return w.Name == ssid })
+		if i < 0 {
+			return nil, fmt.Errorf("SSID %q not found on site %s", ssid, config.Site)
+		}
+		if wlans[i].Security != unifi.WLANSecurityWPAPSK {
+			return nil, fmt.Errorf("SSID %q does not use a passphrase (security %q)", ssid, wlans[i].Security)
+		}
+		targets = append(targets, wlans[i])
+	}
+
+	var applyErr error
+	applied := 0
+	for _, wlan := range targets {
+		if err := unifiClient.SetWLANPassphrase(ctx, config.Site, wlan.ID, passphrase); err != nil {
+			applyErr = fmt.Errorf("failed to set the passphrase of %q: %w", wlan.Name, err)
+			break
+		}
+		logger.Infof("Set a new passphrase on %q.", wlan.Name)
+		applied++
+	}
+	if applied == 0 {
+		return nil, applyErr
+	}
+
+	rotation := &Rotation{
+		SSID:       targets[0].Name,
+		Passphrase: passphrase,
+		QRCode:     unifi.WiFiQRPayload(targets[0].Name, passphrase, targets[0].HideSSID),
+		RotatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	var publishErrs []error
+	if config.SecretName != "" {
+		if err := writeSecret(ctx, k8sClient, config.Namespace, config.SecretName, rotation); err != nil {
+			publishErrs = append(publishErrs, err)
+		}
+	}
+	if config.OutputFile != "" {
+		if err := writeFile(config.OutputFile, rotation); err != nil {
+			publishErrs = append(publishErrs, err)
+		}
+	}
+	return rotation, errors.Join(append([]error{applyErr}, publishErrs...)...)
+}
+
+// passphraseAlphabet leaves out characters that are easily confused when read
+// off a screen, such as 0/o and 1/l.
+const passphraseAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// generatePassphrase returns length random characters in dash-separated groups
+// of four, e.g. "k7dm-xq2p-9hta-m4wc".
+func generatePassphrase(length int) (string, error) {
+	if length < 8 || length+(length-1)/4 > 63 {
+		return "", fmt.Errorf("passphrase length must be between 8 and 51, got %d", length)
+	}
+	var b strings.Builder
+	for i := range length {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passphraseAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(passphraseAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// writeSecret stores the rotation in a secret, creating it if needed.
+func writeSecret(ctx context.Context, k8sClient client.Client, namespace, name string, rotation *Rotation) error {
+	data := map[string][]byte{
+		"ssid":       []byte(rotation.SSID),
+		"passphrase": []byte(rotation.Passphrase),
+		"qr":         []byte(rotation.QRCode),
+		"rotated-at": []byte(rotation.RotatedAt.Format(time.RFC3339)),
+	}
+
+	var secret corev1.Secret
+	err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secret)
+	if apierrors.IsNotFound(err) {
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+		if err := k8sClient.Create(ctx, &secret); err != nil {
+			return fmt.Errorf("failed to create passphrase secret: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch passphrase secret: %w", err)
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range data {
+		secret.Data[k] = v
+	}
+	if err := k8sClient.Update(ctx, &secret); err != nil {
+		return fmt.Errorf("failed to update passphrase secret: %w", err)
+	}
+	return nil
+}
+
+// writeFile replaces the file with the rotation as JSON. The passphrase is
+// meant to be shown to guests, so the file is world-readable.
+func writeFile(path string, rotation *Rotation) error {
+	data, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode passphrase file: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".wifi-passphrase-*")
+	if err != nil {
+		return fmt.Errorf("failed to create passphrase file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write passphrase file: %w", err)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write passphrase file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write passphrase file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace passphrase file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClient(t *testing.T, server *unifitest.Server) *unifi.UniFiClient {
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client())
+	require.NoError(t, err)
+	return client
+}
+
+func TestRotate(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	server.AddWLAN("default", unifi.WLAN{Name: "Lobby", Enabled: true, Security: unifi.WLANSecurityWPAPSK, Passphrase: "old-passphrase", IsGuest: true})
+	server.AddWLAN("default", unifi.WLAN{Name: "Office", Enabled: true, Security: unifi.WLANSecurityWPAPSK, Passphrase: "office-passphrase"})
+	server.AddWLAN("default", unifi.WLAN{Name: "Lobby 5G", Enabled: true, Security: unifi.WLANSecurityWPAPSK, Passphrase: "old-passphrase", IsGuest: true})
+	k8sClient := fake.NewClientBuilder().Build()
+	ctx := context.Background()
+
+	config := Config{
+		Site:             "default",
+		SSIDs:            []string{"Lobby", "Lobby 5G"},
+		PassphraseLength: 16,
+		Namespace:        "wifi",
+		SecretName:       "guest-wifi",
+		OutputFile:       filepath.Join(t.TempDir(), "guest-wifi.json"),
+	}
+	rotation, err := rotate(ctx, config, newTestClient(t, server), k8sClient, logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, "Lobby", rotation.SSID)
+	assert.Equal(t, "WIFI:T:WPA;S:Lobby;P:"+rotation.Passphrase+";;", rotation.QRCode)
+
+	wlans := server.WLANs("default")
+	assert.Equal(t, rotation.Passphrase, wlans[0].Passphrase)
+	assert.Equal(t, "office-passphrase", wlans[1].Passphrase)
+	assert.Equal(t, rotation.Passphrase, wlans[2].Passphrase)
+	assert.Zero(t, server.Sessions(), "expected to log out")
+
+	var secret corev1.Secret
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: "wifi", Name: "guest-wifi"}, &secret))
+	assert.Equal(t, rotation.Passphrase, string(secret.Data["passphrase"]))
+	assert.Equal(t, rotation.QRCode, string(secret.Data["qr"]))
+
+	data, err := os.ReadFile(config.OutputFile)
+	require.NoError(t, err)
+	var published Rotation
+	require.NoError(t, json.Unmarshal(data, &published))
+	assert.Equal(t, *rotation, published)
+
+	// The next rotation updates the secret in place
+	next, err := rotate(ctx, config, newTestClient(t, server), k8sClient, logrus.New())
+	require.NoError(t, err)
+	assert.NotEqual(t, rotation.Passphrase, next.Passphrase)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: "wifi", Name: "guest-wifi"}, &secret))
+	assert.Equal(t, next.Passphrase, string(secret.Data["passphrase"]))
+}
+
+func TestRotateChecksEverySSIDFirst(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	server.AddWLAN("default", unifi.WLAN{Name: "Lobby", Security: unifi.WLANSecurityWPAPSK, Passphrase: "old-passphrase"})
+	server.AddWLAN("default", unifi.WLAN{Name: "Staff", Security: unifi.WLANSecurityWPAEAP})
+
+	config := Config{Site: "default", PassphraseLength: 16, OutputFile: filepath.Join(t.TempDir(), "guest-wifi.json")}
+	for ssids, want := range map[[2]string]string{
+		{"Lobby", "Loby"}:  `SSID "Loby" not found on site default`,
+		{"Lobby", "Staff"}: `SSID "Staff" does not use a passphrase (security "wpaeap")`,
+	} {
+		config.SSIDs = ssids[:]
+		_, err := rotate(context.Background(), config, newTestClient(t, server), nil, logrus.New())
+		assert.EqualError(t, err, want)
+	}
+	assert.Equal(t, "old-passphrase", server.WLANs("default")[0].Passphrase)
+	assert.NoFileExists(t, config.OutputFile)
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	passphrase, err := generatePassphrase(16)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-km-z2-9]{4}(-[a-km-z2-9]{4}){3}$`), passphrase)
+
+	passphrase, err = generatePassphrase(51)
+	require.NoError(t, err)
+	assert.Len(t, passphrase, 63)
+
+	_, err = generatePassphrase(7)
+	assert.EqualError(t, err, "passphrase length must be between 8 and 51, got 7")
+	_, err = generatePassphrase(52)
+	assert.Error(t, err)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("WLAN_SSIDS", "Lobby, Guests,")
+	config, err := configFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Lobby", "Guests"}, config.SSIDs)
+	assert.Equal(t, "default", config.Site)
+	assert.Equal(t, 16, config.PassphraseLength)
+
+	t.Setenv("PASSPHRASE_LENGTH", "20")
+	config, err = configFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 20, config.PassphraseLength)
+
+	t.Setenv("PASSPHRASE_LENGTH", "twenty")
+	_, err = configFromEnv()
+	assert.EqualError(t, err, `PASSPHRASE_LENGTH must be a number, got "twenty"`)
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.Equal(t, []string{"UNIFI_API_URL", "UNIFI_USERNAME", "UNIFI_PASSWORD", "WLAN_SSIDS", "SECRET_NAME or OUTPUT_FILE"}, validateConfig(Config{}))
+	assert.Empty(t, validateConfig(Config{UniFiAPIURL: "https://unifi", Username: "admin", Password: "secret", SSIDs: []string{"Lobby"}, OutputFile: "/data/wifi.json"}))
+	assert.Equal(t, []string{"NAMESPACE"}, validateConfig(Config{UniFiAPIURL: "https://unifi", Username: "admin", Password: "secret", SSIDs: []string{"Lobby"}, SecretName: "guest-wifi"}))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// SecretReference points at a Kubernetes secret. An empty namespace defaults
+// to the NAMESPACE environment variable.
+type SecretReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// CredentialsSecretReference points at a secret holding UniFi credentials.
+type CredentialsSecretReference struct {
+	SecretReference
+	UsernameKey string `json:"usernameKey,omitempty"` // Defaults to "username"
+	PasswordKey string `json:"passwordKey,omitempty"` // Defaults to "password"
+	// Optional key holding the base32 TOTP secret of an account with
+	// two-factor authentication enabled
+	TOTPSecretKey string `json:"totpSecretKey,omitempty"`
+}
+
+// ConsoleConfig describes a single UniFi console and the certificate that
+// should be installed on it. Sync status is recorded on tlsSecret if set.
+type ConsoleConfig struct {
+	Name              string                      `json:"name"`
+	URL               string                      `json:"url"`
+	CredentialsSecret *CredentialsSecretReference `json:"credentialsSecret,omitempty"`
+	TLSSecret         SecretReference             `json:"tlsSecret"`
+	MaxCerts          int                         `json:"maxCerts,omitempty"`
+	VerifyHostname    bool                        `json:"verifyHostname,omitempty"` // Require a SAN matching the URL host
+	ActivationTimeout metav1.Duration             `json:"activationTimeout,omitempty"`
+	Retention         RetentionPolicy             `json:"retention,omitempty"`
+	Source            SourceConfig                `json:"source,omitempty"`
+	ACME              *ACMEConfig                 `json:"acme,omitempty"` // Issue the certificate instead of reading it from tlsSecret
+	SessionCache      *SessionCacheConfig         `json:"sessionCache,omitempty"`
+
+	// Inline credentials are only populated from environment variables
+	Username   string `json:"-"`
+	Password   string `json:"-"`
+	TOTPSecret string `json:"-"`
+}
+
+// fileConfig is the on-disk layout of CONFIG_FILE.
+type fileConfig struct {
+	Consoles []ConsoleConfig `json:"consoles"`
+}
+
+// loadConsolesFromFile reads the console definitions from a YAML or JSON file
+// and applies defaults from the environment-level configuration.
+func loadConsolesFromFile(path string, config Config) ([]ConsoleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var fc fileConfig
+	if err := yaml.UnmarshalStrict(data, &fc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for i := range fc.Consoles {
+		applyConsoleDefaults(&fc.Consoles[i], config)
+	}
+
+	if err := validateConsoles(fc.Consoles); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return fc.Consoles, nil
+}
+
+// consoleFromEnv builds the single console described by the legacy
+// UNIFI_API_URL/UNIFI_USERNAME/UNIFI_PASSWORD/SECRET_NAME variables, or by
+// CERT_DIRECTORY or PKCS12_FILE when running outside Kubernetes.
+func consoleFromEnv(config Config) ConsoleConfig {
+	console := ConsoleConfig{
+		Name:       "default",
+		URL:        config.UniFiAPIURL,
+		Username:   config.Username,
+		Password:   config.Password,
+		TOTPSecret: config.TOTPSecret,
+		TLSSecret:  SecretReference{Name: config.SecretName},
+	}
+	switch {
+	case config.CertDirectory != "":
+		console.Source.Directory = &DirectorySourceConfig{Path: config.CertDirectory}
+	case config.PKCS12File != "":
+		console.Source.PKCS12 = &PKCS12SourceConfig{Path: config.PKCS12File, PasswordFile: config.PKCS12PasswordFile}
+	}
+	switch {
+	case config.SessionCacheFile != "":
+		console.SessionCache = &SessionCacheConfig{File: config.SessionCacheFile}
+	case config.SessionCacheSecret != "":
+		console.SessionCache = &SessionCacheConfig{Secret: &SecretKeyReference{SecretReference: SecretReference{Name: config.SessionCacheSecret}}}
+	}
+	applyConsoleDefaults(&console, config)
+	return console
+}
+
+func applyConsoleDefaults(console *ConsoleConfig, config Config) {
+	if console.TLSSecret.Name != "" && console.TLSSecret.Namespace == "" {
+		console.TLSSecret.Namespace = config.Namespace
+	}
+	if console.MaxCerts == 0 {
+		console.MaxCerts = config.MaxCerts
+	}
+	if config.VerifyHostname {
+		console.VerifyHostname = true
+	}
+	if console.ActivationTimeout.Duration == 0 {
+		console.ActivationTimeout.Duration = config.ActivationTimeout
+	}
+	console.Retention = mergeRetentionPolicy(console.Retention, config.Retention)
+	if cm := console.Source.CertManager; cm != nil && cm.Namespace == "" {
+		cm.Namespace = config.Namespace
+	}
+	if p12 := console.Source.PKCS12; p12 != nil && p12.PasswordSecret != nil && p12.PasswordSecret.Namespace == "" {
+		p12.PasswordSecret.Namespace = config.Namespace
+	}
+	if acme := console.ACME; acme != nil && acme.DNS01 != nil && acme.DNS01.TSIGSecret != nil && acme.DNS01.TSIGSecret.Namespace == "" {
+		acme.DNS01.TSIGSecret.Namespace = config.Namespace
+	}
+	if cache := console.SessionCache; cache != nil && cache.Secret != nil {
+		if cache.Secret.Namespace == "" {
+			cache.Secret.Namespace = config.Namespace
+		}
+		if cache.Secret.Key == "" {
+			cache.Secret.Key = "session-" + console.Name
+		}
+	}
+	if creds := console.CredentialsSecret; creds != nil {
+		if creds.Namespace == "" {
+			creds.Namespace = config.Namespace
+		}
+		if creds.UsernameKey == "" {
+			creds.UsernameKey = "username"
+		}
+		if creds.PasswordKey == "" {
+			creds.PasswordKey = "password"
+		}
+	}
+}
+
+func validateConsoles(consoles []ConsoleConfig) error {
+	if len(consoles) == 0 {
+		return fmt.Errorf("no consoles configured")
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	sessionCaches := map[string]string{} // Cache location -> console name
+	tlsSecrets := map[SecretReference]string{}
+	for _, console := range consoles {
+		if console.TLSSecret.Name != "" {
+			tlsSecrets[console.TLSSecret] = console.Name
+		}
+	}
+	for i, console := range consoles {
+		if console.Name == "" {
+			errs = append(errs, fmt.Errorf("console %d: name is required", i))
+			continue
+		}
+		if seen[console.Name] {
+			errs = append(errs, fmt.Errorf("console %s: duplicate name", console.Name))
+		}
+		seen[console.Name] = true
+		for _, msg := range validation.IsQualifiedName(statusAnnotationKey(console.Name, "certificate-id")) {
+			errs = append(errs, fmt.Errorf("console %s: name cannot be used in annotations: %s", console.Name, msg))
+		}
+
+		if console.URL == "" {
+			errs = append(errs, fmt.Errorf("console %s: url is required", console.Name))
+		}
+		if (console.TLSSecret.Name == "" && console.readsTLSSecret()) || (console.TLSSecret.Name != "" && console.TLSSecret.Namespace == "") {
+			errs = append(errs, fmt.Errorf("console %s: tlsSecret name and namespace are required", console.Name))
+		}
+		if console.CredentialsSecret == nil && (console.Username == "" || console.Password == "") {
+			errs = append(errs, fmt.Errorf("console %s: credentialsSecret is required", console.Name))
+		}
+		if pattern := console.Retention.ProtectNamePattern; pattern != "" {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("console %s: invalid retention.protectNamePattern: %w", console.Name, err))
+			}
+		}
+		if creds := console.CredentialsSecret; creds != nil && (creds.Name == "" || creds.Namespace == "") {
+			errs = append(errs, fmt.Errorf("console %s: credentialsSecret name and namespace are required", console.Name))
+		}
+		if cache := console.SessionCache; cache != nil {
+			if (cache.File == "") == (cache.Secret == nil) {
+				errs = append(errs, fmt.Errorf("console %s: exactly one of sessionCache.file and sessionCache.secret is required", console.Name))
+			} else if cache.Secret != nil && (cache.Secret.Name == "" || cache.Secret.Namespace == "") {
+				errs = append(errs, fmt.Errorf("console %s: sessionCache.secret name and namespace are required", console.Name))
+			}
+			// Two consoles sharing a cache would keep replacing each other's session
+			location := cache.File
+			if cache.Secret != nil {
+				location = fmt.Sprintf("secret %s/%s key %s", cache.Secret.Namespace, cache.Secret.Name, cache.Secret.Key)
+			}
+			if other, ok := sessionCaches[location]; ok {
+				errs = append(errs, fmt.Errorf("console %s: sessionCache is already used by console %s", console.Name, other))
+			}
+			sessionCaches[location] = console.Name
+			// Every saved session would change the secret's data and trigger
+			// another sync
+			if cache.Secret != nil {
+				if other, ok := tlsSecrets[cache.Secret.SecretReference]; ok {
+					errs = append(errs, fmt.Errorf("console %s: sessionCache.secret cannot be the tlsSecret of console %s", console.Name, other))
+				}
+			}
+		}
+		sourceErrs := validateSourceConfig(console.Source)
+		if console.ACME != nil {
+			sourceErrs = append(sourceErrs, validateACMEConfig(console.ACME)...)
+			if console.Source != (SourceConfig{}) {
+				sourceErrs = append(sourceErrs, fmt.Errorf("acme cannot be combined with source"))
+			}
+		}
+		for _, err := range sourceErrs {
+			errs = append(errs, fmt.Errorf("console %s: %w", console.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readsTLSSecret reports whether the console's certificate is read from, or
+// stored in, tlsSecret.
+func (c ConsoleConfig) readsTLSSecret() bool {
+	return c.ACME != nil || (c.Source.Directory == nil && c.Source.PKCS12 == nil && c.Source.CertManager == nil)
+}
+
+// usesKubernetes reports whether the console needs the Kubernetes API for its
+// certificate, credentials, session cache or status. Consoles that do not can
+// be synced from a plain host.
+func (c ConsoleConfig) usesKubernetes() bool {
+	return c.TLSSecret.Name != "" || c.CredentialsSecret != nil || c.readsTLSSecret() ||
+		c.Source.CertManager != nil || (c.Source.PKCS12 != nil && c.Source.PKCS12.PasswordSecret != nil) ||
+		(c.SessionCache != nil && c.SessionCache.Secret != nil)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConsolesFromFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileContent    string
+		expectedError  string
+		expectedResult []ConsoleConfig
+	}{
+		{
+			name: "defaults applied",
+			fileContent: `
+consoles:
+  - name: office
+    url: https://office.example.com
+    credentialsSecret:
+      name: office-credentials
+    tlsSecret:
+      name: office-tls
+  - name: home
+    url: https://home.example.com
+    credentialsSecret:
+      namespace: other
+      name: home-credentials
+      usernameKey: user
+      passwordKey: pass
+    tlsSecret:
+      namespace: other
+      name: home-tls
+    maxCerts: 2
+`,
+			expectedResult: []ConsoleConfig{
+				{
+					Name: "office",
+					URL:  "https://office.example.com",
+					CredentialsSecret: &CredentialsSecretReference{
+						SecretReference: SecretReference{Namespace: "certs", Name: "office-credentials"},
+						UsernameKey:     "username",
+						PasswordKey:     "password",
+					},
+					TLSSecret: SecretReference{Namespace: "certs", Name: "office-tls"},
+					MaxCerts:  5,
+				},
+				{
+					Name: "home",
+					URL:  "https://home.example.com",
+					CredentialsSecret: &CredentialsSecretReference{
+						SecretReference: SecretReference{Namespace: "other", Name: "home-credentials"},
+						UsernameKey:     "user",
+						PasswordKey:     "pass",
+					},
+					TLSSecret: SecretReference{Namespace: "other", Name: "home-tls"},
+					MaxCerts:  2,
+				},
+			},
+		},
+		{
+			name: "duplicate and incomplete consoles",
+			fileContent: `
+consoles:
+  - name: office
+    url: https://office.example.com
+    tlsSecret:
+      name: office-tls
+  - name: office
+    credentialsSecret:
+      name: office-credentials
+    tlsSecret:
+      name: office-tls
+`,
+			expectedError: "invalid config file %s: console office: credentialsSecret is required\n" +
+				"console office: duplicate name\n" +
+				"console office: url is required",
+		},
+		{
+			name: "shared session cache",
+			fileContent: `
+consoles:
+  - name: office
+    url: https://office.example.com
+    credentialsSecret:
+      name: office-credentials
+    tlsSecret:
+      name: office-tls
+    sessionCache:
+      secret:
+        name: unifi-sessions
+        key: session
+  - name: home
+    url: https://home.example.com
+    credentialsSecret:
+      name: home-credentials
+    tlsSecret:
+      name: home-tls
+    sessionCache:
+      secret:
+        namespace: certs
+        name: unifi-sessions
+        key: session
+  - name: lab
+    url: https://lab.example.com
+    credentialsSecret:
+      name: lab-credentials
+    tlsSecret:
+      name: lab-tls
+    sessionCache:
+      file: /var/cache/unifi/session.json
+      secret:
+        name: unifi-sessions
+  - name: garage
+    url: https://garage.example.com
+    credentialsSecret:
+      name: garage-credentials
+    tlsSecret:
+      name: garage-tls
+    sessionCache:
+      secret:
+        name: office-tls
+        key: session
+`,
+			expectedError: "invalid config file %s: console home: sessionCache is already used by console office\n" +
+				"console lab: exactly one of sessionCache.file and sessionCache.secret is required\n" +
+				"console garage: sessionCache.secret cannot be the tlsSecret of console office",
+		},
+		{
+			name:          "unknown field",
+			fileContent:   "consoles:\n  - name: office\n    maxcert: 2\n",
+			expectedError: `failed to parse config file %s: error unmarshaling JSON: while decoding JSON: json: unknown field "maxcert"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.fileContent), 0o600))
+
+			result, err := loadConsolesFromFile(path, Config{Namespace: "certs", MaxCerts: 5})
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, fmt.Sprintf(tt.expectedError, path))
+			}
+
+			assert.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestConsoleFromEnv(t *testing.T) {
+	config := Config{
+		UniFiAPIURL: "https://unifi.example.com",
+		Username:    "admin",
+		Password:    "secret",
+		Namespace:   "certs",
+		SecretName:  "unifi-tls",
+		MaxCerts:    3,
+	}
+
+	console := consoleFromEnv(config)
+	assert.NoError(t, validateConsoles([]ConsoleConfig{console}))
+	assert.Equal(t, ConsoleConfig{
+		Name:      "default",
+		URL:       "https://unifi.example.com",
+		Username:  "admin",
+		Password:  "secret",
+		TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"},
+		MaxCerts:  3,
+	}, console)
+
+	config.SessionCacheSecret = "unifi-sessions"
+	console = consoleFromEnv(config)
+	assert.NoError(t, validateConsoles([]ConsoleConfig{console}))
+	assert.Equal(t, &SessionCacheConfig{
+		Secret: &SecretKeyReference{SecretReference: SecretReference{Namespace: "certs", Name: "unifi-sessions"}, Key: "session-default"},
+	}, console.SessionCache)
+}
+
+func TestConsoleFromEnvOutsideKubernetes(t *testing.T) {
+	config := Config{
+		UniFiAPIURL:   "https://unifi.example.com",
+		Username:      "admin",
+		Password:      "secret",
+		CertDirectory: "/etc/letsencrypt/live/unifi.example.com",
+		MaxCerts:      3,
+	}
+	assert.Empty(t, validateEnvVars(config))
+
+	console := consoleFromEnv(config)
+	assert.NoError(t, validateConsoles([]ConsoleConfig{console}))
+	assert.Equal(t, &DirectorySourceConfig{Path: "/etc/letsencrypt/live/unifi.example.com"}, console.Source.Directory)
+	assert.False(t, console.usesKubernetes())
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, validateConfig(Config{PlanFormat: PlanFormatText}))
+	assert.NoError(t, validateConfig(Config{PlanFormat: PlanFormatJSON}))
+
+	// Formats are case-sensitive, and nothing falls back to text
+	for _, format := range []string{"yaml", "JSON"} {
+		assert.EqualError(t, validateConfig(Config{PlanFormat: format}), `unknown PLAN_FORMAT "`+format+`", expected "text" or "json"`)
+	}
+}
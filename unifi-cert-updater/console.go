@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Stages of a console sync, used to label failures
+const (
+	StageLogin    = "login"
+	StageFetch    = "fetch"
+	StageValidate = "validate"
+	StageUpload   = "upload"
+	StageActivate = "activate"
+	StagePrune    = "prune"
+)
+
+// rollbackTimeout bounds the reactivation of the previous certificate, which
+// is not cancelled along with the sync.
+const rollbackTimeout = 30 * time.Second
+
+// Bounds of the exponential backoff before a certificate that failed the
+// activation check is activated again.
+var (
+	rejectedMinBackoff = 15 * time.Minute
+	rejectedMaxBackoff = 24 * time.Hour
+)
+
+// syncError records the stage a console sync failed in.
+type syncError struct {
+	Stage string
+	Err   error
+}
+
+func (e *syncError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *syncError) Unwrap() error {
+	return e.Err
+}
+
+// ConsoleStatus records the outcome of the most recent reconcile of a console.
+type ConsoleStatus struct {
+	LastAttempt   time.Time
+	LastSuccess   time.Time
+	CertificateID string
+	Fingerprint   string
+	// Changed is set when the reconcile uploaded or activated a certificate
+	Changed bool
+	Err     error
+}
+
+// console pairs a console configuration with its own UniFi client and status,
+// so that each console is logged in to and reconciled independently.
+type console struct {
+	config ConsoleConfig
+	client *unifi.UniFiClient
+	source CertificateSource
+	logger *logrus.Entry
+
+	// Session cache, if configured. A secret cache gets its Kubernetes client
+	// on login, since consoles are created before the client.
+	sessions unifi.SessionStore
+
+	mu       sync.Mutex
+	loggedIn bool
+	status   ConsoleStatus
+	rejected rejectedCertificate
+}
+
+// rejectedCertificate is the certificate that last failed the activation
+// check. Resyncs do not flip the console back to it until retryAt, so a
+// certificate the console will not serve is not activated and rolled back on
+// every resync.
+type rejectedCertificate struct {
+	fingerprint string
+	failures    int
+	retryAt     time.Time
+}
+
+func newConsole(config ConsoleConfig, logger *logrus.Logger) (*console, error) {
+	var opts []unifi.ClientOption
+	if config.TOTPSecret != "" {
+		opts = append(opts, unifi.WithTOTPSecret(config.TOTPSecret))
+	}
+	var sessions unifi.SessionStore
+	if config.SessionCache != nil {
+		sessions = newSessionStore(config.SessionCache)
+		opts = append(opts, unifi.WithSessionStore(sessions))
+	}
+	unifiClient, err := unifi.NewClient(config.URL, config.Username, config.Password, newHTTPClient(logger), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating UniFi client for console %s: %w", config.Name, err)
+	}
+	source, err := newCertificateSource(config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate source for console %s: %w", config.Name, err)
+	}
+
+	return &console{
+		config:   config,
+		client:   unifiClient,
+		source:   source,
+		logger:   logger.WithField("console", config.Name),
+		sessions: sessions,
+	}, nil
+}
+
+// newHTTPClient returns a retrying HTTP client. Every console gets its own so
+// that session cookies are never shared between consoles.
+func newHTTPClient(logger *logrus.Logger) *http.Client {
+	retryClient := retryablehttp.NewClient()
+	retryClient.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+	retryClient.RetryMax = 5
+	retryClient.Logger = logger
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	retryClient.HTTPClient.Transport = tr
+
+	return retryClient.StandardClient()
+}
+
+// Status returns a copy of the console's last reconcile status.
+func (c *console) Status() ConsoleStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// reconcile pushes the console's certificate to the console, records the
+// outcome in its status and reports it on the secret.
+func (c *console) reconcile(ctx context.Context, k8sClient client.Client, recorder record.EventRecorder) error {
+	status := ConsoleStatus{LastAttempt: time.Now()}
+	certID, fingerprint, changed, err := c.sync(ctx, k8sClient)
+
+	c.mu.Lock()
+	status.LastSuccess = c.status.LastSuccess
+	status.CertificateID = certID
+	status.Fingerprint = fingerprint
+	status.Changed = changed
+	status.Err = err
+	if err == nil {
+		status.LastSuccess = status.LastAttempt
+		lastSuccessfulSync.WithLabelValues(c.config.Name).Set(float64(status.LastSuccess.Unix()))
+	} else {
+		// Force a fresh login next time in case the session has expired
+		c.loggedIn = false
+
+		stage := "unknown"
+		var stageErr *syncError
+		if errors.As(err, &stageErr) {
+			stage = stageErr.Stage
+		}
+		failuresTotal.WithLabelValues(c.config.Name, stage).Inc()
+	}
+	c.status = status
+	c.mu.Unlock()
+
+	c.recordStatus(ctx, k8sClient, recorder, status)
+	return err
+}
+
+func (c *console) sync(ctx context.Context, k8sClient client.Client) (string, string, bool, error) {
+	if err := c.login(ctx, k8sClient); err != nil {
+		return "", "", false, &syncError{Stage: StageLogin, Err: err}
+	}
+
+	cert, key, err := c.source.Fetch(ctx, k8sClient)
+	if err != nil {
+		return "", "", false, &syncError{Stage: StageFetch, Err: fmt.Errorf("error fetching certificate and key: %w", err)}
+	}
+
+	if err := c.validate(cert, key); err != nil {
+		return "", "", false, &syncError{Stage: StageValidate, Err: err}
+	}
+
+	fingerprint, err := calculateFingerprint(cert)
+	if err != nil {
+		return "", "", false, &syncError{Stage: StageValidate, Err: fmt.Errorf("failed to calculate certificate fingerprint: %w", err)}
+	}
+
+	certID, changed, err := c.syncCertificate(ctx, cert, key, fingerprint)
+	c.recordActiveCertificate(ctx)
+	if err != nil {
+		return "", fingerprint, changed, err
+	}
+	return certID, fingerprint, changed, nil
+}
+
+// recordActiveCertificate exports the expiry of the certificate the console
+// currently has active.
+func (c *console) recordActiveCertificate(ctx context.Context) {
+	certificates, err := c.client.ListCertificates(ctx)
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to list certificates for metrics.")
+		return
+	}
+
+	for _, cert := range certificates {
+		if cert.Active {
+			certificateNotAfter.WithLabelValues(c.config.Name).Set(float64(cert.ValidTo.Unix()))
+			return
+		}
+	}
+}
+
+// syncCertificate uploads the certificate if the console does not already have
+// it, makes sure it is the active one and prunes old certificates. It returns
+// the ID of the certificate on the console and whether it uploaded or
+// activated one.
+func (c *console) syncCertificate(ctx context.Context, cert, key, fingerprint string) (string, bool, error) {
+	// Check existing certificates and upload only if fingerprint differs
+	c.logger.Debug("Checking existing certificates and uploading if necessary.")
+	newCertID, uploaded, err := checkAndUploadCertificate(ctx, c.client, cert, key, c.logger)
+	if err != nil {
+		return "", false, &syncError{Stage: StageUpload, Err: fmt.Errorf("certificate upload failed: %w", err)}
+	}
+	if uploaded {
+		uploadsTotal.WithLabelValues(c.config.Name).Inc()
+	}
+
+	c.mu.Lock()
+	rejected := c.rejected
+	c.mu.Unlock()
+	if rejected.fingerprint == fingerprint && time.Now().Before(rejected.retryAt) {
+		return newCertID, uploaded, &syncError{Stage: StageActivate, Err: fmt.Errorf("certificate %s failed the activation check %d time(s), not activating it again before %s",
+			fingerprint, rejected.failures, rejected.retryAt.Format(time.RFC3339))}
+	}
+
+	// Activate the new certificate if not already active
+	previousCertID, activated, err := ensureCertificateActivated(ctx, c.client, newCertID, c.logger)
+	if err != nil {
+		return newCertID, uploaded, &syncError{Stage: StageActivate, Err: fmt.Errorf("certificate activation failed: %w", err)}
+	}
+
+	// Make sure the console actually serves what we activated
+	if activated {
+		activationsTotal.WithLabelValues(c.config.Name).Inc()
+		if err := verifyServedCertificate(ctx, c.config.URL, fingerprint, c.config.ActivationTimeout.Duration, c.logger); err != nil {
+			c.logger.WithError(err).Error("Activated certificate is not being served, rolling back.")
+			c.reject(fingerprint)
+			return newCertID, true, &syncError{Stage: StageActivate, Err: c.rollback(ctx, previousCertID, err)}
+		}
+		c.mu.Lock()
+		c.rejected = rejectedCertificate{}
+		c.mu.Unlock()
+	}
+
+	// Enforce the maximum certificate limit
+	deleted, err := enforceCertificateLimit(ctx, c.client, c.config.retentionPolicy(), c.logger)
+	deletionsTotal.WithLabelValues(c.config.Name).Add(float64(deleted))
+	if err != nil {
+		return newCertID, uploaded || activated, &syncError{Stage: StagePrune, Err: fmt.Errorf("enforcing certificate limit failed: %w", err)}
+	}
+
+	return newCertID, uploaded || activated, nil
+}
+
+// reject records that the certificate failed the activation check, doubling
+// the time before it is tried again if it failed before.
+func (c *console) reject(fingerprint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rejected.fingerprint != fingerprint {
+		c.rejected = rejectedCertificate{fingerprint: fingerprint}
+	}
+	c.rejected.failures++
+	backoff := rejectedMinBackoff
+	for i := 1; i < c.rejected.failures && backoff < rejectedMaxBackoff; i++ {
+		backoff *= 2
+	}
+	c.rejected.retryAt = time.Now().Add(min(backoff, rejectedMaxBackoff))
+}
+
+// rollback reactivates the previously active certificate after a failed
+// activation check. The returned error always reports the failed check. The
+// rollback still runs if ctx was cancelled during the check, so that shutting
+// down never leaves an unverified certificate active.
+func (c *console) rollback(ctx context.Context, previousCertID string, cause error) error {
+	if previousCertID == "" {
+		return fmt.Errorf("activation check failed and there is no previous certificate to roll back to: %w", cause)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	if err := c.client.ActivateCertificate(ctx, previousCertID); err != nil {
+		return fmt.Errorf("activation check failed (%w) and rollback to certificate %s failed: %v", cause, previousCertID, err)
+	}
+
+	activationsTotal.WithLabelValues(c.config.Name).Inc()
+	c.logger.Warnf("Rolled back to previously active certificate %s.", previousCertID)
+	return fmt.Errorf("activation check failed, rolled back to certificate %s: %w", previousCertID, cause)
+}
+
+// validate runs the pre-upload checks against the certificate and key.
+func (c *console) validate(cert, key string) error {
+	var opts validationOptions
+	if c.config.VerifyHostname {
+		host, err := hostFromURL(c.config.URL)
+		if err != nil {
+			return err
+		}
+		opts.Host = host
+	}
+
+	if err := validateCertificate(cert, key, opts); err != nil {
+		return err
+	}
+	c.logger.Debug("Certificate passed pre-upload validation.")
+	return nil
+}
+
+// login logs in to the console unless a session is already established,
+// reading the credentials from the credentials secret if one is configured.
+func (c *console) login(ctx context.Context, k8sClient client.Client) error {
+	c.mu.Lock()
+	loggedIn := c.loggedIn
+	c.mu.Unlock()
+	if loggedIn {
+		return nil
+	}
+
+	if ref := c.config.CredentialsSecret; ref != nil {
+		var secret corev1.Secret
+		if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+			return fmt.Errorf("failed to fetch credentials secret: %w", err)
+		}
+		username, userOk := secret.Data[ref.UsernameKey]
+		password, passOk := secret.Data[ref.PasswordKey]
+		if !userOk || !passOk {
+			return fmt.Errorf("credentials secret %s/%s is missing %s or %s", ref.Namespace, ref.Name, ref.UsernameKey, ref.PasswordKey)
+		}
+		c.client.Username = string(username)
+		c.client.Password = string(password)
+		if ref.TOTPSecretKey != "" {
+			totpSecret, ok := secret.Data[ref.TOTPSecretKey]
+			if !ok {
+				return fmt.Errorf("credentials secret %s/%s is missing %s", ref.Namespace, ref.Name, ref.TOTPSecretKey)
+			}
+			c.client.TOTPSecret = string(totpSecret)
+		}
+	}
+
+	if store, ok := c.sessions.(*secretSessionStore); ok {
+		store.client = k8sClient
+	}
+
+	c.logger.Debug("Logging in to UniFi...")
+	if err := c.client.Login(ctx); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	c.logger.Info("Login successful.")
+
+	c.mu.Lock()
+	c.loggedIn = true
+	c.mu.Unlock()
+	return nil
+}
+
+// logout ends the console's session at the end of a run, unless it is cached
+// for the next run.
+func (c *console) logout(ctx context.Context) {
+	c.mu.Lock()
+	loggedIn := c.loggedIn
+	c.loggedIn = false
+	c.mu.Unlock()
+	if !loggedIn || c.sessions != nil {
+		return
+	}
+
+	if err := c.client.Logout(ctx); err != nil {
+		c.logger.WithError(err).Warn("Failed to log out.")
+	}
+}
+
+// reconcileAll reconciles every console. Sources that are not ready yet are
+// waited for concurrently and each console is synced as soon as its own source
+// is ready, so a source that never becomes ready only delays its own console.
+// A failing console is logged and does not stop the remaining consoles from
+// being reconciled.
+func reconcileAll(ctx context.Context, consoles []*console, k8sClient client.Client, recorder record.EventRecorder) error {
+	// Syncs still run one at a time, as in daemon mode, so that ACME consoles
+	// never compete for the HTTP-01 listen address
+	var syncMu sync.Mutex
+	errs := make([]error, len(consoles))
+	var wg sync.WaitGroup
+	for i, c := range consoles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ready, ok := c.source.(readySource); ok {
+				// On failure the sync below fails too, and records why
+				if err := ready.WaitReady(ctx, k8sClient); err != nil {
+					c.logger.WithError(err).Warn("Certificate source is not ready.")
+				}
+			}
+
+			syncMu.Lock()
+			defer syncMu.Unlock()
+			errs[i] = c.reconcile(ctx, k8sClient, recorder)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, c := range consoles {
+		if errs[i] != nil {
+			c.logger.WithError(errs[i]).Error("Certificate sync failed.")
+			failed = append(failed, c.config.Name)
+			continue
+		}
+		c.logger.Info("Certificate successfully managed.")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("certificate sync failed for consoles: %v", failed)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConsoleReconcileEndToEnd(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+
+	// The console starts out with an older certificate active
+	oldCert, oldKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	old, err := server.AddCertificate("old", oldCert, oldKey, true)
+	require.NoError(t, err)
+
+	now := time.Now()
+	ca := newTestCertificate(t, "Test CA", nil, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(time.Hour), ca)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data: map[string][]byte{
+			"tls.crt": []byte(leaf.certPEM + ca.certPEM),
+			"tls.key": []byte(leaf.keyPEM),
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+	// Every upload, activation and deletion is logged with the console's name
+	testLogger, hook := logtest.NewNullLogger()
+	c, err := newConsole(ConsoleConfig{
+		Name:              "office",
+		URL:               server.URL,
+		Username:          unifitest.DefaultUsername,
+		Password:          unifitest.DefaultPassword,
+		TLSSecret:         SecretReference{Namespace: "certs", Name: "unifi-tls"},
+		MaxCerts:          1,
+		ActivationTimeout: metav1.Duration{Duration: 5 * time.Second},
+	}, testLogger)
+	require.NoError(t, err)
+
+	// Upload, activate, verify the console serves it, then prune the old one
+	require.NoError(t, c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10)))
+	var logged []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["console"] == "office" {
+			logged = append(logged, entry.Message)
+		}
+	}
+	assert.Contains(t, logged, "Certificate uploaded successfully.")
+	assert.Contains(t, logged, "Activating certificate with ID "+server.Certificates()[0].ID+".")
+	assert.True(t, slices.ContainsFunc(logged, func(msg string) bool {
+		return strings.HasPrefix(msg, "Deleted certificate with ID "+old.ID+":")
+	}), "deletion not logged for the console: %q", logged)
+	certificates := server.Certificates()
+	require.Len(t, certificates, 1)
+	assert.NotEqual(t, old.ID, certificates[0].ID)
+	assert.True(t, certificates[0].Active)
+	assert.Equal(t, fingerprintCertificate(leaf.cert), certificates[0].Fingerprint)
+
+	status := c.Status()
+	assert.NoError(t, status.Err)
+	assert.Equal(t, certificates[0].ID, status.CertificateID)
+
+	// A second pass finds the certificate in place and changes nothing
+	require.NoError(t, c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10)))
+	assert.Equal(t, certificates, server.Certificates())
+	writes := slices.DeleteFunc(server.Requests(), func(r string) bool {
+		return strings.HasPrefix(r, "GET ") || r == "POST /api/auth/login"
+	})
+	assert.Equal(t, []string{
+		"POST /api/userCertificates",
+		"PUT /api/userCertificates/" + certificates[0].ID + "/status",
+		"DELETE /api/userCertificates/" + old.ID,
+	}, writes)
+	assert.Equal(t, 1, server.Logins())
+}
+
+func TestConsoleRollsBackUnservedCertificate(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+
+	// Served over plain HTTP, the console never presents what it activates
+	server := unifitest.NewServer()
+	defer server.Close()
+	oldCert, oldKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	old, err := server.AddCertificate("old", oldCert, oldKey, true)
+	require.NoError(t, err)
+
+	newCert, newKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	k8sClient := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data:       map[string][]byte{"tls.crt": []byte(newCert), "tls.key": []byte(newKey)},
+	}).Build()
+	c, err := newConsole(ConsoleConfig{
+		Name:              "office",
+		URL:               server.URL,
+		Username:          unifitest.DefaultUsername,
+		Password:          unifitest.DefaultPassword,
+		TLSSecret:         SecretReference{Namespace: "certs", Name: "unifi-tls"},
+		MaxCerts:          5,
+		ActivationTimeout: metav1.Duration{Duration: 50 * time.Millisecond},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	activations := func() int {
+		return len(slices.DeleteFunc(server.Requests(), func(r string) bool { return !strings.HasSuffix(r, "/status") }))
+	}
+	active := func() string {
+		for _, cert := range server.Certificates() {
+			if cert.Active {
+				return cert.ID
+			}
+		}
+		return ""
+	}
+
+	// The new certificate is activated, not served, and the old one restored
+	err = c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10))
+	assert.ErrorContains(t, err, "activation check failed, rolled back to certificate "+old.ID)
+	assert.Equal(t, old.ID, active())
+	assert.Equal(t, 2, activations())
+
+	// Resyncs do not flip the console to the same certificate again
+	err = c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10))
+	assert.ErrorContains(t, err, "failed the activation check 1 time(s), not activating it again before")
+	assert.Equal(t, 2, activations())
+
+	// Once the backoff has passed it is tried again, and backs off for longer
+	c.rejected.retryAt = time.Now()
+	err = c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10))
+	assert.ErrorContains(t, err, "rolled back")
+	assert.Equal(t, 4, activations())
+	assert.Equal(t, 2, c.rejected.failures)
+	assert.WithinDuration(t, time.Now().Add(2*rejectedMinBackoff), c.rejected.retryAt, time.Minute)
+}
+
+func TestConsoleRollback(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	oldCert, oldKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	old, err := server.AddCertificate("old", oldCert, oldKey, false)
+	require.NoError(t, err)
+	newCert, newKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	_, err = server.AddCertificate("new", newCert, newKey, true)
+	require.NoError(t, err)
+
+	c, err := newConsole(ConsoleConfig{Name: "office", URL: server.URL}, logrus.New())
+	require.NoError(t, err)
+	// Without retries, so that a missing certificate fails at once
+	c.client, err = unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, c.client.Login(context.Background()))
+	cause := errors.New("console serves certificate aa, expected bb")
+
+	t.Run("reactivates the previous certificate", func(t *testing.T) {
+		err := c.rollback(context.Background(), old.ID, cause)
+		assert.EqualError(t, err, "activation check failed, rolled back to certificate "+old.ID+": "+cause.Error())
+		assert.ErrorIs(t, err, cause)
+		assert.True(t, server.Certificates()[0].Active)
+	})
+
+	t.Run("rollback fails", func(t *testing.T) {
+		err := c.rollback(context.Background(), "missing", cause)
+		assert.ErrorContains(t, err, "activation check failed ("+cause.Error()+") and rollback to certificate missing failed")
+		assert.ErrorIs(t, err, cause)
+	})
+
+	t.Run("no previous certificate", func(t *testing.T) {
+		err := c.rollback(context.Background(), "", cause)
+		assert.EqualError(t, err, "activation check failed and there is no previous certificate to roll back to: "+cause.Error())
+	})
+
+	t.Run("runs after the sync was cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorContains(t, c.rollback(ctx, old.ID, cause), "rolled back to certificate "+old.ID)
+	})
+}
+
+// blockedSource is not ready until release is closed
+type blockedSource struct {
+	CertificateSource
+	release chan struct{}
+}
+
+func (s blockedSource) WaitReady(context.Context, client.Client) error {
+	<-s.release
+	return errors.New("Certificate is not Ready")
+}
+
+func TestReconcileAllDoesNotWaitForOtherConsoles(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+	cert, key, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	k8sClient := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "garage-tls"},
+		Data:       map[string][]byte{"tls.crt": []byte(cert), "tls.key": []byte(key)},
+	}).Build()
+
+	newTestConsole := func(name string) *console {
+		c, err := newConsole(ConsoleConfig{
+			Name:              name,
+			URL:               server.URL,
+			Username:          unifitest.DefaultUsername,
+			Password:          unifitest.DefaultPassword,
+			TLSSecret:         SecretReference{Namespace: "certs", Name: name + "-tls"},
+			ActivationTimeout: metav1.Duration{Duration: 5 * time.Second},
+		}, logrus.New())
+		require.NoError(t, err)
+		return c
+	}
+	office := newTestConsole("office")
+	release := make(chan struct{})
+	office.source = blockedSource{CertificateSource: office.source, release: release}
+	garage := newTestConsole("garage")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- reconcileAll(context.Background(), []*console{office, garage}, k8sClient, record.NewFakeRecorder(10))
+	}()
+
+	// The garage is synced while the office's source is still not ready
+	require.Eventually(t, func() bool {
+		return len(server.Certificates()) == 1 && server.Certificates()[0].Active
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("reconcileAll returned before the office's source was ready: %v", err)
+	default:
+	}
+
+	// Only the office, whose secret does not exist, fails
+	close(release)
+	assert.EqualError(t, <-done, "certificate sync failed for consoles: [office]")
+	assert.Error(t, office.Status().Err)
+	assert.NoError(t, garage.Status().Err)
+}
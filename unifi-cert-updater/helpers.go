@@ -32,11 +32,15 @@ func calculateFingerprint(certPEM string) (string, error) {
 		return "", fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
+	return fingerprintCertificate(cert), nil
+}
+
+// fingerprintCertificate returns the fingerprint of a parsed certificate in the
+// same format as calculateFingerprint.
+func fingerprintCertificate(cert *x509.Certificate) string {
 	// Compute SHA256 hash of the raw certificate
 	hash := sha1.Sum(cert.Raw)
 
 	// Format the hash as colon-separated hexadecimal
-	fingerprint := strings.ToUpper(strings.Join(formatColonSeparated(hash[:]), ":"))
-
-	return fingerprint, nil
+	return strings.ToUpper(strings.Join(formatColonSeparated(hash[:]), ":"))
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// hostRetryBackoff is the first delay before retrying a failed sync in host
+// mode. It doubles on every failure, up to the resync interval.
+var hostRetryBackoff = 5 * time.Second
+
+// runHostDaemon keeps consoles in sync on a plain host without Kubernetes.
+// Each console is synced at start, whenever its source changes and every
+// resync interval until the context is cancelled.
+func runHostDaemon(ctx context.Context, config Config, consoles []*console) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: config.MetricsAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("metrics server failed: %w", err)
+		}
+	}()
+
+	triggers := make(map[*console]chan struct{}, len(consoles))
+	for _, c := range consoles {
+		triggers[c] = make(chan struct{}, 1)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watchSources(ctx, consoles, func(c *console) {
+			select {
+			case triggers[c] <- struct{}{}:
+			default:
+				// A sync is already pending
+			}
+		})
+	}()
+
+	logger.Infof("Watching certificate sources for %d consoles without Kubernetes, resyncing every %s.", len(consoles), config.ResyncInterval)
+	for _, c := range consoles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syncLoop(ctx, c, config.ResyncInterval, triggers[c])
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serverErr:
+		cancel()
+	}
+	wg.Wait()
+	_ = server.Shutdown(context.Background())
+	return err
+}
+
+// syncLoop reconciles a console until ctx is cancelled, retrying failures
+// with exponential backoff.
+func syncLoop(ctx context.Context, c *console, resyncInterval time.Duration, trigger <-chan struct{}) {
+	backoff := hostRetryBackoff
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-timer.C:
+		}
+
+		next := resyncInterval
+		if err := c.reconcile(ctx, nil, nil); err != nil {
+			c.logger.WithError(err).Errorf("Certificate sync failed, retrying in %s.", backoff)
+			next = backoff
+			backoff = min(2*backoff, resyncInterval)
+		} else {
+			backoff = hostRetryBackoff
+			c.logger.Infof("Certificate in sync, next resync in %s.", resyncInterval)
+		}
+		timer.Reset(next)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newHostTestConsole returns a console reading PEM files from a new directory,
+// and a function that writes a new certificate there.
+func newHostTestConsole(t *testing.T, server *unifitest.Server) (*console, func() string) {
+	t.Helper()
+	dir := t.TempDir()
+	c, err := newConsole(ConsoleConfig{
+		Name:              "office",
+		URL:               server.URL,
+		Username:          unifitest.DefaultUsername,
+		Password:          unifitest.DefaultPassword,
+		Source:            SourceConfig{Directory: &DirectorySourceConfig{Path: dir}},
+		MaxCerts:          5,
+		ActivationTimeout: metav1.Duration{Duration: 5 * time.Second},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	write := func() string {
+		cert, key, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), []byte(key), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), []byte(cert), 0o600))
+		fingerprint, err := calculateFingerprint(cert)
+		require.NoError(t, err)
+		return fingerprint
+	}
+	return c, write
+}
+
+func activeFingerprint(server *unifitest.Server) string {
+	for _, cert := range server.Certificates() {
+		if cert.Active {
+			return cert.Fingerprint
+		}
+	}
+	return ""
+}
+
+func TestSyncLoop(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+	defer func(d time.Duration) { hostRetryBackoff = d }(hostRetryBackoff)
+	hostRetryBackoff = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+	c, write := newHostTestConsole(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	trigger := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		syncLoop(ctx, c, time.Hour, trigger)
+	}()
+
+	// The directory is empty at first, so the sync fails and is retried
+	// long before the resync interval
+	require.Eventually(t, func() bool { return c.Status().Err != nil }, 5*time.Second, 10*time.Millisecond)
+	first := write()
+	require.Eventually(t, func() bool { return activeFingerprint(server) == first }, 5*time.Second, 10*time.Millisecond)
+
+	// A trigger syncs again without waiting for the resync
+	second := write()
+	trigger <- struct{}{}
+	require.Eventually(t, func() bool { return activeFingerprint(server) == second }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, c.Status().Err)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("syncLoop did not return after the context was cancelled")
+	}
+}
+
+func TestRunHostDaemon(t *testing.T) {
+	defer func(l *logrus.Logger) { logger = l }(logger)
+	logger = logrus.New()
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+	defer func(d time.Duration) { watchDebounce = d }(watchDebounce)
+	watchDebounce = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+	c, write := newHostTestConsole(t, server)
+	first := write()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- runHostDaemon(ctx, Config{MetricsAddress: "127.0.0.1:0", ResyncInterval: time.Hour}, []*console{c})
+	}()
+
+	// Synced at start, then again when the files change
+	require.Eventually(t, func() bool { return activeFingerprint(server) == first }, 5*time.Second, 10*time.Millisecond)
+	second := write()
+	require.Eventually(t, func() bool { return activeFingerprint(server) == second }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("runHostDaemon did not return after the context was cancelled")
+	}
+
+	t.Run("metrics server fails", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		// The address is taken, so the daemon stops its sync loops and exits
+		err = runHostDaemon(context.Background(), Config{MetricsAddress: listener.Addr().String(), ResyncInterval: time.Hour}, []*console{c})
+		assert.ErrorContains(t, err, "metrics server failed")
+	})
+}
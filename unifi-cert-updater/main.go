@@ -2,34 +2,63 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
-	"sort"
 	"strconv"
+	"time"
 
 	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
-	"github.com/hashicorp/go-retryablehttp"
+	legolog "github.com/go-acme/lego/v4/log"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Run modes supported by the updater
+const (
+	RunModeOnce   = "once"   // Sync the certificate once and exit
+	RunModeDaemon = "daemon" // Watch the secret and keep the console in sync
+	RunModePlan   = "plan"   // Print what a sync would change without changing it
+)
+
+// Output formats of plan mode
+const (
+	PlanFormatText = "text"
+	PlanFormatJSON = "json"
+)
+
 // Config holds application configuration
 type Config struct {
-	UniFiAPIURL string
-	Username    string
-	Password    string
-	Namespace   string
-	SecretName  string
-	LogLevel    string
-	MaxCerts    int
+	UniFiAPIURL        string
+	Username           string
+	Password           string
+	TOTPSecret         string
+	Namespace          string
+	SecretName         string
+	CertDirectory      string
+	PKCS12File         string
+	PKCS12PasswordFile string
+	LogLevel           string
+	MaxCerts           int
+	RunMode            string
+	ResyncInterval     time.Duration
+	ConfigFile         string
+	VerifyHostname     bool
+	ActivationTimeout  time.Duration
+	MetricsAddress     string
+	PushgatewayURL     string
+	PlanFormat         string
+	SessionCacheFile   string
+	SessionCacheSecret string
+	Retention          RetentionPolicy
+	Consoles           []ConsoleConfig
 }
 
 var logger *logrus.Logger
@@ -45,101 +74,188 @@ func init() {
 	// Initialize logrus
 	logger = logrus.New()
 	setupLogger(logger)
+	// lego logs through a package-level logger shared by all ACME consoles
+	legolog.Logger = logger
 
 }
 
 func main() {
 	// Load configuration from environment variables
 	config := Config{
-		UniFiAPIURL: os.Getenv("UNIFI_API_URL"),
-		Username:    os.Getenv("UNIFI_USERNAME"),
-		Password:    os.Getenv("UNIFI_PASSWORD"),
-		Namespace:   os.Getenv("NAMESPACE"),
-		SecretName:  os.Getenv("SECRET_NAME"),
+		UniFiAPIURL:        os.Getenv("UNIFI_API_URL"),
+		Username:           os.Getenv("UNIFI_USERNAME"),
+		Password:           os.Getenv("UNIFI_PASSWORD"),
+		TOTPSecret:         os.Getenv("UNIFI_TOTP_SECRET"),
+		Namespace:          os.Getenv("NAMESPACE"),
+		SecretName:         os.Getenv("SECRET_NAME"),
+		CertDirectory:      os.Getenv("CERT_DIRECTORY"),
+		PKCS12File:         os.Getenv("PKCS12_FILE"),
+		PKCS12PasswordFile: os.Getenv("PKCS12_PASSWORD_FILE"),
+		ConfigFile:         os.Getenv("CONFIG_FILE"),
+		PushgatewayURL:     os.Getenv("PUSHGATEWAY_URL"),
+		SessionCacheFile:   os.Getenv("SESSION_CACHE_FILE"),
+		SessionCacheSecret: os.Getenv("SESSION_CACHE_SECRET"),
 	}
 
 	if config.MaxCerts, _ = strconv.Atoi(os.Getenv("MAX_CERTS")); config.MaxCerts == 0 {
 		config.MaxCerts = 5
 	}
 
-	// Validate required environment variables
-	missingEnvVars := validateEnvVars(config)
-	if len(missingEnvVars) > 0 {
-		logger.Errorf("Missing required environment variables: %s", missingEnvVars)
-		os.Exit(1)
-	}
-
-	logger.Info("Environment variables validated successfully.")
+	config.VerifyHostname, _ = strconv.ParseBool(os.Getenv("VERIFY_HOSTNAME"))
 
-	// Initialize retryablehttp client
-	retryClient := retryablehttp.NewClient()
-	retryClient.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
-		if resp.StatusCode == http.StatusNotFound {
-			return true, nil
-		}
-		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if config.RunMode = os.Getenv("RUN_MODE"); config.RunMode == "" {
+		config.RunMode = RunModeOnce
 	}
-	retryClient.RetryMax = 5
-	retryClient.Logger = logger
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+
+	if config.ResyncInterval, _ = time.ParseDuration(os.Getenv("RESYNC_INTERVAL")); config.ResyncInterval == 0 {
+		config.ResyncInterval = time.Hour
 	}
-	retryClient.HTTPClient.Transport = tr
 
-	// Initialize UniFi client
-	unifiClient, err := unifi.NewClient(config.UniFiAPIURL, config.Username, config.Password, retryClient.StandardClient())
-	if err != nil {
-		logger.Errorf("Error creating UniFi client: %v", err)
-		os.Exit(1)
+	if config.ActivationTimeout, _ = time.ParseDuration(os.Getenv("ACTIVATION_TIMEOUT")); config.ActivationTimeout == 0 {
+		config.ActivationTimeout = 2 * time.Minute
 	}
 
-	// Log in to UniFi
-	logger.Debug("Logging in to UniFi...")
-	if err := unifiClient.Login(); err != nil {
-		logger.Errorf("Login failed: %v", err)
-		os.Exit(1)
+	if deleteExpired, err := strconv.ParseBool(os.Getenv("DELETE_EXPIRED_CERTS")); err == nil {
+		config.Retention.DeleteExpired = &deleteExpired
 	}
-	logger.Info("Login successful.")
+	config.Retention.KeepPerSANs, _ = strconv.Atoi(os.Getenv("KEEP_CERTS_PER_SAN"))
+	config.Retention.KeepPerCN, _ = strconv.Atoi(os.Getenv("KEEP_CERTS_PER_CN"))
+	config.Retention.KeepYoungerThan.Duration, _ = time.ParseDuration(os.Getenv("KEEP_CERTS_YOUNGER_THAN"))
+	config.Retention.ProtectNamePattern = os.Getenv("PROTECT_CERT_NAME_PATTERN")
 
-	// Initialize Kubernetes client
-	scheme := runtime.NewScheme()
-	utilruntime.Must(corev1.AddToScheme(scheme))
+	if config.PlanFormat = os.Getenv("PLAN_FORMAT"); config.PlanFormat == "" {
+		config.PlanFormat = PlanFormatText
+	}
 
-	k8sClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
-	if err != nil {
-		logger.Errorf("Error creating Kubernetes client: %v", err)
-		os.Exit(1)
+	if config.MetricsAddress = os.Getenv("METRICS_BIND_ADDRESS"); config.MetricsAddress == "" {
+		config.MetricsAddress = ":8080"
 	}
 
-	// Fetch certificate and key from Kubernetes secret
-	logger.Debugf("Fetching certificate from namespace '%s', secret '%s'", config.Namespace, config.SecretName)
-	cert, key, err := fetchCertAndKeyFromSecret(context.Background(), k8sClient, config.Namespace, config.SecretName, logger)
-	if err != nil {
-		logger.Errorf("Error fetching certificate and key: %v", err)
+	if err := validateConfig(config); err != nil {
+		logger.Errorf("Invalid configuration: %v", err)
 		os.Exit(1)
 	}
 
-	// Check existing certificates and upload only if fingerprint differs
-	logger.Debug("Checking existing certificates and uploading if necessary.")
-	newCertID, err := checkAndUploadCertificate(unifiClient, cert, key, logger)
-	if err != nil {
-		logger.Errorf("Error during certificate upload: %v", err)
-		os.Exit(1)
+	if config.ConfigFile != "" {
+		// Consoles are described by the config file
+		consoles, err := loadConsolesFromFile(config.ConfigFile, config)
+		if err != nil {
+			logger.Errorf("Error loading config file: %v", err)
+			os.Exit(1)
+		}
+		config.Consoles = consoles
+		logger.Infof("Loaded %d consoles from %s.", len(consoles), config.ConfigFile)
+	} else {
+		// Validate required environment variables
+		missingEnvVars := validateEnvVars(config)
+		if len(missingEnvVars) > 0 {
+			logger.Errorf("Missing required environment variables: %s", missingEnvVars)
+			os.Exit(1)
+		}
+		config.Consoles = []ConsoleConfig{consoleFromEnv(config)}
+		if err := validateConsoles(config.Consoles); err != nil {
+			logger.Errorf("Invalid configuration: %v", err)
+			os.Exit(1)
+		}
+
+		logger.Info("Environment variables validated successfully.")
 	}
 
-	// Activate the new certificate if not already active
-	err = ensureCertificateActivated(unifiClient, newCertID, logger)
-	if err != nil {
-		logger.Fatalf("Error ensuring certificate activation: %v", err)
+	// Initialize a UniFi client per console
+	consoles := make([]*console, 0, len(config.Consoles))
+	for _, consoleConfig := range config.Consoles {
+		c, err := newConsole(consoleConfig, logger)
+		if err != nil {
+			logger.Errorf("Error creating UniFi client: %v", err)
+			os.Exit(1)
+		}
+		consoles = append(consoles, c)
 	}
 
-	// Enforce the maximum certificate limit
-	err = enforceCertificateLimit(unifiClient, config.MaxCerts)
-	if err != nil {
-		logrus.Fatalf("Error enforcing certificate limit: %v", err)
+	// Initialize Kubernetes scheme
+	scheme := runtime.NewScheme()
+	utilruntime.Must(corev1.AddToScheme(scheme))
+
+	// Kubernetes is only needed if a console reads secrets or records status
+	usesKubernetes := false
+	for _, c := range consoles {
+		usesKubernetes = usesKubernetes || c.config.usesKubernetes()
+	}
+	newKubernetesClient := func() client.Client {
+		if !usesKubernetes {
+			logger.Info("No console uses Kubernetes, running without it.")
+			return nil
+		}
+		k8sClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			logger.Errorf("Error creating Kubernetes client: %v", err)
+			os.Exit(1)
+		}
+		return k8sClient
 	}
 
-	logrus.Info("Certificate successfully managed.")
+	switch config.RunMode {
+	case RunModeDaemon:
+		var err error
+		if usesKubernetes {
+			err = runDaemon(ctrl.SetupSignalHandler(), config, scheme, consoles)
+		} else {
+			err = runHostDaemon(ctrl.SetupSignalHandler(), config, consoles)
+		}
+		if err != nil {
+			logger.Errorf("Daemon exited with error: %v", err)
+			os.Exit(1)
+		}
+	case RunModeOnce:
+		k8sClient := newKubernetesClient()
+
+		var recorder record.EventRecorder
+		if k8sClient != nil {
+			recorder = &clientEventRecorder{client: k8sClient, scheme: scheme, component: "unifi-cert-updater"}
+		}
+		syncErr := reconcileAll(context.Background(), consoles, k8sClient, recorder)
+		for _, c := range consoles {
+			c.logout(context.Background())
+		}
+
+		if config.PushgatewayURL != "" {
+			if err := pushMetrics(config.PushgatewayURL); err != nil {
+				logger.Warnf("Error pushing metrics: %v", err)
+			}
+		}
+
+		if syncErr != nil {
+			logger.Fatalf("Error syncing certificates: %v", syncErr)
+		}
+	case RunModePlan:
+		k8sClient := newKubernetesClient()
+
+		plans := make([]Plan, 0, len(consoles))
+		failed := false
+		for _, c := range consoles {
+			plan := c.plan(context.Background(), k8sClient)
+			failed = failed || plan.Error != ""
+			plans = append(plans, plan)
+			c.logout(context.Background())
+		}
+
+		switch config.PlanFormat {
+		case PlanFormatJSON:
+			if err := writePlansJSON(os.Stdout, plans); err != nil {
+				logger.Errorf("Error writing plan: %v", err)
+				os.Exit(1)
+			}
+		case PlanFormatText:
+			writePlansText(os.Stdout, plans)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	default:
+		logger.Errorf("Unknown RUN_MODE %q, expected %q, %q or %q", config.RunMode, RunModeOnce, RunModeDaemon, RunModePlan)
+		os.Exit(1)
+	}
 }
 
 func setupLogger(logger *logrus.Logger) {
@@ -174,6 +290,10 @@ func validateEnvVars(config Config) []string {
 	if config.Password == "" {
 		missingEnvVars = append(missingEnvVars, "UNIFI_PASSWORD")
 	}
+	if config.CertDirectory != "" || config.PKCS12File != "" {
+		// The certificate is read from disk, Kubernetes is not needed
+		return missingEnvVars
+	}
 	if config.Namespace == "" {
 		missingEnvVars = append(missingEnvVars, "NAMESPACE")
 	}
@@ -183,7 +303,16 @@ func validateEnvVars(config Config) []string {
 	return missingEnvVars
 }
 
-func fetchCertAndKeyFromSecret(ctx context.Context, k8sClient client.Client, namespace, secretName string, logger *logrus.Logger) (string, string, error) {
+// validateConfig checks the settings read from the environment that apply to
+// every console.
+func validateConfig(config Config) error {
+	if config.PlanFormat != PlanFormatText && config.PlanFormat != PlanFormatJSON {
+		return fmt.Errorf("unknown PLAN_FORMAT %q, expected %q or %q", config.PlanFormat, PlanFormatText, PlanFormatJSON)
+	}
+	return nil
+}
+
+func fetchCertAndKeyFromSecret(ctx context.Context, k8sClient client.Client, namespace, secretName, certKey, keyKey string, logger *logrus.Logger) (string, string, error) {
 	var secret corev1.Secret
 	secretKey := client.ObjectKey{Namespace: namespace, Name: secretName}
 	if err := k8sClient.Get(ctx, secretKey, &secret); err != nil {
@@ -191,10 +320,10 @@ func fetchCertAndKeyFromSecret(ctx context.Context, k8sClient client.Client, nam
 		return "", "", fmt.Errorf("failed to fetch secret: %v", err)
 	}
 
-	cert, certOk := secret.Data["tls.crt"]
-	key, keyOk := secret.Data["tls.key"]
+	cert, certOk := secret.Data[certKey]
+	key, keyOk := secret.Data[keyKey]
 	if !certOk || !keyOk {
-		err := fmt.Errorf("secret is missing tls.crt or tls.key")
+		err := fmt.Errorf("secret is missing %s or %s", certKey, keyKey)
 		logger.Errorf("Invalid secret data: %v", err)
 		return "", "", err
 	}
@@ -203,48 +332,55 @@ func fetchCertAndKeyFromSecret(ctx context.Context, k8sClient client.Client, nam
 	return string(cert), string(key), nil
 }
 
-func ensureCertificateActivated(client *unifi.UniFiClient, certID string, logger *logrus.Logger) error {
+// ensureCertificateActivated activates certID unless it is already active. It
+// reports whether an activation took place and, if so, the ID of the
+// certificate that was active before so the change can be rolled back.
+func ensureCertificateActivated(ctx context.Context, client *unifi.UniFiClient, certID string, logger logrus.FieldLogger) (string, bool, error) {
 	logger.Infof("Ensuring certificate with ID %s is active...", certID)
 
 	// Fetch the list of certificates to find the active one
-	existingCerts, err := client.ListCertificates()
+	existingCerts, err := client.ListCertificates(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list certificates: %w", err)
+		return "", false, fmt.Errorf("failed to list certificates: %w", err)
 	}
 
+	var previousCertID string
 	for _, cert := range existingCerts {
 		if cert.Active {
 			logger.Infof("Certificate with ID %s is already active.", cert.ID)
 			if cert.ID == certID {
 				// The uploaded certificate is already active
-				return nil
+				return "", false, nil
 			}
 			logger.Warnf("A different certificate with ID %s is active.", cert.ID)
+			previousCertID = cert.ID
 		}
 	}
 
 	// Activate the certificate if it's not active
 	logger.Infof("Activating certificate with ID %s.", certID)
-	if err := client.ActivateCertificate(certID); err != nil {
-		return fmt.Errorf("failed to activate certificate with ID %s: %w", certID, err)
+	if err := client.ActivateCertificate(ctx, certID); err != nil {
+		return previousCertID, false, fmt.Errorf("failed to activate certificate with ID %s: %w", certID, err)
 	}
 
 	logger.Infof("Certificate with ID %s activated successfully.", certID)
-	return nil
+	return previousCertID, true, nil
 }
 
-func checkAndUploadCertificate(client *unifi.UniFiClient, cert, key string, logger *logrus.Logger) (string, error) {
+// checkAndUploadCertificate returns the ID of the certificate on the console,
+// uploading it first if no certificate with the same fingerprint exists.
+func checkAndUploadCertificate(ctx context.Context, client *unifi.UniFiClient, cert, key string, logger logrus.FieldLogger) (string, bool, error) {
 	// Get existing certificates
-	existingCerts, err := client.ListCertificates()
+	existingCerts, err := client.ListCertificates(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to list existing certificates: %v", err)
+		return "", false, fmt.Errorf("failed to list existing certificates: %v", err)
 	}
 	logger.Infof("Existing certificates fetched successfully: %v.", len(existingCerts))
 
 	// Calculate the fingerprint of the new certificate
 	newFingerprint, err := calculateFingerprint(cert)
 	if err != nil {
-		return "", fmt.Errorf("failed to calculate certificate fingerprint: %v", err)
+		return "", false, fmt.Errorf("failed to calculate certificate fingerprint: %v", err)
 	}
 	logger.Infof("New certificate fingerprint: %s", newFingerprint)
 
@@ -253,76 +389,63 @@ func checkAndUploadCertificate(client *unifi.UniFiClient, cert, key string, logg
 		logger.Debugf("Checking certificate with fingerprint: %s", existingCert.Fingerprint)
 		if existingCert.Fingerprint == newFingerprint {
 			logger.Info("Certificate with the same fingerprint already exists. No action required.")
-			return existingCert.ID, nil
+			return existingCert.ID, false, nil
 		}
 	}
 	logger.Info("No matching certificate found. Uploading new certificate...")
 
 	// Upload the new certificate
-	certObj, err := client.CreateCertificate(newFingerprint, cert, key)
+	certObj, err := client.CreateCertificate(ctx, newFingerprint, cert, key)
 	if err != nil {
 		logger.Errorf("Failed to upload certificate: %v", err)
-		return "", fmt.Errorf("failed to upload certificate: %v", err)
+		return "", false, fmt.Errorf("failed to upload certificate: %v", err)
 	}
 
 	logger.Info("Certificate uploaded successfully.")
-	return certObj.ID, nil
+	return certObj.ID, true, nil
 }
 
-func enforceCertificateLimit(client *unifi.UniFiClient, maxCertificates int) error {
-	logrus.Infof("Enforcing certificate limit of %d...", maxCertificates)
+// enforceCertificateLimit deletes the certificates selected by the retention
+// policy and returns how many were deleted.
+func enforceCertificateLimit(ctx context.Context, client *unifi.UniFiClient, policy RetentionPolicy, logger logrus.FieldLogger) (int, error) {
+	logger.Infof("Enforcing certificate limit of %d...", policy.MaxCerts)
 
 	// Fetch all certificates
-	certificates, err := client.ListCertificates()
+	certificates, err := client.ListCertificates(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list certificates: %w", err)
+		return 0, fmt.Errorf("failed to list certificates: %w", err)
 	}
 
-	// Find the active certificate ID
-	var activeCertID string
-	for _, cert := range certificates {
-		if cert.Active {
-			activeCertID = cert.ID
-			break
-		}
+	deletions, err := planCertificateDeletions(certificates, policy, time.Now())
+	if err != nil {
+		return 0, err
 	}
-
-	// Sort certificates by `ValidFrom` (oldest first)
-	sort.Slice(certificates, func(i, j int) bool {
-		return certificates[i].ValidFrom.Before(certificates[j].ValidFrom)
-	})
-
-	// Identify certificates to delete
-	excessCount := len(certificates) - maxCertificates
-	if excessCount <= 0 {
-		logrus.WithFields(logrus.Fields{
+	if len(deletions) == 0 {
+		logger.WithFields(logrus.Fields{
 			"current_count": len(certificates),
-			"max_count":     maxCertificates,
+			"max_count":     policy.MaxCerts,
 		}).Info("No excess certificates to delete.")
-		return nil
+		return 0, nil
 	}
 
-	logrus.Warnf("Deleting %d excess certificates to enforce limit of %d", excessCount, maxCertificates)
-
-	// Delete oldest certificates, skipping the active one
-	for _, cert := range certificates {
-		if excessCount == 0 {
-			break
-		}
+	logger.Warnf("Deleting %d certificates to enforce the retention policy", len(deletions))
 
-		if cert.ID == activeCertID {
-			logrus.Infof("Skipping active certificate with ID %s", cert.ID)
-			continue
-		}
-
-		err := client.DeleteCertificate(cert.ID)
+	deleted := 0
+	var errs []error
+	for _, deletion := range deletions {
+		err := client.DeleteCertificate(ctx, deletion.ID)
 		if err != nil {
-			logrus.WithError(err).Warnf("Failed to delete certificate with ID %s", cert.ID)
+			logger.WithError(err).Warnf("Failed to delete certificate with ID %s", deletion.ID)
+			errs = append(errs, fmt.Errorf("certificate %s: %w", deletion.ID, err))
 		} else {
-			logrus.Infof("Deleted certificate with ID %s", cert.ID)
-			excessCount--
+			logger.WithField("rule", deletion.Rule).Infof("Deleted certificate with ID %s: %s", deletion.ID, deletion.Reason)
+			deleted++
 		}
 	}
 
-	return nil
+	// Keep going past failed deletes, but report them so the prune counts as failed
+	if len(errs) > 0 {
+		return deleted, fmt.Errorf("failed to delete %d of %d certificates: %w", len(errs), len(deletions), errors.Join(errs...))
+	}
+	return deleted, nil
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "unifi_cert_updater"
+
+var (
+	certificateNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "certificate_not_after_timestamp_seconds",
+		Help:      "Expiry time of the active certificate on the console.",
+	}, []string{"console"})
+
+	lastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Time of the last successful certificate sync.",
+	}, []string{"console"})
+
+	uploadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "uploads_total",
+		Help:      "Number of certificates uploaded to the console.",
+	}, []string{"console"})
+
+	activationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "activations_total",
+		Help:      "Number of certificate activations, including rollbacks.",
+	}, []string{"console"})
+
+	deletionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deletions_total",
+		Help:      "Number of certificates deleted from the console.",
+	}, []string{"console"})
+
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "failures_total",
+		Help:      "Number of failed syncs by the stage that failed.",
+	}, []string{"console", "stage"})
+
+	collectors = []prometheus.Collector{
+		certificateNotAfter,
+		lastSuccessfulSync,
+		uploadsTotal,
+		activationsTotal,
+		deletionsTotal,
+		failuresTotal,
+	}
+)
+
+func init() {
+	// Served by the controller-runtime metrics server in daemon mode
+	ctrlmetrics.Registry.MustRegister(collectors...)
+}
+
+// pushMetrics sends the updater's metrics to a Pushgateway-compatible
+// endpoint. It is used in once mode, where there is nothing to scrape.
+func pushMetrics(url string) error {
+	pusher := push.New(url, "unifi-cert-updater")
+	for _, c := range collectors {
+		pusher = pusher.Collector(c)
+	}
+
+	if err := pusher.Push(); err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", url, err)
+	}
+	return nil
+}
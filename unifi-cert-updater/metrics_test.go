@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncMetrics(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+
+	// Two old certificates, one of which the console refuses to delete
+	var old []string
+	for _, name := range []string{"old-1", "old-2"} {
+		certPEM, keyPEM, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+		require.NoError(t, err)
+		cert, err := server.AddCertificate(name, certPEM, keyPEM, name == "old-2")
+		require.NoError(t, err)
+		old = append(old, cert.ID)
+	}
+	server.FailRequests("DELETE /api/userCertificates/"+old[0], http.StatusBadRequest, "certificate is in use")
+
+	certPEM, keyPEM, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	k8sClient := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data:       map[string][]byte{"tls.crt": []byte(certPEM), "tls.key": []byte(keyPEM)},
+	}).Build()
+
+	// Counters are process-wide, so the console name keeps this test's apart
+	const name = "metrics-test"
+	c, err := newConsole(ConsoleConfig{
+		Name:              name,
+		URL:               server.URL,
+		Username:          unifitest.DefaultUsername,
+		Password:          unifitest.DefaultPassword,
+		TLSSecret:         SecretReference{Namespace: "certs", Name: "unifi-tls"},
+		MaxCerts:          1,
+		ActivationTimeout: metav1.Duration{Duration: 5 * time.Second},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	// The upload and activation succeed, but only one of the two deletes does
+	err = c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10))
+	assert.ErrorContains(t, err, "failed to delete 1 of 2 certificates")
+	assert.Equal(t, 1.0, testutil.ToFloat64(uploadsTotal.WithLabelValues(name)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(activationsTotal.WithLabelValues(name)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(deletionsTotal.WithLabelValues(name)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(failuresTotal.WithLabelValues(name, StagePrune)))
+	assert.Zero(t, testutil.ToFloat64(lastSuccessfulSync.WithLabelValues(name)))
+
+	var active time.Time
+	for _, cert := range server.Certificates() {
+		if cert.Active {
+			active = cert.ValidTo
+		}
+	}
+	assert.Equal(t, float64(active.Unix()), testutil.ToFloat64(certificateNotAfter.WithLabelValues(name)))
+
+	// Once the delete goes through, the sync succeeds without uploading again
+	server.FailRequests("DELETE /api/userCertificates/"+old[0], 0, "")
+	require.NoError(t, c.reconcile(context.Background(), k8sClient, record.NewFakeRecorder(10)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(uploadsTotal.WithLabelValues(name)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(activationsTotal.WithLabelValues(name)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(deletionsTotal.WithLabelValues(name)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(failuresTotal.WithLabelValues(name, StagePrune)))
+	assert.Equal(t, float64(c.Status().LastSuccess.Unix()), testutil.ToFloat64(lastSuccessfulSync.WithLabelValues(name)))
+}
+
+func TestSyncMetricsFailureStage(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+
+	const name = "metrics-login-test"
+	c, err := newConsole(ConsoleConfig{
+		Name:      name,
+		URL:       server.URL,
+		Username:  unifitest.DefaultUsername,
+		Password:  "wrong",
+		TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	assert.Error(t, c.reconcile(context.Background(), fake.NewClientBuilder().Build(), record.NewFakeRecorder(10)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(failuresTotal.WithLabelValues(name, StageLogin)))
+	assert.Zero(t, testutil.ToFloat64(failuresTotal.WithLabelValues(name, StageFetch)))
+}
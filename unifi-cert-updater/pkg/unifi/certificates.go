@@ -1,18 +1,19 @@
 package unifi
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/sirupsen/logrus"
 )
 
-func (c *UniFiClient) ListCertificates() ([]Certificate, error) {
-	if !c.isUniFiOS {
+func (c *UniFiClient) ListCertificates(ctx context.Context) ([]Certificate, error) {
+	if !c.unifiOS() {
 		return nil, fmt.Errorf("ListCertificates is only supported on UniFi OS systems")
 	}
 
 	var certificates []Certificate
-	err := c.doRequest("GET", EndpointListCertificates, nil, &certificates)
+	err := c.doRequest(ctx, "GET", EndpointListCertificates, nil, &certificates)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list certificates: %w", err)
 	}
@@ -20,8 +21,8 @@ func (c *UniFiClient) ListCertificates() ([]Certificate, error) {
 }
 
 // CreateCertificate uploads a new certificate
-func (c *UniFiClient) CreateCertificate(name, cert, key string) (*Certificate, error) {
-	if !c.isUniFiOS {
+func (c *UniFiClient) CreateCertificate(ctx context.Context, name, cert, key string) (*Certificate, error) {
+	if !c.unifiOS() {
 		return nil, fmt.Errorf("CreateCertificate is only supported on UniFi OS systems")
 	}
 
@@ -32,7 +33,7 @@ func (c *UniFiClient) CreateCertificate(name, cert, key string) (*Certificate, e
 	}
 
 	var createdCert Certificate
-	err := c.doRequest("POST", EndpointCreateCertificate, payload, &createdCert)
+	err := c.doRequest(ctx, "POST", EndpointCreateCertificate, payload, &createdCert)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
@@ -42,15 +43,15 @@ func (c *UniFiClient) CreateCertificate(name, cert, key string) (*Certificate, e
 	return &createdCert, nil
 }
 
-func (c *UniFiClient) ActivateCertificate(certID string) error {
-	if !c.isUniFiOS {
+func (c *UniFiClient) ActivateCertificate(ctx context.Context, certID string) error {
+	if !c.unifiOS() {
 		return fmt.Errorf("ActivateCertificate is only supported on UniFi OS systems")
 	}
 
 	endpoint := fmt.Sprintf(EndpointActivateCertificate, certID)
 	payload := map[string]bool{"active": true}
 
-	err := c.doRequest("PUT", endpoint, payload, nil)
+	err := c.doRequest(ctx, "PUT", endpoint, payload, nil)
 	if err != nil {
 		return fmt.Errorf("failed to activate certificate with ID %s: %w", certID, err)
 	}
@@ -58,14 +59,14 @@ func (c *UniFiClient) ActivateCertificate(certID string) error {
 	logrus.Infof("Certificate with ID %s successfully activated", certID)
 	return nil
 }
-func (c *UniFiClient) DeleteCertificate(certID string) error {
-	if !c.isUniFiOS {
+func (c *UniFiClient) DeleteCertificate(ctx context.Context, certID string) error {
+	if !c.unifiOS() {
 		return fmt.Errorf("DeleteCertificate is only supported on UniFi OS systems")
 	}
 
 	endpoint := fmt.Sprintf(EndpointDeleteCertificate, certID)
 
-	err := c.doRequest("DELETE", endpoint, nil, nil)
+	err := c.doRequest(ctx, "DELETE", endpoint, nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete certificate with ID %s: %w", certID, err)
 	}
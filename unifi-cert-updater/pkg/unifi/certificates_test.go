@@ -1,6 +1,7 @@
 package unifi
 
 import (
+	"context"
 	"net/http"
 	"testing"
 
@@ -35,7 +36,7 @@ func TestListCertificates(t *testing.T) {
 			name:           "server error",
 			serverResponse: `{"error":"internal server error"}`,
 			serverStatus:   http.StatusInternalServerError,
-			expectedError:  `unexpected status code 500: {"error":"internal server error"}`,
+			expectedError:  `failed to list certificates: unexpected status code 500: {"error":"internal server error"}`,
 			expectedResult: nil,
 		},
 	}
@@ -45,7 +46,7 @@ func TestListCertificates(t *testing.T) {
 			server, client := setupTestServer(tt.serverResponse, tt.serverStatus)
 			defer server.Close()
 
-			result, err := client.ListCertificates()
+			result, err := client.ListCertificates(context.Background())
 			if tt.expectedError == "" {
 				assert.NoError(t, err)
 			} else {
@@ -83,7 +84,7 @@ func TestCreateCertificate(t *testing.T) {
 			key:            "key_data",
 			serverResponse: `{"error":"internal server error"}`,
 			serverStatus:   http.StatusInternalServerError,
-			expectedError:  "unexpected status code: 500",
+			expectedError:  `failed to create certificate: unexpected status code 500: {"error":"internal server error"}`,
 		},
 	}
 
@@ -92,7 +93,7 @@ func TestCreateCertificate(t *testing.T) {
 			server, client := setupTestServer(tt.serverResponse, tt.serverStatus)
 			defer server.Close()
 
-			_, err := client.CreateCertificate(tt.certName, tt.cert, tt.key)
+			_, err := client.CreateCertificate(context.Background(), tt.certName, tt.cert, tt.key)
 			if (err != nil && err.Error() != tt.expectedError) || (err == nil && tt.expectedError != "") {
 				t.Errorf("expected error %q, got %v", tt.expectedError, err)
 			}
@@ -120,7 +121,7 @@ func TestActivateCertificate(t *testing.T) {
 			certID:         "2",
 			serverResponse: `{"error":"internal server error"}`,
 			serverStatus:   http.StatusInternalServerError,
-			expectedError:  `unexpected status code 500: {"error":"internal server error"}`,
+			expectedError:  `failed to activate certificate with ID 2: unexpected status code 500: {"error":"internal server error"}`,
 		},
 	}
 
@@ -129,7 +130,7 @@ func TestActivateCertificate(t *testing.T) {
 			server, client := setupTestServer(tt.serverResponse, tt.serverStatus)
 			defer server.Close()
 
-			err := client.ActivateCertificate(tt.certID)
+			err := client.ActivateCertificate(context.Background(), tt.certID)
 			if (err != nil && err.Error() != tt.expectedError) || (err == nil && tt.expectedError != "") {
 				t.Errorf("expected error %q, got %v", tt.expectedError, err)
 			}
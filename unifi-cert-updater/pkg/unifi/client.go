@@ -1,30 +1,97 @@
 package unifi
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 type UniFiClient struct {
 	BaseURL  string
 	Username string
 	Password string
-	Site     string // Exported, defaults to "default"
+	APIKey   string // Sent as X-API-Key, for the integration API
+
+	TOTPSecret string // Base32 secret used to answer two-factor prompts
+	MFAToken   string // One-time code used for the next two-factor prompt only
+	Site       string // Exported, defaults to "default"
 
 	HTTPClient *http.Client
 
-	token     string // Internal, unexported
-	csrfToken string // Internal, unexported
-	isUniFiOS bool   // Internal, unexported flag
+	mu        sync.RWMutex // Guards token, csrfToken and isUniFiOS
+	token     string       // Internal, unexported
+	csrfToken string       // Internal, unexported
+	isUniFiOS bool         // Internal, unexported flag
+
+	loginMu      sync.Mutex    // Serialises logins
+	session      atomic.Uint64 // Incremented on every successful login
+	limiter      *rate.Limiter // Optional client-side request rate limit
+	pollInterval time.Duration // How often device commands poll the device
+
+	sessionStore SessionStore // Optional, persists the session between runs
+}
+
+// ClientOption configures optional features of a UniFiClient.
+type ClientOption func(*UniFiClient)
+
+// WithAPIKey authenticates requests with an API key created in the console's
+// Integrations settings. Username and password may then be left empty, but
+// only the integration API accepts the key.
+func WithAPIKey(apiKey string) ClientOption {
+	return func(c *UniFiClient) {
+		c.APIKey = apiKey
+	}
+}
+
+// WithTOTPSecret answers two-factor prompts during Login with codes generated
+// from the base32 secret shown when the authenticator was enrolled.
+func WithTOTPSecret(secret string) ClientOption {
+	return func(c *UniFiClient) {
+		c.TOTPSecret = secret
+	}
 }
 
-// NewClient initializes a new UniFi API client
-func NewClient(baseURL, username, password string, customHTTPClient *http.Client) (*UniFiClient, error) {
+// WithMFAToken answers the next two-factor prompt with a one-time code, for
+// callers that obtain codes themselves.
+func WithMFAToken(token string) ClientOption {
+	return func(c *UniFiClient) {
+		c.MFAToken = token
+	}
+}
+
+// WithRateLimit limits the client to limit requests per second with bursts of
+// up to burst requests, shared by every goroutine using the client, to avoid
+// overloading small gateways.
+func WithRateLimit(limit rate.Limit, burst int) ClientOption {
+	return func(c *UniFiClient) {
+		c.limiter = rate.NewLimiter(limit, burst)
+	}
+}
+
+// WithPollInterval sets how often device commands poll the device while
+// waiting for it to finish, 2 seconds by default.
+func WithPollInterval(interval time.Duration) ClientOption {
+	return func(c *UniFiClient) {
+		c.pollInterval = interval
+	}
+}
+
+// NewClient initializes a new UniFi API client. The client is safe for
+// concurrent use; sessions are shared and renewed once for all goroutines.
+func NewClient(baseURL, username, password string, customHTTPClient *http.Client, opts ...ClientOption) (*UniFiClient, error) {
 	var httpClient *http.Client
 
 	// Use the provided custom HTTP client if passed
@@ -47,23 +114,73 @@ func NewClient(baseURL, username, password string, customHTTPClient *http.Client
 		httpClient = &http.Client{Jar: jar}
 	}
 
-	return &UniFiClient{
+	client := &UniFiClient{
 		BaseURL:    strings.TrimRight(baseURL, "/"),
 		Username:   username,
 		Password:   password,
 		HTTPClient: httpClient,
-	}, nil
+
+		pollInterval: 2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client, nil
 }
 
 func (c *UniFiClient) setToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.token = token
 }
 
 func (c *UniFiClient) setCSRFToken(csrfToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.csrfToken = csrfToken
 }
 
-func (c *UniFiClient) Login() error {
+// sessionTokens returns the session cookie and CSRF token to send.
+func (c *UniFiClient) sessionTokens() (token, csrfToken string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token, c.csrfToken
+}
+
+// unifiOS reports whether the client is logged in to a UniFi OS console.
+func (c *UniFiClient) unifiOS() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isUniFiOS
+}
+
+// Login authenticates against the first login endpoint that accepts the
+// credentials. The context bounds the whole attempt, across all endpoints. If
+// the account requires two-factor authentication, the prompt is answered with
+// MFAToken or a code generated from TOTPSecret. With a session store, a saved
+// session is resumed instead if the console still accepts it.
+func (c *UniFiClient) Login(ctx context.Context) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.sessionStore != nil && c.resumeSession(ctx) {
+		return nil
+	}
+	return c.login(ctx)
+}
+
+// renewSession logs in again after a request made during session was
+// rejected. Concurrent callers wait for a single login: if the session has
+// changed by the time the lock is held, someone else already renewed it.
+func (c *UniFiClient) renewSession(ctx context.Context, session uint64) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.session.Load() != session {
+		return nil
+	}
+	return c.login(ctx)
+}
+
+func (c *UniFiClient) login(ctx context.Context) error {
 	logrus.Info("Attempting to log in to the UniFi API...")
 
 	// List of potential login endpoints
@@ -73,11 +190,7 @@ func (c *UniFiClient) Login() error {
 		"/api/s/default/login", // Legacy API
 	}
 
-	var loginResponse struct {
-		UniqueID    string `json:"unique_id"`
-		CsrfToken   string `json:"csrfToken,omitempty"`
-		AccessToken string `json:"access_token,omitempty"` // For future-proofing UniFi OS
-	}
+	var loginResponse loginResponse
 
 	// Iterate through endpoints and attempt login
 	for _, endpoint := range endpoints {
@@ -86,26 +199,87 @@ func (c *UniFiClient) Login() error {
 			"password": c.Password,
 		}
 
-		err := c.doRequest("POST", endpoint, payload, &loginResponse)
-		if err != nil {
+		err := c.send(ctx, "POST", endpoint, payload, &loginResponse)
+		if errors.Is(err, ErrMFARequired) {
+			// The credentials were accepted, so there is no point trying the
+			// remaining endpoints
+			if err := c.completeMFA(ctx, endpoint, payload, &loginResponse); err != nil {
+				return err
+			}
+		} else if err != nil {
+			if ctx.Err() != nil {
+				// No point trying the remaining endpoints
+				return fmt.Errorf("login aborted: %w", ctx.Err())
+			}
 			logrus.WithError(err).Warnf("Login attempt failed for endpoint: %s", endpoint)
 			continue
 		}
 
 		// Successfully logged in
+		isUniFiOS := endpoint == "/api/auth/login"
+		c.mu.Lock()
 		c.token = extractTokenFromCookies(c.HTTPClient.Jar, c.BaseURL)
 		if loginResponse.CsrfToken != "" {
 			c.csrfToken = loginResponse.CsrfToken
 		}
+		c.isUniFiOS = isUniFiOS
+		c.mu.Unlock()
 
-		c.isUniFiOS = endpoint == "/api/auth/login"
-		logrus.Infof("Login successful. Detected UniFi OS: %v", c.isUniFiOS)
+		c.session.Add(1)
+		logrus.Infof("Login successful. Detected UniFi OS: %v", isUniFiOS)
+		if c.sessionStore != nil {
+			c.saveSession(ctx)
+		}
 		return nil
 	}
 
 	return fmt.Errorf("all login attempts failed")
 }
 
+// loginResponse is the UniFi OS login response. Legacy controllers answer
+// with an empty data list instead, which leaves it empty.
+type loginResponse struct {
+	UniqueID    string `json:"unique_id"`
+	CsrfToken   string `json:"csrfToken,omitempty"`
+	AccessToken string `json:"access_token,omitempty"` // For future-proofing UniFi OS
+}
+
+func (r *loginResponse) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return nil
+	}
+	type plain loginResponse
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// completeMFA repeats a login that was answered with a two-factor prompt,
+// adding a one-time code.
+func (c *UniFiClient) completeMFA(ctx context.Context, endpoint string, payload map[string]string, response interface{}) error {
+	code := c.MFAToken
+	c.MFAToken = ""
+	if code == "" {
+		if c.TOTPSecret == "" {
+			return fmt.Errorf("login requires two-factor authentication but no TOTP secret or token is configured: %w", ErrMFARequired)
+		}
+		var err error
+		if code, err = totpCode(c.TOTPSecret, time.Now()); err != nil {
+			return fmt.Errorf("failed to generate TOTP code: %w", err)
+		}
+	}
+
+	logrus.Infof("Two-factor authentication required, answering with a one-time code on %s", endpoint)
+	mfaPayload := maps.Clone(payload)
+	if endpoint == EndpointLogin {
+		mfaPayload["token"] = code
+	} else {
+		mfaPayload["ubic_2fa_token"] = code
+	}
+	if err := c.send(ctx, "POST", endpoint, mfaPayload, response); err != nil {
+		return fmt.Errorf("two-factor login failed: %w", err)
+	}
+	return nil
+}
+
 // Helper to extract the TOKEN from cookies
 func extractTokenFromCookies(jar http.CookieJar, baseURL string) string {
 	parsedURL, err := url.Parse(baseURL)
@@ -1,8 +1,16 @@
 package unifi
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestServer(response string, status int) (*httptest.Server, *UniFiClient) {
@@ -14,7 +22,41 @@ func setupTestServer(response string, status int) (*httptest.Server, *UniFiClien
 	client := &UniFiClient{
 		BaseURL:    server.URL,
 		HTTPClient: server.Client(),
+		isUniFiOS:  true,
 	}
 
 	return server, client
 }
+
+func TestContextCancelsHungRequest(t *testing.T) {
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewClient(server.URL, "admin", "secret", server.Client())
+	require.NoError(t, err)
+	client.isUniFiOS = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.ListCertificates(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// Login gives up on the remaining endpoints once the context is done
+	attempts.Store(0)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Login(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.EqualValues(t, 1, attempts.Load())
+}
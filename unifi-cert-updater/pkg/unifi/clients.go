@@ -1,31 +1,98 @@
 package unifi
 
-import "fmt"
+import "context"
 
-func (c *UniFiClient) ListClients(site string) ([]Client, error) {
-	endpoint := fmt.Sprintf("/api/s/%s/stat/sta", site)
+func (c *UniFiClient) ListClients(ctx context.Context, site string) ([]Client, error) {
+	endpoint := c.networkEndpoint(EndpointListClients, site)
 	var clients []Client
-	err := c.doRequest("GET", endpoint, nil, &clients)
+	err := c.doRequest(ctx, "GET", endpoint, nil, &clients)
 	if err != nil {
 		return nil, err
 	}
 	return clients, nil
 }
 
-func (c *UniFiClient) AuthorizeGuest(site, mac string, duration int) error {
-	endpoint := fmt.Sprintf("/api/s/%s/cmd/stamgr", site)
+// AuthorizeGuest lets a guest through the hotspot for duration minutes.
+func (c *UniFiClient) AuthorizeGuest(ctx context.Context, site, mac string, duration int) error {
+	return c.AuthorizeGuestWithLimits(ctx, site, GuestAuthorizePayload{MAC: mac, Minutes: duration})
+}
+
+// AuthorizeGuestWithLimits lets a guest through the hotspot with the bandwidth
+// and data limits in payload.
+func (c *UniFiClient) AuthorizeGuestWithLimits(ctx context.Context, site string, payload GuestAuthorizePayload) error {
+	endpoint := c.networkEndpoint(EndpointAuthorizeGuest, site)
+	payload.Cmd = "authorize-guest" // Mandatory command
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+func (c *UniFiClient) UnauthorizeGuest(ctx context.Context, site, mac string) error {
+	endpoint := c.networkEndpoint(EndpointUnauthorizeGuest, site)
 	payload := map[string]interface{}{
-		"cmd":     "authorize-guest",
-		"mac":     mac,
-		"minutes": duration,
+		"cmd": "unauthorize-guest",
+		"mac": mac,
 	}
-	return c.doRequest("POST", endpoint, payload, nil)
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
 }
-func (c *UniFiClient) UnauthorizeGuest(site, mac string) error {
-	endpoint := fmt.Sprintf("/api/s/%s/cmd/stamgr", site)
+
+// BlockClient disconnects a client and keeps it from connecting again.
+func (c *UniFiClient) BlockClient(ctx context.Context, site, mac string) error {
+	endpoint := c.networkEndpoint(EndpointBlockClient, site)
 	payload := map[string]interface{}{
-		"cmd": "unauthorize-guest",
+		"cmd": "block-sta",
+		"mac": mac,
+	}
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// UnblockClient lets a blocked client connect again.
+func (c *UniFiClient) UnblockClient(ctx context.Context, site, mac string) error {
+	endpoint := c.networkEndpoint(EndpointUnblockClient, site)
+	payload := map[string]interface{}{
+		"cmd": "unblock-sta",
+		"mac": mac,
+	}
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// ReconnectClient kicks a wireless client off its AP, forcing it to
+// reconnect.
+func (c *UniFiClient) ReconnectClient(ctx context.Context, site, mac string) error {
+	endpoint := c.networkEndpoint(EndpointReconnectClient, site)
+	payload := map[string]interface{}{
+		"cmd": "kick-sta",
 		"mac": mac,
 	}
-	return c.doRequest("POST", endpoint, payload, nil)
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// ForgetClient removes the history, name and note the controller keeps for
+// the given clients.
+func (c *UniFiClient) ForgetClient(ctx context.Context, site string, macs ...string) error {
+	endpoint := c.networkEndpoint(EndpointForgetClient, site)
+	payload := map[string]interface{}{
+		"cmd":  "forget-sta",
+		"macs": macs,
+	}
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// SetClientName sets the alias shown for a client. An empty name clears it.
+// userID is the client's Client.UserID.
+func (c *UniFiClient) SetClientName(ctx context.Context, site, userID, name string) error {
+	endpoint := c.networkEndpoint(EndpointUpdateClient, site, userID)
+	payload := map[string]interface{}{
+		"name": name,
+	}
+	return c.doRequest(ctx, "PUT", endpoint, payload, nil)
+}
+
+// SetClientNote sets the note stored with a client. An empty note clears it.
+// userID is the client's Client.UserID.
+func (c *UniFiClient) SetClientNote(ctx context.Context, site, userID, note string) error {
+	endpoint := c.networkEndpoint(EndpointUpdateClient, site, userID)
+	payload := map[string]interface{}{
+		"note":  note,
+		"noted": note != "",
+	}
+	return c.doRequest(ctx, "PUT", endpoint, payload, nil)
 }
@@ -0,0 +1,134 @@
+package unifi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCommands(t *testing.T) {
+	// Payloads as sent by the Network application's web UI
+	tests := []struct {
+		name   string
+		call   func(ctx context.Context, c *unifi.UniFiClient) error
+		method string
+		path   string
+		body   string
+	}{
+		{
+			name: "BlockClient",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.BlockClient(ctx, "default", "11:22:33:44:55:66")
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"block-sta","mac":"11:22:33:44:55:66"}`,
+		},
+		{
+			name: "UnblockClient",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.UnblockClient(ctx, "default", "11:22:33:44:55:66")
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"unblock-sta","mac":"11:22:33:44:55:66"}`,
+		},
+		{
+			name: "ReconnectClient",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.ReconnectClient(ctx, "default", "11:22:33:44:55:66")
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"kick-sta","mac":"11:22:33:44:55:66"}`,
+		},
+		{
+			name: "ForgetClient",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.ForgetClient(ctx, "default", "11:22:33:44:55:66", "aa:bb:cc:dd:ee:ff")
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"forget-sta","macs":["11:22:33:44:55:66","aa:bb:cc:dd:ee:ff"]}`,
+		},
+		{
+			name: "SetClientName",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.SetClientName(ctx, "default", "5f1e2d3c4b5a697887766554", "Living room TV")
+			},
+			method: "PUT",
+			path:   "/api/s/default/rest/user/5f1e2d3c4b5a697887766554",
+			body:   `{"name":"Living room TV"}`,
+		},
+		{
+			name: "SetClientNote",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.SetClientNote(ctx, "default", "5f1e2d3c4b5a697887766554", "Replaced 2025-03")
+			},
+			method: "PUT",
+			path:   "/api/s/default/rest/user/5f1e2d3c4b5a697887766554",
+			body:   `{"note":"Replaced 2025-03","noted":true}`,
+		},
+		{
+			name: "ClearClientNote",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.SetClientNote(ctx, "default", "5f1e2d3c4b5a697887766554", "")
+			},
+			method: "PUT",
+			path:   "/api/s/default/rest/user/5f1e2d3c4b5a697887766554",
+			body:   `{"note":"","noted":false}`,
+		},
+		{
+			name: "AuthorizeGuest",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.AuthorizeGuest(ctx, "default", "11:22:33:44:55:66", 60)
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"authorize-guest","mac":"11:22:33:44:55:66","minutes":60}`,
+		},
+		{
+			name: "AuthorizeGuestWithLimits",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.AuthorizeGuestWithLimits(ctx, "default", unifi.GuestAuthorizePayload{
+					MAC:     "11:22:33:44:55:66",
+					Minutes: 480,
+					Up:      2048,
+					Down:    10240,
+					Bytes:   1024,
+					APMAC:   "aa:bb:cc:dd:ee:ff",
+				})
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"authorize-guest","mac":"11:22:33:44:55:66","minutes":480,"up":2048,"down":10240,"bytes":1024,"ap_mac":"aa:bb:cc:dd:ee:ff"}`,
+		},
+		{
+			name: "UnauthorizeGuest",
+			call: func(ctx context.Context, c *unifi.UniFiClient) error {
+				return c.UnauthorizeGuest(ctx, "default", "11:22:33:44:55:66")
+			},
+			method: "POST",
+			path:   "/api/s/default/cmd/stamgr",
+			body:   `{"cmd":"unauthorize-guest","mac":"11:22:33:44:55:66"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := newConsoleClient(t, unifitest.WithLegacyController())
+			server.AddClient("default", unifi.Client{Mac: "11:22:33:44:55:66", UserID: "5f1e2d3c4b5a697887766554"})
+			server.AddClient("default", unifi.Client{Mac: "aa:bb:cc:dd:ee:ff"})
+			require.NoError(t, tt.call(context.Background(), client))
+
+			requests := server.Requests()
+			request := tt.method + " " + tt.path
+			assert.Equal(t, request, requests[len(requests)-1])
+			assert.JSONEq(t, tt.body, server.RequestBody(request))
+		})
+	}
+}
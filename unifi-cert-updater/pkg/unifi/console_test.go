@@ -0,0 +1,243 @@
+package unifi_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// These tests run the client against the in-memory console in unifitest.
+// The tests of each feature live next to its source file and share
+// newConsoleClient.
+
+func newConsoleClient(t *testing.T, opts ...unifitest.Option) (*unifitest.Server, *unifi.UniFiClient) {
+	server := unifitest.NewServer(opts...)
+	t.Cleanup(server.Close)
+
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, client.Login(context.Background()))
+	return server, client
+}
+
+func TestLoginFallsBackToLegacyController(t *testing.T) {
+	server, client := newConsoleClient(t, unifitest.WithLegacyController())
+	ctx := context.Background()
+
+	sites, err := client.ListSites(ctx)
+	require.NoError(t, err)
+	require.Len(t, sites, 1)
+	assert.Equal(t, "default", sites[0].Name)
+
+	assert.Equal(t, []string{
+		"POST /api/auth/login",
+		"POST /api/login",
+		"GET /api/self/sites",
+	}, server.Requests())
+
+	_, err = client.ListCertificates(ctx)
+	assert.EqualError(t, err, "ListCertificates is only supported on UniFi OS systems")
+}
+
+func TestLoginRejectsWrongCredentials(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+
+	client, err := unifi.NewClient(server.URL, "admin", "wrong", server.Client())
+	require.NoError(t, err)
+	assert.EqualError(t, client.Login(context.Background()), "all login attempts failed")
+	assert.Zero(t, server.Logins())
+}
+
+func TestCSRFRotation(t *testing.T) {
+	server, client := newConsoleClient(t)
+	server.AddClient("default", unifi.Client{Mac: "11:22:33:44:55:66"})
+	ctx := context.Background()
+
+	// A rotated token is picked up from the next response
+	server.RotateCSRFTokens()
+	_, err := client.ListClients(ctx, "default")
+	require.NoError(t, err)
+	require.NoError(t, client.BlockClient(ctx, "default", "11:22:33:44:55:66"))
+	assert.Equal(t, 1, server.Logins())
+
+	// A write with a stale token is retried after logging in again
+	server.RotateCSRFTokens()
+	require.NoError(t, client.UnblockClient(ctx, "default", "11:22:33:44:55:66"))
+	assert.Equal(t, 2, server.Logins())
+	assert.False(t, server.Clients("default")[0].Blocked)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	server, client := newConsoleClient(t)
+
+	server.ExpireSessions()
+	_, err := client.ListDevices(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.Logins())
+}
+
+func TestCertificateLifecycle(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+
+	oldCert, oldKey, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	old, err := server.AddCertificate("old", oldCert, oldKey, true)
+	require.NoError(t, err)
+
+	certPEM, keyPEM, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+	require.NoError(t, err)
+	created, err := client.CreateCertificate(ctx, "new", certPEM, keyPEM)
+	require.NoError(t, err)
+	assert.Equal(t, "unifi.example.com", created.Subject.CN)
+	assert.Equal(t, []string{"unifi.example.com"}, created.SubjectAlt.DNS)
+	assert.False(t, created.Active)
+
+	require.NoError(t, client.ActivateCertificate(ctx, created.ID))
+	certificates, err := client.ListCertificates(ctx)
+	require.NoError(t, err)
+	require.Len(t, certificates, 2)
+	assert.False(t, certificates[0].Active)
+	assert.True(t, certificates[1].Active)
+
+	// The active certificate cannot be deleted, the old one can
+	err = client.DeleteCertificate(ctx, created.ID)
+	var apiErr *unifi.APIError
+	require.True(t, errors.As(err, &apiErr), "unexpected error: %v", err)
+	assert.Equal(t, 400, apiErr.StatusCode)
+	require.NoError(t, client.DeleteCertificate(ctx, old.ID))
+	assert.Len(t, server.Certificates(), 1)
+
+	_, err = client.CreateCertificate(ctx, "broken", certPEM, oldKey)
+	assert.ErrorContains(t, err, "invalid certificate or key")
+}
+
+func TestSitesDevicesClientsAndVouchers(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+
+	server.AddDevice("default", unifi.Device{MAC: "aa:bb:cc:dd:ee:ff", Name: "Office AP", Adopted: true, State: unifi.DeviceStateConnected, Uptime: 3600})
+	server.AddDevice("default", unifi.Device{MAC: "00:11:22:33:44:55", Name: "New switch", State: unifi.DeviceStatePendingAdoption})
+	guest := server.AddClient("default", unifi.Client{Mac: "11:22:33:44:55:66", IsGuest: true, Connected: true})
+
+	stats, err := client.ListSiteStats(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.NumDevices)
+	assert.Equal(t, 1, stats.NumGuestDevices)
+
+	_, err = client.ListDevices(ctx, "elsewhere")
+	assert.ErrorContains(t, err, "api.err.NoSiteContext")
+
+	_, err = client.GetDevice(ctx, "default", "ff:ff:ff:ff:ff:ff")
+	assert.ErrorIs(t, err, unifi.ErrNotFound)
+
+	require.NoError(t, client.RestartDevice(ctx, "default", "aa:bb:cc:dd:ee:ff", 5*time.Second))
+	require.NoError(t, client.AdoptDevice(ctx, "default", "00:11:22:33:44:55", 5*time.Second))
+	device, err := client.GetDevice(ctx, "default", "00:11:22:33:44:55")
+	require.NoError(t, err)
+	assert.True(t, device.Adopted)
+
+	require.NoError(t, client.AuthorizeGuest(ctx, "default", guest.Mac, 60))
+	require.NoError(t, client.SetClientName(ctx, "default", guest.UserID, "Visitor"))
+	clients, err := client.ListClients(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, clients, 1)
+	assert.True(t, clients[0].Authorized)
+	assert.Equal(t, "Visitor", clients[0].Name)
+
+	require.NoError(t, client.ForgetClient(ctx, "default", guest.Mac))
+	assert.Empty(t, server.Clients("default"))
+
+	created, err := client.CreateVoucher(ctx, "default", unifi.VoucherCreatePayload{Minutes: 1440, Quota: 1, Note: "lobby"})
+	require.NoError(t, err)
+	vouchers := server.Vouchers("default")
+	require.Len(t, vouchers, 1)
+	assert.Equal(t, vouchers, created)
+	assert.Equal(t, 1440, vouchers[0].Duration)
+	assert.Equal(t, "lobby", vouchers[0].Note)
+}
+
+func TestConcurrentUse(t *testing.T) {
+	server, client := newConsoleClient(t)
+	server.AddSite(unifi.Site{Name: "office"})
+	for _, site := range []string{"default", "office"} {
+		server.AddDevice(site, unifi.Device{MAC: "aa:bb:cc:dd:ee:ff", Adopted: true, State: unifi.DeviceStateConnected})
+		server.AddClient(site, unifi.Client{Mac: "11:22:33:44:55:66"})
+	}
+	ctx := context.Background()
+
+	calls := []func(site string) error{
+		func(site string) error { _, err := client.ListDevices(ctx, site); return err },
+		func(site string) error { _, err := client.ListClients(ctx, site); return err },
+		func(site string) error { return client.BlockClient(ctx, site, "11:22:33:44:55:66") },
+		func(site string) error { return client.SetLocate(ctx, site, "aa:bb:cc:dd:ee:ff", true, 0) },
+		func(string) error { _, err := client.ListCertificates(ctx); return err },
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Invalidate the CSRF token and the session once each, so that
+			// token updates and renewals race with requests
+			switch i {
+			case 3:
+				server.RotateCSRFTokens()
+			case 7:
+				server.ExpireSessions()
+			}
+			site := []string{"default", "office"}[i%2]
+			for _, call := range calls {
+				errs <- call(site)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.True(t, server.Clients("office")[0].Blocked)
+}
+
+func TestRateLimit(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(),
+		unifi.WithRateLimit(rate.Every(20*time.Millisecond), 1))
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, client.Login(ctx))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ListSites(ctx)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 180*time.Millisecond, "requests were not rate limited")
+
+	// Waiting for the limiter honours the context
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.ListSites(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -1,23 +1,258 @@
 package unifi
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
 
-func (c *UniFiClient) ListDevices(site string) ([]Device, error) {
-	endpoint := fmt.Sprintf(EndpointListDevices, site)
+func (c *UniFiClient) ListDevices(ctx context.Context, site string) ([]Device, error) {
+	endpoint := c.networkEndpoint(EndpointListDevices, site)
 	var devices []Device
-	err := c.doRequest("GET", endpoint, nil, &devices)
+	err := c.doRequest(ctx, "GET", endpoint, nil, &devices)
 	if err != nil {
 		return nil, err
 	}
 	return devices, nil
 }
 
-func (c *UniFiClient) GetDevice(site, mac string) (Device, error) {
-	endpoint := fmt.Sprintf("/api/s/%s/stat/device/%s", site, mac)
-	var device Device
-	err := c.doRequest("GET", endpoint, nil, &device)
+func (c *UniFiClient) GetDevice(ctx context.Context, site, mac string) (Device, error) {
+	endpoint := c.networkEndpoint(EndpointGetDevice, site, mac)
+	var devices []Device
+	err := c.doRequest(ctx, "GET", endpoint, nil, &devices)
 	if err != nil {
-		return device, err
+		return Device{}, err
+	}
+	if len(devices) == 0 {
+		return Device{}, fmt.Errorf("device %s: %w", mac, ErrNotFound)
+	}
+	return devices[0], nil
+}
+
+// The device commands below wait for the device to finish the command when
+// timeout is positive, and return as soon as the console accepted it
+// otherwise.
+
+// RestartDevice reboots a device and waits for it to come back.
+func (c *UniFiClient) RestartDevice(ctx context.Context, site, mac string, timeout time.Duration) error {
+	var before Device
+	if timeout > 0 {
+		var err error
+		if before, err = c.GetDevice(ctx, site, mac); err != nil {
+			return err
+		}
+	}
+
+	payload := map[string]interface{}{
+		"cmd":         "restart",
+		"mac":         mac,
+		"reboot_type": "soft",
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	// A quick reboot can be missed between two polls, but never resets the
+	// uptime unnoticed
+	restarted := false
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		if d.State != DeviceStateConnected || d.Uptime < before.Uptime {
+			restarted = true
+		}
+		return restarted && d.State == DeviceStateConnected, nil
+	})
+}
+
+// SetLocate starts or stops flashing a device's LED and waits for the device
+// to report it.
+func (c *UniFiClient) SetLocate(ctx context.Context, site, mac string, enabled bool, timeout time.Duration) error {
+	cmd := "unset-locate"
+	if enabled {
+		cmd = "set-locate"
+	}
+	payload := map[string]interface{}{
+		"cmd": cmd,
+		"mac": mac,
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		return d.Locating == enabled, nil
+	})
+}
+
+// AdoptDevice adopts a device that is pending adoption and waits for it to
+// connect.
+func (c *UniFiClient) AdoptDevice(ctx context.Context, site, mac string, timeout time.Duration) error {
+	payload := map[string]interface{}{
+		"cmd": "adopt",
+		"mac": mac,
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		switch d.State {
+		case DeviceStateAdoptionError, DeviceStateAdoptionFailed:
+			return false, fmt.Errorf("adoption of device %s failed: %s", mac, d.State)
+		case DeviceStateConnected:
+			return d.Adopted, nil
+		}
+		return false, nil
+	})
+}
+
+// ForceProvision pushes the device's configuration to it again and waits for
+// it to finish provisioning.
+func (c *UniFiClient) ForceProvision(ctx context.Context, site, mac string, timeout time.Duration) error {
+	var before Device
+	if timeout > 0 {
+		var err error
+		if before, err = c.GetDevice(ctx, site, mac); err != nil {
+			return err
+		}
+	}
+
+	payload := map[string]interface{}{
+		"cmd": "force-provision",
+		"mac": mac,
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	// Provisioning often takes less than a poll, but always moves the
+	// provisioning time on. Devices that do not report it are done as soon as
+	// they are seen connected.
+	provisioned := false
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		if d.State != DeviceStateConnected || d.ProvisionedAt != before.ProvisionedAt || d.ProvisionedAt == 0 {
+			provisioned = true
+		}
+		return provisioned && d.State == DeviceStateConnected, nil
+	})
+}
+
+// UpgradeDevice upgrades a device to the latest firmware known to the
+// controller, or to the firmware at firmwareURL if set, and waits for it to
+// come back running a different version.
+func (c *UniFiClient) UpgradeDevice(ctx context.Context, site, mac, firmwareURL string, timeout time.Duration) error {
+	var before Device
+	if timeout > 0 {
+		var err error
+		if before, err = c.GetDevice(ctx, site, mac); err != nil {
+			return err
+		}
+	}
+
+	payload := map[string]interface{}{
+		"cmd": "upgrade",
+		"mac": mac,
+	}
+	if firmwareURL != "" {
+		payload["cmd"] = "upgrade-external"
+		payload["url"] = firmwareURL
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	upgrading := false
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		if d.State != DeviceStateConnected {
+			upgrading = true
+			return false, nil
+		}
+		if d.Version != before.Version {
+			return true, nil
+		}
+		if upgrading {
+			return false, fmt.Errorf("device %s came back still running firmware %s", mac, d.Version)
+		}
+		return false, nil
+	})
+}
+
+// PowerCyclePort cuts PoE power to a switch port and waits for the link to
+// come back up.
+func (c *UniFiClient) PowerCyclePort(ctx context.Context, site, mac string, portIdx int, timeout time.Duration) error {
+	var before Port
+	if timeout > 0 {
+		device, err := c.GetDevice(ctx, site, mac)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(device.PortTable, func(p Port) bool { return p.PortIdx == portIdx })
+		if i < 0 {
+			return fmt.Errorf("device %s has no port %d", mac, portIdx)
+		}
+		before = device.PortTable[i]
+	}
+
+	payload := map[string]interface{}{
+		"cmd":      "power-cycle",
+		"mac":      mac,
+		"port_idx": portIdx,
+	}
+	if err := c.deviceCommand(ctx, site, payload); err != nil || timeout <= 0 {
+		return err
+	}
+
+	// Like a reboot, a link that drops between two polls still resets the
+	// port's uptime. Ports that do not report it are done once seen up.
+	cycled := false
+	return c.waitForDevice(ctx, site, mac, timeout, func(d Device) (bool, error) {
+		for _, port := range d.PortTable {
+			if port.PortIdx != portIdx {
+				continue
+			}
+			if !port.Up || port.Uptime < before.Uptime || port.Uptime == 0 {
+				cycled = true
+			}
+			return cycled && port.Up, nil
+		}
+		return false, fmt.Errorf("device %s has no port %d", mac, portIdx)
+	})
+}
+
+func (c *UniFiClient) deviceCommand(ctx context.Context, site string, payload map[string]interface{}) error {
+	endpoint := c.networkEndpoint(EndpointDeviceCmd, site)
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// waitForDevice polls a device until done reports it has reached the wanted
+// state, done returns an error, or timeout expires.
+func (c *UniFiClient) waitForDevice(ctx context.Context, site, mac string, timeout time.Duration, done func(Device) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	var last Device
+	for {
+		device, err := c.GetDevice(ctx, site, mac)
+		switch {
+		case err == nil:
+			last = device
+			if ok, err := done(device); ok || err != nil {
+				return err
+			}
+		case ctx.Err() != nil:
+		case errors.Is(err, ErrNotFound):
+			// Devices can briefly disappear from the listing while rebooting
+		default:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for device %s (last state %s): %w", mac, last.State, ctx.Err())
+		case <-ticker.C:
+		}
 	}
-	return device, nil
 }
@@ -0,0 +1,157 @@
+package unifi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDeviceConsoleClient starts a legacy controller whose device goes
+// through states, one per poll of the client.
+func newDeviceConsoleClient(t *testing.T, states ...unifi.Device) (*unifitest.Server, *unifi.UniFiClient) {
+	server := unifitest.NewServer(unifitest.WithLegacyController())
+	t.Cleanup(server.Close)
+	server.AddDevice("default", states[0])
+	server.QueueDeviceStates("default", states[0].MAC, states...)
+
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+	return server, client
+}
+
+func TestDeviceCommands(t *testing.T) {
+	const (
+		mac    = "aa:bb:cc:dd:ee:ff"
+		devmgr = "POST /api/s/default/cmd/devmgr"
+	)
+	ctx := context.Background()
+	connected := unifi.Device{MAC: mac, Adopted: true, State: unifi.DeviceStateConnected, Uptime: 86400, ProvisionedAt: 1735689600, Version: "6.6.55"}
+	with := func(update func(d *unifi.Device)) unifi.Device {
+		d := connected
+		update(&d)
+		return d
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t,
+			connected,
+			connected,
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateDisconnected }),
+			with(func(d *unifi.Device) { d.Uptime = 30 }),
+		)
+		require.NoError(t, client.RestartDevice(ctx, "default", mac, time.Second))
+		assert.JSONEq(t, `{"cmd":"restart","mac":"aa:bb:cc:dd:ee:ff","reboot_type":"soft"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("restart missed between polls", func(t *testing.T) {
+		_, client := newDeviceConsoleClient(t, connected, with(func(d *unifi.Device) { d.Uptime = 30 }))
+		require.NoError(t, client.RestartDevice(ctx, "default", mac, time.Second))
+	})
+
+	t.Run("restart without waiting", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t, with(func(d *unifi.Device) { d.State = unifi.DeviceStateDisconnected }))
+		require.NoError(t, client.RestartDevice(ctx, "default", mac, 0))
+		assert.Contains(t, server.Requests(), devmgr)
+		assert.NotContains(t, server.Requests(), "GET /api/s/default/stat/device/"+mac)
+	})
+
+	t.Run("restart timeout", func(t *testing.T) {
+		_, client := newDeviceConsoleClient(t, connected, with(func(d *unifi.Device) { d.State = unifi.DeviceStateHeartbeatMissed }))
+		err := client.RestartDevice(ctx, "default", mac, 50*time.Millisecond)
+		assert.ErrorContains(t, err, "timed out waiting for device aa:bb:cc:dd:ee:ff (last state heartbeat missed)")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("locate", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t, connected, with(func(d *unifi.Device) { d.Locating = true }))
+		require.NoError(t, client.SetLocate(ctx, "default", mac, true, time.Second))
+		assert.JSONEq(t, `{"cmd":"set-locate","mac":"aa:bb:cc:dd:ee:ff"}`, server.RequestBody(devmgr))
+		require.NoError(t, client.SetLocate(ctx, "default", mac, false, 0))
+		assert.JSONEq(t, `{"cmd":"unset-locate","mac":"aa:bb:cc:dd:ee:ff"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t,
+			with(func(d *unifi.Device) { d.Adopted = false; d.State = unifi.DeviceStatePendingAdoption }),
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateAdopting }),
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateProvisioning }),
+			connected,
+		)
+		require.NoError(t, client.AdoptDevice(ctx, "default", mac, time.Second))
+		assert.JSONEq(t, `{"cmd":"adopt","mac":"aa:bb:cc:dd:ee:ff"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("adoption failed", func(t *testing.T) {
+		_, client := newDeviceConsoleClient(t,
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateAdopting }),
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateAdoptionFailed }),
+		)
+		err := client.AdoptDevice(ctx, "default", mac, time.Second)
+		assert.EqualError(t, err, "adoption of device aa:bb:cc:dd:ee:ff failed: adoption failed")
+	})
+
+	t.Run("force provision", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t,
+			connected,
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateProvisioning }),
+			connected,
+		)
+		require.NoError(t, client.ForceProvision(ctx, "default", mac, time.Second))
+		assert.JSONEq(t, `{"cmd":"force-provision","mac":"aa:bb:cc:dd:ee:ff"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("force provision missed between polls", func(t *testing.T) {
+		_, client := newDeviceConsoleClient(t, connected, with(func(d *unifi.Device) { d.ProvisionedAt += 5 }))
+		require.NoError(t, client.ForceProvision(ctx, "default", mac, time.Second))
+	})
+
+	t.Run("force provision not started", func(t *testing.T) {
+		_, client := newDeviceConsoleClient(t, connected)
+		err := client.ForceProvision(ctx, "default", mac, 50*time.Millisecond)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t,
+			connected,
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateUpgrading }),
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateDisconnected }),
+			with(func(d *unifi.Device) { d.Version = "6.6.77"; d.Uptime = 10 }),
+		)
+		require.NoError(t, client.UpgradeDevice(ctx, "default", mac, "", time.Second))
+		assert.JSONEq(t, `{"cmd":"upgrade","mac":"aa:bb:cc:dd:ee:ff"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("upgrade to custom firmware", func(t *testing.T) {
+		server, client := newDeviceConsoleClient(t,
+			connected,
+			with(func(d *unifi.Device) { d.State = unifi.DeviceStateUpgrading }),
+			connected,
+		)
+		err := client.UpgradeDevice(ctx, "default", mac, "https://fw.example.com/u6pro.bin", time.Second)
+		assert.EqualError(t, err, "device aa:bb:cc:dd:ee:ff came back still running firmware 6.6.55")
+		assert.JSONEq(t, `{"cmd":"upgrade-external","mac":"aa:bb:cc:dd:ee:ff","url":"https://fw.example.com/u6pro.bin"}`, server.RequestBody(devmgr))
+	})
+
+	t.Run("power cycle port", func(t *testing.T) {
+		ports := func(up bool, uptime int64) unifi.Device {
+			return with(func(d *unifi.Device) {
+				d.PortTable = []unifi.Port{{PortIdx: 1, Up: true, Uptime: 86400}, {PortIdx: 5, Up: up, Uptime: uptime, PoEMode: "auto"}}
+			})
+		}
+		server, client := newDeviceConsoleClient(t, ports(true, 3600), ports(true, 3601), ports(false, 0), ports(true, 2))
+		require.NoError(t, client.PowerCyclePort(ctx, "default", mac, 5, time.Second))
+		assert.JSONEq(t, `{"cmd":"power-cycle","mac":"aa:bb:cc:dd:ee:ff","port_idx":5}`, server.RequestBody(devmgr))
+
+		_, client = newDeviceConsoleClient(t, ports(true, 3600), ports(true, 3))
+		require.NoError(t, client.PowerCyclePort(ctx, "default", mac, 5, time.Second), "a cycle missed between polls still resets the uptime")
+
+		err := client.PowerCyclePort(ctx, "default", mac, 9, time.Second)
+		assert.EqualError(t, err, "device aa:bb:cc:dd:ee:ff has no port 9")
+	})
+}
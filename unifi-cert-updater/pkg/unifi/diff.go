@@ -0,0 +1,108 @@
+package unifi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldChange is a setting an update would change, named by its JSON key.
+type FieldChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, formatFieldValue(c.Old), formatFieldValue(c.New))
+}
+
+func formatFieldValue(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// diffFields compares the JSON fields of two structs of the same type, in
+// declaration order, ignoring the keys in skip.
+func diffFields(current, desired any, skip ...string) []FieldChange {
+	var changes []FieldChange
+	a, b := reflect.ValueOf(current), reflect.ValueOf(desired)
+	for _, field := range jsonFields(a.Type()) {
+		if slices.Contains(skip, field.name) {
+			continue
+		}
+		before, after := a.FieldByIndex(field.index).Interface(), b.FieldByIndex(field.index).Interface()
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, FieldChange{Field: field.name, Old: before, New: after})
+		}
+	}
+	return changes
+}
+
+type jsonField struct {
+	name  string
+	index []int
+}
+
+// jsonFields lists the fields of a struct type that encoding/json encodes,
+// including those promoted from embedded structs.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+// The console's configuration objects carry many more settings than the types
+// in this package cover. These helpers keep the rest in an Extra map, so that
+// an object read, changed and written back does not lose them.
+
+// marshalWithExtra encodes v, which must not have a MarshalJSON method, and
+// adds the keys of extra it does not set itself.
+func marshalWithExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// unmarshalExtra returns the keys of data that are not fields of t.
+func unmarshalExtra(data []byte, t reflect.Type) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range jsonFields(t) {
+		delete(fields, field.name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
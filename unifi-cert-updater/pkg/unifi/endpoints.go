@@ -1,10 +1,17 @@
 package unifi
 
+// NetworkPathPrefix is where UniFi OS serves the Network application's API.
+// The auth and certificate endpoints belong to UniFi OS itself and stay at the
+// root; every /api/s/{site}/ and /api/self/ endpoint moves under this prefix.
+const NetworkPathPrefix = "/proxy/network"
+
 // Authentication
 const (
-	EndpointLogin  = "/api/auth/login" // Login to UniFi OS or UDM
-	EndpointLogout = "/api/logout"     // Logout from UniFi OS or UDM
-	EndpointSelf   = "/api/users/self" // Get current user details
+	EndpointLogin      = "/api/auth/login"  // Login to UniFi OS or UDM
+	EndpointAuthLogout = "/api/auth/logout" // Logout from UniFi OS or UDM
+	EndpointLogout     = "/api/logout"      // Logout from a legacy controller
+	EndpointSelf       = "/api/users/self"  // Get current user details on UniFi OS
+	EndpointLegacySelf = "/api/self"        // Get current user details on a legacy controller
 )
 
 // Sites
@@ -17,16 +24,24 @@ const (
 const (
 	EndpointListDevices = "/api/s/%s/stat/device"    // %s = site name, list all devices
 	EndpointGetDevice   = "/api/s/%s/stat/device/%s" // %s = site name, %s = device MAC, get specific device
+	EndpointDeviceCmd   = "/api/s/%s/cmd/devmgr"     // %s = site name, restart, adopt, provision or upgrade a device
 )
 
 // Clients
 const (
-	EndpointListClients      = "/api/s/%s/stat/sta"   // %s = site name, list clients
-	EndpointAuthorizeGuest   = "/api/s/%s/cmd/stamgr" // %s = site name, authorize a guest
-	EndpointUnauthorizeGuest = "/api/s/%s/cmd/stamgr" // %s = site name, unauthorize a guest
-	EndpointReconnectClient  = "/api/s/%s/cmd/stamgr" // %s = site name, reconnect a client
-	EndpointBlockClient      = "/api/s/%s/cmd/stamgr" // %s = site name, block a client
-	EndpointUnblockClient    = "/api/s/%s/cmd/stamgr" // %s = site name, unblock a client
+	EndpointListClients      = "/api/s/%s/stat/sta"     // %s = site name, list clients
+	EndpointAuthorizeGuest   = "/api/s/%s/cmd/stamgr"   // %s = site name, authorize a guest
+	EndpointUnauthorizeGuest = "/api/s/%s/cmd/stamgr"   // %s = site name, unauthorize a guest
+	EndpointReconnectClient  = "/api/s/%s/cmd/stamgr"   // %s = site name, reconnect a client
+	EndpointBlockClient      = "/api/s/%s/cmd/stamgr"   // %s = site name, block a client
+	EndpointUnblockClient    = "/api/s/%s/cmd/stamgr"   // %s = site name, unblock a client
+	EndpointForgetClient     = "/api/s/%s/cmd/stamgr"   // %s = site name, forget clients
+	EndpointUpdateClient     = "/api/s/%s/rest/user/%s" // %s = site name, %s = user ID, set name or note
+)
+
+// Events
+const (
+	EndpointEvents = "/wss/s/%s/events" // %s = site name, websocket event stream
 )
 
 // Certificates
@@ -57,5 +72,23 @@ const (
 
 // Network Configuration
 const (
-	EndpointListNetworks = "/api/s/%s/rest/networkconf" // %s = site name, list network configurations
+	EndpointListNetworks  = "/api/s/%s/rest/networkconf"    // %s = site name, list network configurations
+	EndpointGetNetwork    = "/api/s/%s/rest/networkconf/%s" // %s = site name, %s = network ID, get a network configuration
+	EndpointCreateNetwork = "/api/s/%s/rest/networkconf"    // %s = site name, create a network configuration
+	EndpointUpdateNetwork = "/api/s/%s/rest/networkconf/%s" // %s = site name, %s = network ID, update a network configuration
+	EndpointDeleteNetwork = "/api/s/%s/rest/networkconf/%s" // %s = site name, %s = network ID, delete a network configuration
+)
+
+// Wireless Networks
+const (
+	EndpointListWLANs  = "/api/s/%s/rest/wlanconf"    // %s = site name, list WLAN configurations
+	EndpointUpdateWLAN = "/api/s/%s/rest/wlanconf/%s" // %s = site name, %s = WLAN ID, update a WLAN configuration
+)
+
+// Integration API, authenticated with an API key
+const (
+	EndpointIntegrationSites   = "/proxy/network/integration/v1/sites"               // List sites
+	EndpointIntegrationDevices = "/proxy/network/integration/v1/sites/%s/devices"    // %s = site ID, list adopted devices
+	EndpointIntegrationDevice  = "/proxy/network/integration/v1/sites/%s/devices/%s" // %s = site ID, %s = device ID, get a device
+	EndpointIntegrationClients = "/proxy/network/integration/v1/sites/%s/clients"    // %s = site ID, list connected clients
 )
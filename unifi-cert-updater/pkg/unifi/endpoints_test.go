@@ -0,0 +1,113 @@
+package unifi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointRouting(t *testing.T) {
+	calls := []struct {
+		name        string
+		call        func(ctx context.Context, c *UniFiClient) error
+		classicPath string
+		unifiOSPath string
+		unifiOSOnly bool
+	}{
+		{
+			name:        "ListDevices",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListDevices(ctx, "default"); return err },
+			classicPath: "/api/s/default/stat/device",
+			unifiOSPath: "/proxy/network/api/s/default/stat/device",
+		},
+		{
+			name: "GetDevice",
+			call: func(ctx context.Context, c *UniFiClient) error {
+				_, err := c.GetDevice(ctx, "default", "aa:bb")
+				return err
+			},
+			classicPath: "/api/s/default/stat/device/aa:bb",
+			unifiOSPath: "/proxy/network/api/s/default/stat/device/aa:bb",
+		},
+		{
+			name:        "ListClients",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListClients(ctx, "default"); return err },
+			classicPath: "/api/s/default/stat/sta",
+			unifiOSPath: "/proxy/network/api/s/default/stat/sta",
+		},
+		{
+			name:        "AuthorizeGuest",
+			call:        func(ctx context.Context, c *UniFiClient) error { return c.AuthorizeGuest(ctx, "default", "aa:bb", 60) },
+			classicPath: "/api/s/default/cmd/stamgr",
+			unifiOSPath: "/proxy/network/api/s/default/cmd/stamgr",
+		},
+		{
+			name:        "ListSites",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListSites(ctx); return err },
+			classicPath: "/api/self/sites",
+			unifiOSPath: "/proxy/network/api/self/sites",
+		},
+		{
+			name:        "ListSiteStats",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListSiteStats(ctx, "default"); return err },
+			classicPath: "/api/s/default/stat/site",
+			unifiOSPath: "/proxy/network/api/s/default/stat/site",
+		},
+		{
+			name: "CreateVoucher",
+			call: func(ctx context.Context, c *UniFiClient) error {
+				_, err := c.CreateVoucher(ctx, "default", VoucherCreatePayload{})
+				return err
+			},
+			classicPath: "/api/s/default/cmd/hotspot",
+			unifiOSPath: "/proxy/network/api/s/default/cmd/hotspot",
+		},
+		{
+			name:        "ListVouchers",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListVouchers(ctx, "default"); return err },
+			classicPath: "/api/s/default/stat/voucher",
+			unifiOSPath: "/proxy/network/api/s/default/stat/voucher",
+		},
+		{
+			name:        "ListNetworks",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListNetworks(ctx, "default"); return err },
+			classicPath: "/api/s/default/rest/networkconf",
+			unifiOSPath: "/proxy/network/api/s/default/rest/networkconf",
+		},
+		{
+			name:        "ListCertificates",
+			call:        func(ctx context.Context, c *UniFiClient) error { _, err := c.ListCertificates(ctx); return err },
+			unifiOSPath: "/api/userCertificates",
+			unifiOSOnly: true,
+		},
+	}
+
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_, _ = w.Write([]byte(`{"meta":{"rc":"ok"},"data":[{}]}`))
+	}))
+	defer server.Close()
+
+	for _, tt := range calls {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, unifiOS := range []bool{false, true} {
+				if tt.unifiOSOnly && !unifiOS {
+					continue
+				}
+				client := &UniFiClient{BaseURL: server.URL, HTTPClient: server.Client(), isUniFiOS: unifiOS}
+				path = ""
+				_ = tt.call(context.Background(), client)
+
+				expected := tt.classicPath
+				if unifiOS {
+					expected = tt.unifiOSPath
+				}
+				assert.Equal(t, expected, path, "UniFi OS: %v", unifiOS)
+			}
+		})
+	}
+}
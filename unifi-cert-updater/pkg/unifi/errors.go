@@ -0,0 +1,66 @@
+package unifi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Sentinel errors matched by APIError through errors.Is.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrInvalidCSRFToken = errors.New("invalid CSRF token")
+	ErrMFARequired      = errors.New("two-factor authentication required")
+)
+
+// notFoundMessages are the msg values the legacy controller answers with when
+// the object a request names does not exist.
+var notFoundMessages = []string{
+	"api.err.NotFound",
+	"api.err.UnknownDevice",
+	"api.err.UnknownStation",
+	"api.err.UnknownUser",
+}
+
+// statusMFARequired is the non-standard status UniFi OS answers a password
+// login with when the account needs a second factor.
+const statusMFARequired = 499
+
+// APIError is returned when the console answers with a non-2xx status or with
+// a meta/data envelope whose rc is "error".
+type APIError struct {
+	StatusCode int    // HTTP status code
+	RC         string // meta.rc, empty if the response had no envelope
+	Msg        string // meta.msg, e.g. "api.err.LoginRequired"
+	Body       string // Raw response body
+}
+
+func (e *APIError) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("unifi error %s (status code %d)", e.Msg, e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+// Is matches the sentinel errors by status code and by the msg values the
+// legacy controller uses for the same conditions.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || slices.Contains(notFoundMessages, e.Msg)
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.Msg == "api.err.LoginRequired"
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden || e.Msg == "api.err.NoPermission"
+	case ErrInvalidCSRFToken:
+		return strings.Contains(strings.ToLower(e.Msg), "csrf") ||
+			(e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusUnauthorized) && strings.Contains(strings.ToLower(e.Body), "csrf")
+	case ErrMFARequired:
+		return e.StatusCode == statusMFARequired || e.Msg == "api.err.Ubic2faTokenRequired" || strings.Contains(e.Body, "MFA_AUTH_REQUIRED")
+	}
+	return false
+}
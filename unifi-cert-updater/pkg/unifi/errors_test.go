@@ -0,0 +1,81 @@
+package unifi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     *APIError
+		matches []error
+	}{
+		{
+			name:    "404",
+			err:     &APIError{StatusCode: http.StatusNotFound},
+			matches: []error{ErrNotFound},
+		},
+		{
+			name:    "unknown device",
+			err:     &APIError{StatusCode: http.StatusBadRequest, RC: "error", Msg: "api.err.UnknownDevice"},
+			matches: []error{ErrNotFound},
+		},
+		{
+			name:    "unknown station",
+			err:     &APIError{StatusCode: http.StatusBadRequest, RC: "error", Msg: "api.err.UnknownStation"},
+			matches: []error{ErrNotFound},
+		},
+		{
+			name: "unknown error",
+			err:  &APIError{StatusCode: http.StatusBadRequest, RC: "error", Msg: "api.err.UnknownError"},
+		},
+		{
+			name:    "login required",
+			err:     &APIError{StatusCode: http.StatusUnauthorized, RC: "error", Msg: "api.err.LoginRequired"},
+			matches: []error{ErrUnauthorized},
+		},
+		{
+			name:    "no permission",
+			err:     &APIError{StatusCode: http.StatusOK, RC: "error", Msg: "api.err.NoPermission"},
+			matches: []error{ErrForbidden},
+		},
+		{
+			name:    "csrf",
+			err:     &APIError{StatusCode: http.StatusForbidden, Body: `{"error":"Invalid CSRF Token"}`},
+			matches: []error{ErrForbidden, ErrInvalidCSRFToken},
+		},
+		{
+			name: "server error",
+			err:  &APIError{StatusCode: http.StatusInternalServerError, Body: "oops"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Wrapping must not hide the error
+			err := fmt.Errorf("failed to do something: %w", tt.err)
+			for _, sentinel := range []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrInvalidCSRFToken} {
+				assert.Equal(t, containsError(tt.matches, sentinel), errors.Is(err, sentinel), "errors.Is(%v)", sentinel)
+			}
+		})
+	}
+}
+
+func containsError(errs []error, target error) bool {
+	for _, err := range errs {
+		if err == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	assert.EqualError(t, &APIError{StatusCode: 500, Body: "oops"}, "unexpected status code 500: oops")
+	assert.EqualError(t, &APIError{StatusCode: 200, RC: "error", Msg: "api.err.Invalid"}, "unifi error api.err.Invalid (status code 200)")
+}
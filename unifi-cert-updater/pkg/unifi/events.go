@@ -0,0 +1,272 @@
+package unifi
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// Bounds of the exponential backoff between event stream reconnects.
+var (
+	eventsMinBackoff = time.Second
+	eventsMaxBackoff = time.Minute
+)
+
+// EventKind classifies the events delivered by SubscribeEvents.
+type EventKind string
+
+const (
+	EventClientConnected    EventKind = "client-connected"
+	EventClientDisconnected EventKind = "client-disconnected"
+	EventClientRoamed       EventKind = "client-roamed"
+	EventDeviceStateChanged EventKind = "device-state-changed"
+	EventAlarm              EventKind = "alarm"
+	EventOther              EventKind = "other"
+)
+
+// Event is a single event from a site's event stream.
+type Event struct {
+	Kind      EventKind
+	Message   string    // Message type, e.g. "events", "alarm", "device:sync" or "sta:sync"
+	Key       string    // Event key, e.g. "EVT_WU_Connected", empty for sync messages
+	Time      time.Time // Zero for sync messages
+	Msg       string    // Human-readable description, if any
+	ClientMAC string
+	DeviceMAC string
+	SSID      string
+	Device    *Device         // Set for device:sync and device:update messages
+	Raw       json.RawMessage // The undecoded event
+}
+
+// rawEvent holds the fields shared by the controller's event and alarm
+// objects.
+type rawEvent struct {
+	Key   string `json:"key"`
+	Msg   string `json:"msg"`
+	Time  int64  `json:"time"` // Milliseconds since the epoch
+	User  string `json:"user"`
+	Guest string `json:"guest"`
+	AP    string `json:"ap"`
+	SW    string `json:"sw"`
+	GW    string `json:"gw"`
+	SSID  string `json:"ssid"`
+}
+
+// SubscribeEvents streams the site's events, including client connects and
+// disconnects, device state changes and alarms, until ctx is cancelled. The
+// first connection is made before returning; after that the stream reconnects
+// with backoff, renewing the session if the console rejects it. The channel is
+// closed once ctx is cancelled.
+func (c *UniFiClient) SubscribeEvents(ctx context.Context, site string) (<-chan Event, error) {
+	conn, err := c.dialEvents(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 64)
+	go c.streamEvents(ctx, site, conn, events)
+	return events, nil
+}
+
+func (c *UniFiClient) streamEvents(ctx context.Context, site string, conn *websocket.Conn, events chan<- Event) {
+	defer close(events)
+
+	// Last reported state of each device, to turn device:sync messages into
+	// state changes
+	deviceStates := map[string]DeviceState{}
+	backoff := eventsMinBackoff
+	for {
+		err := c.readEvents(ctx, conn, deviceStates, events)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithError(err).Warnf("UniFi event stream for site %s disconnected, reconnecting", site)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, eventsMaxBackoff)
+
+			session := c.session.Load()
+			conn, err = c.dialEvents(ctx, site)
+			if err == nil {
+				backoff = eventsMinBackoff
+				break
+			}
+			logrus.WithError(err).Warnf("Failed to reconnect UniFi event stream for site %s", site)
+
+			var dialErr *websocket.DialError
+			if errors.As(err, &dialErr) && dialErr.Err == websocket.ErrBadStatus && c.Username != "" {
+				if err := c.renewSession(ctx, session); err != nil {
+					logrus.WithError(err).Warn("Failed to renew session for the UniFi event stream")
+				}
+			}
+		}
+	}
+}
+
+// readEvents delivers the events read from conn until it fails or ctx is
+// cancelled. The connection is always closed on return.
+func (c *UniFiClient) readEvents(ctx context.Context, conn *websocket.Conn, deviceStates map[string]DeviceState, events chan<- Event) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			return err
+		}
+
+		decoded, err := decodeEvents(msg)
+		if err != nil {
+			logrus.WithError(err).Warn("Ignoring undecodable UniFi event message")
+			continue
+		}
+		for _, event := range decoded {
+			if event.Device != nil {
+				previous, seen := deviceStates[event.Device.MAC]
+				deviceStates[event.Device.MAC] = event.Device.State
+				if seen && previous != event.Device.State {
+					event.Kind = EventDeviceStateChanged
+				}
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// dialEvents opens the site's event websocket with the session cookies. TLS
+// settings are taken from HTTPClient's transport if it is an *http.Transport.
+func (c *UniFiClient) dialEvents(ctx context.Context, site string) (*websocket.Conn, error) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	location := *base
+	location.Path = strings.TrimRight(base.Path, "/") + c.networkEndpoint(EndpointEvents, site)
+	switch base.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	case "http":
+		location.Scheme = "ws"
+	}
+
+	config, err := websocket.NewConfig(location.String(), c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event stream URL: %w", err)
+	}
+	config.Header = http.Header{}
+	if c.HTTPClient.Jar != nil {
+		var cookies []string
+		for _, cookie := range c.HTTPClient.Jar.Cookies(base) {
+			cookies = append(cookies, cookie.String())
+		}
+		if len(cookies) > 0 {
+			config.Header.Set("Cookie", strings.Join(cookies, "; "))
+		}
+	}
+	if _, csrfToken := c.sessionTokens(); csrfToken != "" {
+		config.Header.Set("X-CSRF-Token", csrfToken)
+	}
+	if transport, ok := c.HTTPClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		config.TlsConfig = transport.TLSClientConfig.Clone()
+	}
+
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to event stream: %w", err)
+	}
+	return conn, nil
+}
+
+// decodeEvents splits a websocket message into its events.
+func decodeEvents(msg []byte) ([]Event, error) {
+	var message struct {
+		Meta struct {
+			Message string `json:"message"`
+		} `json:"meta"`
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &message); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(message.Data))
+	for _, data := range message.Data {
+		event := Event{Kind: EventOther, Message: message.Meta.Message, Raw: data}
+
+		switch message.Meta.Message {
+		case "device:sync", "device:update":
+			var device Device
+			if err := json.Unmarshal(data, &device); err != nil {
+				return nil, fmt.Errorf("failed to decode %s message: %w", message.Meta.Message, err)
+			}
+			event.Device = &device
+			event.DeviceMAC = device.MAC
+		default:
+			var raw rawEvent
+			if err := json.Unmarshal(data, &raw); err != nil {
+				return nil, fmt.Errorf("failed to decode %s message: %w", message.Meta.Message, err)
+			}
+			event.Key = raw.Key
+			event.Msg = raw.Msg
+			if raw.Time != 0 {
+				event.Time = time.UnixMilli(raw.Time)
+			}
+			event.ClientMAC = cmp.Or(raw.User, raw.Guest)
+			event.DeviceMAC = cmp.Or(raw.AP, raw.SW, raw.GW)
+			event.SSID = raw.SSID
+			event.Kind = eventKind(message.Meta.Message, raw.Key)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// eventKind classifies an event by its key, e.g. EVT_WU_Connected for a
+// wireless user connecting or EVT_AP_Lost_Contact for an AP going offline.
+func eventKind(message, key string) EventKind {
+	if message == "alarm" {
+		return EventAlarm
+	}
+
+	prefix, suffix, ok := strings.Cut(strings.TrimPrefix(key, "EVT_"), "_")
+	if !ok {
+		return EventOther
+	}
+	switch prefix {
+	case "WU", "WG", "LU", "LG": // Wireless/wired users and guests
+		switch suffix {
+		case "Connected":
+			return EventClientConnected
+		case "Disconnected":
+			return EventClientDisconnected
+		case "Roam", "RoamRadio":
+			return EventClientRoamed
+		}
+	case "AP", "SW", "GW", "XG", "DM":
+		switch suffix {
+		case "Connected", "Disconnected", "Lost_Contact", "Restarted", "RestartedUnknown", "Upgraded", "Adopted", "Isolated":
+			return EventDeviceStateChanged
+		}
+	}
+	return EventOther
+}
@@ -0,0 +1,102 @@
+package unifi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	defer unifi.SetEventsMinBackoff(time.Millisecond)()
+	const streamRequest = "GET /proxy/network/wss/s/default/events"
+
+	server, client := newConsoleClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.SendEvents("default",
+		`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WU_Connected","user":"11:22:33:44:55:66","ap":"aa:bb:cc:dd:ee:ff","ssid":"Home","time":1735689600000,"msg":"User[11:22:33:44:55:66] has connected to AP[aa:bb:cc:dd:ee:ff]"}]}`,
+		`{"meta":{"rc":"ok","message":"device:sync"},"data":[{"mac":"aa:bb:cc:dd:ee:ff","state":1,"version":"6.6.77"}]}`,
+		`not json`,
+	)
+
+	events, err := client.SubscribeEvents(ctx, "default")
+	require.NoError(t, err)
+
+	var received []unifi.Event
+	receive := func(n int) {
+		t.Helper()
+		for len(received) < n {
+			select {
+			case event := <-events:
+				received = append(received, event)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out after %d events", len(received))
+			}
+		}
+	}
+	receive(2)
+
+	assert.Equal(t, unifi.EventClientConnected, received[0].Kind)
+	assert.Equal(t, "EVT_WU_Connected", received[0].Key)
+	assert.Equal(t, "11:22:33:44:55:66", received[0].ClientMAC)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", received[0].DeviceMAC)
+	assert.Equal(t, "Home", received[0].SSID)
+	assert.Equal(t, time.UnixMilli(1735689600000), received[0].Time)
+
+	// The first sync only establishes the device's state
+	assert.Equal(t, unifi.EventOther, received[1].Kind)
+	require.NotNil(t, received[1].Device)
+	assert.Equal(t, unifi.DeviceStateConnected, received[1].Device.State)
+
+	// The console restarts, so the stream is rejected until the session is
+	// renewed
+	server.ExpireSessions()
+	server.CloseEventStreams()
+	server.SendEvents("default",
+		`{"meta":{"rc":"ok","message":"device:sync"},"data":[{"mac":"aa:bb:cc:dd:ee:ff","state":5}]}`,
+		`{"meta":{"rc":"ok","message":"events"},"data":[{"key":"EVT_WG_Disconnected","guest":"77:88:99:aa:bb:cc","ap":"aa:bb:cc:dd:ee:ff"},{"key":"EVT_SW_Lost_Contact","sw":"00:11:22:33:44:55"}]}`,
+		`{"meta":{"rc":"ok","message":"alarm"},"data":[{"key":"EVT_IPS_IpsAlert","msg":"IPS Alert 1: A Network Trojan was Detected"}]}`,
+	)
+	receive(6)
+
+	assert.Equal(t, unifi.EventDeviceStateChanged, received[2].Kind)
+	assert.Equal(t, unifi.DeviceStateProvisioning, received[2].Device.State)
+	assert.Equal(t, unifi.EventClientDisconnected, received[3].Kind)
+	assert.Equal(t, "77:88:99:aa:bb:cc", received[3].ClientMAC)
+	assert.Equal(t, unifi.EventDeviceStateChanged, received[4].Kind)
+	assert.Equal(t, "00:11:22:33:44:55", received[4].DeviceMAC)
+	assert.Equal(t, unifi.EventAlarm, received[5].Kind)
+
+	assert.Equal(t, 2, server.Logins())
+	var streams int
+	for _, request := range server.Requests() {
+		if request == streamRequest {
+			streams++
+		}
+	}
+	assert.GreaterOrEqual(t, streams, 3, "expected a rejected reconnect before the renewal")
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok, "expected the channel to be closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancelling the context")
+	}
+}
+
+func TestSubscribeEventsRejected(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+
+	// Not logged in
+	client, err := unifi.NewClient(server.URL, "", "", server.Client())
+	require.NoError(t, err)
+	_, err = client.SubscribeEvents(context.Background(), "default")
+	assert.ErrorContains(t, err, "failed to connect to event stream")
+}
@@ -0,0 +1,19 @@
+package unifi
+
+import "time"
+
+// SetEventsMinBackoff shortens the event stream's reconnect backoff for the
+// external tests, returning a function that restores it.
+func SetEventsMinBackoff(d time.Duration) (restore func()) {
+	previous := eventsMinBackoff
+	eventsMinBackoff = d
+	return func() { eventsMinBackoff = previous }
+}
+
+// Unexported helpers tested from the external tests
+var (
+	TOTPCode              = totpCode
+	FormatVoucherDuration = formatVoucherDuration
+	FormatVoucherQuota    = formatVoucherQuota
+	ValidatePassphrase    = validatePassphrase
+)
@@ -2,7 +2,9 @@ package unifi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,7 +13,74 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func (c *UniFiClient) doRequest(method, endpoint string, payload interface{}, response interface{}) error {
+// envelope is the meta/data wrapper used by the Network application's
+// legacy /api/s/{site}/ endpoints.
+type envelope struct {
+	Meta *ResponseMeta   `json:"meta"`
+	Data json.RawMessage `json:"data"`
+}
+
+// decodeEnvelope returns the envelope of body, or nil if it has none.
+func decodeEnvelope(body []byte) *envelope {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+	var env envelope
+	if err := json.Unmarshal(trimmed, &env); err != nil || env.Meta == nil || env.Meta.RC == "" {
+		return nil
+	}
+	return &env
+}
+
+// sessionRejected reports whether the console rejected the session cookie or
+// CSRF token, which a fresh login fixes. A denied permission is not fixed by
+// logging in again as the same user.
+func sessionRejected(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Msg == "api.err.NoPermission" {
+		return false
+	}
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrInvalidCSRFToken)
+}
+
+// networkEndpoint returns the path of a Network application endpoint for the
+// kind of console the client is logged in to.
+func (c *UniFiClient) networkEndpoint(format string, args ...interface{}) string {
+	endpoint := fmt.Sprintf(format, args...)
+	if c.unifiOS() {
+		return NetworkPathPrefix + endpoint
+	}
+	return endpoint
+}
+
+// doRequest sends a request and decodes the response. If the console rejects
+// the session, the client logs in again and retries the request once.
+func (c *UniFiClient) doRequest(ctx context.Context, method, endpoint string, payload interface{}, response interface{}) error {
+	session := c.session.Load()
+	err := c.send(ctx, method, endpoint, payload, response)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !sessionRejected(apiErr) || c.Username == "" {
+		return err
+	}
+
+	logrus.Debugf("Session rejected with status code %d, logging in again", apiErr.StatusCode)
+	if loginErr := c.renewSession(ctx, session); loginErr != nil {
+		return fmt.Errorf("%w (session renewal failed: %v)", err, loginErr)
+	}
+	return c.send(ctx, method, endpoint, payload, response)
+}
+
+// send performs a single request without session renewal. Responses wrapped
+// in a meta/data envelope are unwrapped, so response receives only the data.
+func (c *UniFiClient) send(ctx context.Context, method, endpoint string, payload interface{}, response interface{}) error {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit wait: %w", err)
+		}
+	}
+
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 
 	var body io.Reader
@@ -23,37 +92,60 @@ func (c *UniFiClient) doRequest(method, endpoint string, payload interface{}, re
 		body = bytes.NewReader(jsonData)
 	}
 
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Cookie", fmt.Sprintf("TOKEN=%s", c.token))
-	if c.csrfToken != "" {
-		req.Header.Set("X-CSRF-Token", c.csrfToken)
+	token, csrfToken := c.sessionTokens()
+	req.Header.Set("Cookie", fmt.Sprintf("TOKEN=%s", token))
+	if csrfToken != "" {
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
 	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Update CSRF token if present in response headers
-	if newCsrfToken := resp.Header.Get("X-CSRF-Token"); newCsrfToken != "" && newCsrfToken != c.csrfToken {
-		c.csrfToken = newCsrfToken
+	// Update CSRF token if present in response headers, and the saved session
+	// with it, or a resumed session would send the old token
+	if newCsrfToken := resp.Header.Get("X-CSRF-Token"); newCsrfToken != "" && newCsrfToken != csrfToken {
+		c.setCSRFToken(newCsrfToken)
 		logrus.Debug("CSRF token updated")
+		if c.sessionStore != nil && token != "" {
+			c.saveSession(ctx)
+		}
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if response != nil {
-		return json.NewDecoder(resp.Body).Decode(response)
+	env := decodeEnvelope(respBody)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || (env != nil && env.Meta.RC == "error") {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+		if env != nil {
+			apiErr.RC = env.Meta.RC
+			apiErr.Msg = env.Meta.Msg
+		}
+		return apiErr
 	}
 
+	if response == nil {
+		return nil
+	}
+	if env != nil {
+		respBody = env.Data
+	}
+	if err := json.Unmarshal(respBody, response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
 	return nil
 }
 
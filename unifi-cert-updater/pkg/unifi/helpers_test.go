@@ -0,0 +1,96 @@
+package unifi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeDecoding(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("devices", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"ok"},"data":[{"_id":"1","name":"ap","mac":"aa:bb:cc:dd:ee:ff"}]}`, http.StatusOK)
+		defer server.Close()
+
+		devices, err := client.ListDevices(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, []Device{{ID: "1", Name: "ap", MAC: "aa:bb:cc:dd:ee:ff"}}, devices)
+
+		device, err := client.GetDevice(ctx, "default", "aa:bb:cc:dd:ee:ff")
+		require.NoError(t, err)
+		assert.Equal(t, "ap", device.Name)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"ok"},"data":[{"_id":"1","mac":"11:22:33:44:55:66","hostname":"laptop"}]}`, http.StatusOK)
+		defer server.Close()
+
+		clients, err := client.ListClients(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, []Client{{ID: "1", Mac: "11:22:33:44:55:66", Hostname: "laptop"}}, clients)
+	})
+
+	t.Run("sites", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"ok"},"data":[{"_id":"1","name":"default","desc":"Default"}]}`, http.StatusOK)
+		defer server.Close()
+
+		sites, err := client.ListSites(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []Site{{ID: "1", Name: "default", Description: "Default"}}, sites)
+	})
+
+	t.Run("empty data", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"ok"},"data":[]}`, http.StatusOK)
+		defer server.Close()
+
+		_, err := client.GetDevice(ctx, "default", "aa:bb:cc:dd:ee:ff")
+		assert.True(t, errors.Is(err, ErrNotFound), "unexpected error: %v", err)
+	})
+
+	t.Run("rc error with 200 status", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"error","msg":"api.err.UnknownDevice"},"data":[]}`, http.StatusOK)
+		defer server.Close()
+
+		_, err := client.ListDevices(ctx, "default")
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr), "unexpected error: %v", err)
+		assert.Equal(t, http.StatusOK, apiErr.StatusCode)
+		assert.Equal(t, "error", apiErr.RC)
+		assert.Equal(t, "api.err.UnknownDevice", apiErr.Msg)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("rc error with 401 status", func(t *testing.T) {
+		server, client := setupTestServer(`{"meta":{"rc":"error","msg":"api.err.LoginRequired"},"data":[]}`, http.StatusUnauthorized)
+		defer server.Close()
+
+		_, err := client.ListSites(ctx)
+		assert.True(t, errors.Is(err, ErrUnauthorized), "unexpected error: %v", err)
+		assert.EqualError(t, err, "unifi error api.err.LoginRequired (status code 401)")
+	})
+}
+
+func TestSessionRejected(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		rejected bool
+	}{
+		"401":            {err: &APIError{StatusCode: http.StatusUnauthorized}, rejected: true},
+		"login required": {err: &APIError{StatusCode: http.StatusOK, RC: "error", Msg: "api.err.LoginRequired"}, rejected: true},
+		"csrf":           {err: &APIError{StatusCode: http.StatusForbidden, Body: `{"error":"invalid csrf token"}`}, rejected: true},
+		"403":            {err: &APIError{StatusCode: http.StatusForbidden}, rejected: true},
+		"no permission":  {err: &APIError{StatusCode: http.StatusForbidden, RC: "error", Msg: "api.err.NoPermission"}},
+		"not found":      {err: &APIError{StatusCode: http.StatusNotFound}},
+		"network":        {err: errors.New("connection refused")},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.rejected, sessionRejected(tt.err))
+		})
+	}
+}
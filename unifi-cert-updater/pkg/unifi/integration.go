@@ -0,0 +1,87 @@
+package unifi
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ListIntegrationSites returns one page of the sites visible to the API key.
+func (c *UniFiClient) ListIntegrationSites(ctx context.Context, page PageRequest) (*Page[IntegrationSite], error) {
+	return getPage[IntegrationSite](ctx, c, EndpointIntegrationSites, page)
+}
+
+// ListAllIntegrationSites returns every site visible to the API key.
+func (c *UniFiClient) ListAllIntegrationSites(ctx context.Context) ([]IntegrationSite, error) {
+	return getAllPages[IntegrationSite](ctx, c, EndpointIntegrationSites)
+}
+
+// ListIntegrationDevices returns one page of the adopted devices of a site.
+func (c *UniFiClient) ListIntegrationDevices(ctx context.Context, siteID string, page PageRequest) (*Page[IntegrationDevice], error) {
+	return getPage[IntegrationDevice](ctx, c, fmt.Sprintf(EndpointIntegrationDevices, siteID), page)
+}
+
+// ListAllIntegrationDevices returns every adopted device of a site.
+func (c *UniFiClient) ListAllIntegrationDevices(ctx context.Context, siteID string) ([]IntegrationDevice, error) {
+	return getAllPages[IntegrationDevice](ctx, c, fmt.Sprintf(EndpointIntegrationDevices, siteID))
+}
+
+// GetIntegrationDevice returns a single device of a site by its ID.
+func (c *UniFiClient) GetIntegrationDevice(ctx context.Context, siteID, deviceID string) (*IntegrationDevice, error) {
+	var device IntegrationDevice
+	if err := c.doRequest(ctx, "GET", fmt.Sprintf(EndpointIntegrationDevice, siteID, deviceID), nil, &device); err != nil {
+		return nil, fmt.Errorf("failed to get device %s: %w", deviceID, err)
+	}
+	return &device, nil
+}
+
+// ListIntegrationClients returns one page of the clients connected to a site.
+func (c *UniFiClient) ListIntegrationClients(ctx context.Context, siteID string, page PageRequest) (*Page[IntegrationClient], error) {
+	return getPage[IntegrationClient](ctx, c, fmt.Sprintf(EndpointIntegrationClients, siteID), page)
+}
+
+// ListAllIntegrationClients returns every client connected to a site.
+func (c *UniFiClient) ListAllIntegrationClients(ctx context.Context, siteID string) ([]IntegrationClient, error) {
+	return getAllPages[IntegrationClient](ctx, c, fmt.Sprintf(EndpointIntegrationClients, siteID))
+}
+
+func getPage[T any](ctx context.Context, c *UniFiClient, endpoint string, page PageRequest) (*Page[T], error) {
+	query := url.Values{}
+	if page.Offset > 0 {
+		query.Set("offset", strconv.Itoa(page.Offset))
+	}
+	if page.Limit > 0 {
+		query.Set("limit", strconv.Itoa(page.Limit))
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var result Page[T]
+	if err := c.doRequest(ctx, "GET", endpoint, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", endpoint, err)
+	}
+	return &result, nil
+}
+
+// getAllPages follows the pagination of a listing until every item has been
+// fetched.
+func getAllPages[T any](ctx context.Context, c *UniFiClient, endpoint string) ([]T, error) {
+	var items []T
+	page := PageRequest{}
+	for {
+		result, err := getPage[T](ctx, c, endpoint, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Data...)
+
+		// Stop on the last page, or if the console stops returning items
+		if len(result.Data) == 0 || len(items) >= result.TotalCount {
+			return items, nil
+		}
+		page.Offset = result.Offset + len(result.Data)
+		page.Limit = result.Limit
+	}
+}
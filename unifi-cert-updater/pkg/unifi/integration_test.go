@@ -0,0 +1,89 @@
+package unifi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationAPI(t *testing.T) {
+	server := unifitest.NewServer(unifitest.WithAPIKey("secret-key"), unifitest.WithPageSize(2))
+	t.Cleanup(server.Close)
+	office := server.AddSite(unifi.Site{Name: "office", Description: "Office"})
+	home := server.AddSite(unifi.Site{Name: "home", Description: "Home"})
+	device := server.AddDevice("office", unifi.Device{
+		Name: "Office Switch", Model: "USW-24", MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.2",
+		State: unifi.DeviceStateConnected, Version: "6.6.77", PortTable: []unifi.Port{{PortIdx: 1}},
+	})
+	connectedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	laptop := server.AddIntegrationClient("office", unifi.IntegrationClient{
+		Type: "WIRELESS", Name: "laptop", ConnectedAt: connectedAt, IPAddress: "10.0.0.10",
+		MACAddress: "11:22:33:44:55:66", UplinkDeviceID: device.ID,
+	})
+	ctx := context.Background()
+
+	client, err := unifi.NewClient(server.URL, "", "", server.Client(), unifi.WithAPIKey("secret-key"))
+	require.NoError(t, err)
+	sitesPath := unifi.EndpointIntegrationSites
+
+	t.Run("all sites across pages", func(t *testing.T) {
+		result, err := client.ListAllIntegrationSites(ctx)
+		require.NoError(t, err)
+		require.Len(t, result, 3)
+		assert.Equal(t, unifi.IntegrationSite{ID: office.ID, InternalReference: "office", Name: "Office"}, result[1])
+		assert.Equal(t, unifi.IntegrationSite{ID: home.ID, InternalReference: "home", Name: "Home"}, result[2])
+		assert.Equal(t, []string{"GET " + sitesPath, "GET " + sitesPath + "?limit=2&offset=2"}, server.Requests())
+	})
+
+	t.Run("single page", func(t *testing.T) {
+		page, err := client.ListIntegrationSites(ctx, unifi.PageRequest{Offset: 1, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, &unifi.Page[unifi.IntegrationSite]{
+			Offset: 1, Limit: 1, Count: 1, TotalCount: 3,
+			Data: []unifi.IntegrationSite{{ID: office.ID, InternalReference: "office", Name: "Office"}},
+		}, page)
+	})
+
+	t.Run("devices", func(t *testing.T) {
+		devices, err := client.ListAllIntegrationDevices(ctx, office.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []unifi.IntegrationDevice{{
+			ID: device.ID, Name: "Office Switch", Model: "USW-24", MACAddress: "aa:bb:cc:dd:ee:ff", IPAddress: "10.0.0.2",
+			State: "ONLINE", FirmwareVersion: "6.6.77", Features: []string{"switching"}, Interfaces: []string{"ports"},
+		}}, devices)
+
+		result, err := client.GetIntegrationDevice(ctx, office.ID, device.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "6.6.77", result.FirmwareVersion)
+
+		_, err = client.GetIntegrationDevice(ctx, office.ID, "missing")
+		assert.True(t, errors.Is(err, unifi.ErrNotFound), "unexpected error: %v", err)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		clients, err := client.ListAllIntegrationClients(ctx, office.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []unifi.IntegrationClient{laptop}, clients)
+
+		clients, err = client.ListAllIntegrationClients(ctx, home.ID)
+		require.NoError(t, err)
+		assert.Empty(t, clients)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		badClient, err := unifi.NewClient(server.URL, "", "", server.Client(), unifi.WithAPIKey("wrong"))
+		require.NoError(t, err)
+
+		before := len(server.Requests())
+		_, err = badClient.ListIntegrationSites(ctx, unifi.PageRequest{})
+		assert.True(t, errors.Is(err, unifi.ErrUnauthorized), "unexpected error: %v", err)
+		assert.Len(t, server.Requests(), before+1, "an API key client must not try to log in")
+		assert.Zero(t, server.Logins())
+	})
+}
@@ -0,0 +1,89 @@
+package unifi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+func (c *UniFiClient) ListNetworks(ctx context.Context, site string) ([]Network, error) {
+	endpoint := c.networkEndpoint(EndpointListNetworks, site)
+	var networks []Network
+	err := c.doRequest(ctx, "GET", endpoint, nil, &networks)
+	if err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+func (c *UniFiClient) GetNetwork(ctx context.Context, site, id string) (Network, error) {
+	endpoint := c.networkEndpoint(EndpointGetNetwork, site, id)
+	var networks []Network
+	err := c.doRequest(ctx, "GET", endpoint, nil, &networks)
+	if err != nil {
+		return Network{}, err
+	}
+	if len(networks) == 0 {
+		return Network{}, fmt.Errorf("network %s: %w", id, ErrNotFound)
+	}
+	return networks[0], nil
+}
+
+// CreateNetwork creates a network and returns it as stored, with its ID and
+// the console's defaults filled in.
+func (c *UniFiClient) CreateNetwork(ctx context.Context, site string, network Network) (Network, error) {
+	if network.ID != "" {
+		return Network{}, fmt.Errorf("network %s already has an ID", network.Name)
+	}
+	endpoint := c.networkEndpoint(EndpointCreateNetwork, site)
+	return c.writeNetwork(ctx, "POST", endpoint, network)
+}
+
+// UpdateNetwork replaces the settings of the network with network.ID. Use
+// DiffNetworks first to see what will change.
+func (c *UniFiClient) UpdateNetwork(ctx context.Context, site string, network Network) (Network, error) {
+	if network.ID == "" {
+		return Network{}, fmt.Errorf("network %s has no ID", network.Name)
+	}
+	endpoint := c.networkEndpoint(EndpointUpdateNetwork, site, network.ID)
+	return c.writeNetwork(ctx, "PUT", endpoint, network)
+}
+
+func (c *UniFiClient) DeleteNetwork(ctx context.Context, site, id string) error {
+	endpoint := c.networkEndpoint(EndpointDeleteNetwork, site, id)
+	return c.doRequest(ctx, "DELETE", endpoint, nil, nil)
+}
+
+func (c *UniFiClient) writeNetwork(ctx context.Context, method, endpoint string, network Network) (Network, error) {
+	var networks []Network
+	if err := c.doRequest(ctx, method, endpoint, network, &networks); err != nil {
+		return Network{}, err
+	}
+	if len(networks) == 0 {
+		return Network{}, fmt.Errorf("network %s: console returned no network", network.Name)
+	}
+	return networks[0], nil
+}
+
+// DiffNetworks lists the settings that differ between current and desired,
+// i.e. what UpdateNetwork(desired) would change. Settings kept in Extra are
+// not compared.
+func DiffNetworks(current, desired Network) []FieldChange {
+	return diffFields(current, desired, "_id", "site_id")
+}
+
+func (n Network) MarshalJSON() ([]byte, error) {
+	type plain Network
+	return marshalWithExtra(plain(n), n.Extra)
+}
+
+func (n *Network) UnmarshalJSON(data []byte) error {
+	type plain Network
+	if err := json.Unmarshal(data, (*plain)(n)); err != nil {
+		return err
+	}
+	extra, err := unmarshalExtra(data, reflect.TypeFor[Network]())
+	n.Extra = extra
+	return err
+}
@@ -0,0 +1,146 @@
+package unifi_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkKeepsUnknownSettings(t *testing.T) {
+	data := `{"_id":"1","name":"IoT","purpose":"corporate","enabled":true,"vlan_enabled":true,"vlan":30,` +
+		`"ip_subnet":"10.0.30.1/24","dhcpd_enabled":true,"dhcpd_dns_1":"1.1.1.1","igmp_snooping":true,"mdns_enabled":false}`
+
+	var network unifi.Network
+	require.NoError(t, json.Unmarshal([]byte(data), &network))
+	assert.Equal(t, 30, network.VLAN)
+	assert.Equal(t, "1.1.1.1", network.DHCPDNS1)
+	assert.Equal(t, map[string]json.RawMessage{
+		"igmp_snooping": json.RawMessage("true"),
+		"mdns_enabled":  json.RawMessage("false"),
+	}, network.Extra)
+
+	// Typed fields win over Extra, and the rest is sent back unchanged
+	network.VLAN = 31
+	network.Extra["vlan"] = json.RawMessage("99")
+	encoded, err := json.Marshal(network)
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(encoded, &fields))
+	assert.Equal(t, 31.0, fields["vlan"])
+	assert.Equal(t, true, fields["igmp_snooping"])
+	assert.Equal(t, false, fields["mdns_enabled"])
+	assert.Equal(t, "1.1.1.1", fields["dhcpd_dns_1"])
+}
+
+func TestDiffNetworks(t *testing.T) {
+	current := unifi.Network{
+		ID:          "1",
+		Name:        "IoT",
+		Purpose:     unifi.NetworkPurposeCorporate,
+		Enabled:     true,
+		VLANEnabled: true,
+		VLAN:        30,
+		Subnet:      "10.0.30.1/24",
+		NetworkDHCP: unifi.NetworkDHCP{DHCPEnabled: true, DHCPStart: "10.0.30.6", DHCPStop: "10.0.30.254"},
+		Extra:       map[string]json.RawMessage{"igmp_snooping": json.RawMessage("true")},
+	}
+	assert.Empty(t, unifi.DiffNetworks(current, current))
+
+	desired := current
+	desired.ID = ""
+	desired.VLAN = 31
+	desired.Subnet = "10.0.31.1/24"
+	desired.DHCPDNSEnabled = true
+	desired.DHCPDNS1 = "10.0.0.53"
+	desired.Extra = nil
+
+	changes := unifi.DiffNetworks(current, desired)
+	assert.Equal(t, []unifi.FieldChange{
+		{Field: "vlan", Old: 30, New: 31},
+		{Field: "ip_subnet", Old: "10.0.30.1/24", New: "10.0.31.1/24"},
+		{Field: "dhcpd_dns_enabled", Old: false, New: true},
+		{Field: "dhcpd_dns_1", Old: "", New: "10.0.0.53"},
+	}, changes)
+	assert.Equal(t, `ip_subnet: "10.0.30.1/24" -> "10.0.31.1/24"`, changes[1].String())
+	assert.Equal(t, `dhcpd_dns_enabled: false -> true`, changes[2].String())
+}
+
+func TestNetworkConfiguration(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+
+	networks, err := client.ListNetworks(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, networks, 1)
+	lan := networks[0]
+	assert.Equal(t, "Default", lan.Name)
+	assert.Contains(t, lan.Extra, "attr_hidden_id")
+
+	iot, err := client.CreateNetwork(ctx, "default", unifi.Network{
+		Name:        "IoT",
+		Purpose:     unifi.NetworkPurposeCorporate,
+		Enabled:     true,
+		VLANEnabled: true,
+		VLAN:        30,
+		Subnet:      "10.0.30.1/24",
+		NetworkDHCP: unifi.NetworkDHCP{DHCPEnabled: true, DHCPStart: "10.0.30.6", DHCPStop: "10.0.30.254"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, iot.ID)
+
+	_, err = client.CreateNetwork(ctx, "default", unifi.Network{Name: "Cameras", VLANEnabled: true, VLAN: 30})
+	assert.ErrorContains(t, err, "api.err.VlanUsed")
+
+	// Settings the type does not know survive an update
+	desired := lan
+	desired.DHCPLeaseTime = 3600
+	desired.DomainName = "home.arpa"
+	assert.Len(t, unifi.DiffNetworks(lan, desired), 2)
+	updated, err := client.UpdateNetwork(ctx, "default", desired)
+	require.NoError(t, err)
+	assert.Empty(t, unifi.DiffNetworks(desired, updated))
+	assert.Equal(t, lan.Extra, updated.Extra)
+
+	got, err := client.GetNetwork(ctx, "default", iot.ID)
+	require.NoError(t, err)
+	assert.Equal(t, iot, got)
+
+	require.NoError(t, client.DeleteNetwork(ctx, "default", iot.ID))
+	_, err = client.GetNetwork(ctx, "default", iot.ID)
+	assert.ErrorIs(t, err, unifi.ErrNotFound)
+	assert.Error(t, client.DeleteNetwork(ctx, "default", lan.ID), "the default LAN cannot be deleted")
+	assert.Len(t, server.Networks("default"), 1)
+}
+
+func TestNetworkUpdateClearsSettings(t *testing.T) {
+	_, client := newConsoleClient(t)
+	ctx := context.Background()
+
+	cameras, err := client.CreateNetwork(ctx, "default", unifi.Network{
+		Name:        "Cameras",
+		Purpose:     unifi.NetworkPurposeCorporate,
+		VLANEnabled: true,
+		VLAN:        40,
+		DomainName:  "cameras.home.arpa",
+		NetworkDHCP: unifi.NetworkDHCP{DHCPDNSEnabled: true, DHCPDNS1: "10.0.0.53"},
+	})
+	require.NoError(t, err)
+
+	desired := cameras
+	desired.VLANEnabled = false
+	desired.VLAN = 0
+	desired.DomainName = ""
+	desired.DHCPDNSEnabled = false
+	desired.DHCPDNS1 = ""
+	assert.Len(t, unifi.DiffNetworks(cameras, desired), 5)
+	_, err = client.UpdateNetwork(ctx, "default", desired)
+	require.NoError(t, err)
+
+	got, err := client.GetNetwork(ctx, "default", cameras.ID)
+	require.NoError(t, err)
+	assert.Empty(t, unifi.DiffNetworks(desired, got))
+}
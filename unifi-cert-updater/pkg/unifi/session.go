@@ -0,0 +1,220 @@
+package unifi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Session is a saved login: the console's cookies and CSRF token, and the
+// console and user they belong to.
+type Session struct {
+	BaseURL   string            `json:"baseURL"`
+	Username  string            `json:"username"`
+	Cookies   map[string]string `json:"cookies"`
+	CSRFToken string            `json:"csrfToken,omitempty"`
+	UniFiOS   bool              `json:"unifiOS"`
+	SavedAt   time.Time         `json:"savedAt"`
+}
+
+// SessionStore persists a client's session, so that short-lived processes can
+// resume it instead of logging in on every run.
+type SessionStore interface {
+	// Load returns the saved session, or nil if there is none.
+	Load(ctx context.Context) (*Session, error)
+	Save(ctx context.Context, session *Session) error
+	Delete(ctx context.Context) error
+}
+
+// WithSessionStore resumes the session saved in store on Login, if the
+// console still accepts it, and saves every new session to it.
+func WithSessionStore(store SessionStore) ClientOption {
+	return func(c *UniFiClient) {
+		c.sessionStore = store
+	}
+}
+
+// FileSessionStore saves the session as JSON in a file only its owner can
+// read, since the cookies grant the same access as the password.
+type FileSessionStore struct {
+	Path string
+}
+
+func (s *FileSessionStore) Load(ctx context.Context) (*Session, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session file %s: %w", s.Path, err)
+	}
+	return &session, nil
+}
+
+// Save replaces the file atomically, so a crash never leaves half a session.
+func (s *FileSessionStore) Save(ctx context.Context, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".session-*")
+	if err != nil {
+		return fmt.Errorf("failed to create session file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	// CreateTemp already uses 0600, but be explicit about it
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to restrict session file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to replace session file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSessionStore) Delete(ctx context.Context) error {
+	if err := os.Remove(s.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete session file: %w", err)
+	}
+	return nil
+}
+
+// resumeSession installs the saved session and checks with a cheap request
+// that the console still accepts it. It reports whether the session can be
+// used; on false the caller logs in as usual.
+func (c *UniFiClient) resumeSession(ctx context.Context) bool {
+	saved, err := c.sessionStore.Load(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to load saved session, logging in")
+		return false
+	}
+	if saved == nil || saved.BaseURL != c.BaseURL || saved.Username != c.Username {
+		return false
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || c.HTTPClient.Jar == nil {
+		return false
+	}
+
+	cookies := make([]*http.Cookie, 0, len(saved.Cookies))
+	for name, value := range saved.Cookies {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: value, Path: "/"})
+	}
+	c.HTTPClient.Jar.SetCookies(base, cookies)
+	c.mu.Lock()
+	c.token = saved.Cookies["TOKEN"]
+	c.csrfToken = saved.CSRFToken
+	c.isUniFiOS = saved.UniFiOS
+	c.mu.Unlock()
+
+	// send rather than doRequest: a rejection must not trigger a renewal,
+	// which would wait for the login lock held by our caller
+	endpoint := EndpointSelf
+	if !saved.UniFiOS {
+		endpoint = EndpointLegacySelf
+	}
+	if err := c.send(ctx, http.MethodGet, endpoint, nil, nil); err != nil {
+		logrus.WithError(err).Info("Saved session is no longer valid, logging in")
+		c.clearSession()
+		return false
+	}
+
+	c.session.Add(1)
+	logrus.Infof("Resumed session saved at %s", saved.SavedAt.Format(time.RFC3339))
+	return true
+}
+
+// saveSession saves the current session. A failure only costs a login on the
+// next run, so it is logged rather than returned.
+func (c *UniFiClient) saveSession(ctx context.Context) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || c.HTTPClient.Jar == nil {
+		return
+	}
+	_, csrfToken := c.sessionTokens()
+	saved := &Session{
+		BaseURL:   c.BaseURL,
+		Username:  c.Username,
+		Cookies:   map[string]string{},
+		CSRFToken: csrfToken,
+		UniFiOS:   c.unifiOS(),
+		SavedAt:   time.Now().UTC(),
+	}
+	for _, cookie := range c.HTTPClient.Jar.Cookies(base) {
+		saved.Cookies[cookie.Name] = cookie.Value
+	}
+	if err := c.sessionStore.Save(ctx, saved); err != nil {
+		logrus.WithError(err).Warn("Failed to save session")
+	}
+}
+
+// clearSession forgets the session tokens and expires the console's cookies.
+func (c *UniFiClient) clearSession() {
+	c.mu.Lock()
+	c.token = ""
+	c.csrfToken = ""
+	c.mu.Unlock()
+
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || c.HTTPClient.Jar == nil {
+		return
+	}
+	var expired []*http.Cookie
+	for _, cookie := range c.HTTPClient.Jar.Cookies(base) {
+		expired = append(expired, &http.Cookie{Name: cookie.Name, Path: "/", MaxAge: -1})
+	}
+	c.HTTPClient.Jar.SetCookies(base, expired)
+}
+
+// Logout ends the session on the console and forgets it, including any copy
+// in the session store. The session is forgotten even if the console could
+// not be reached.
+func (c *UniFiClient) Logout(ctx context.Context) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	endpoint := EndpointLogout
+	if c.unifiOS() {
+		endpoint = EndpointAuthLogout
+	}
+	err := c.send(ctx, http.MethodPost, endpoint, nil, nil)
+	c.clearSession()
+	c.session.Add(1)
+
+	if c.sessionStore != nil {
+		if err := c.sessionStore.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete saved session: %w", err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("logout failed: %w", err)
+	}
+	logrus.Info("Logged out of the UniFi API")
+	return nil
+}
@@ -0,0 +1,186 @@
+package unifi_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	store := &unifi.FileSessionStore{Path: filepath.Join(t.TempDir(), "cache", "session.json")}
+	ctx := context.Background()
+
+	saved, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, saved, "expected no session before the first save")
+
+	session := &unifi.Session{
+		BaseURL:   "https://unifi.example.com",
+		Username:  "admin",
+		Cookies:   map[string]string{"TOKEN": "token-1"},
+		CSRFToken: "csrf-1",
+		UniFiOS:   true,
+		SavedAt:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, store.Save(ctx, session))
+	info, err := os.Stat(store.Path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	saved, err = store.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, session, saved)
+
+	require.NoError(t, store.Delete(ctx))
+	require.NoError(t, store.Delete(ctx), "deleting a missing session should succeed")
+	saved, err = store.Load(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, saved)
+}
+
+func TestConcurrentRequestsShareOneRenewal(t *testing.T) {
+	server, client := newConsoleClient(t)
+	server.ExpireSessions()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ListCertificates(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, server.Logins(), "expected a single renewal login")
+}
+
+func TestSessionRenewalRetriesOnce(t *testing.T) {
+	server, client := newConsoleClient(t)
+	server.ExpireSessions()
+	server.SetCredentials(unifitest.DefaultUsername, "changed")
+
+	_, err := client.ListCertificates(context.Background())
+	assert.ErrorContains(t, err, "unexpected status code 401")
+	assert.ErrorContains(t, err, "session renewal failed: all login attempts failed")
+	assert.Equal(t, 1, server.Logins())
+}
+
+func TestSessionStoreResumesSession(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []unifitest.Option
+		self string
+	}{
+		{name: "UniFi OS", self: "GET /api/users/self"},
+		{name: "legacy controller", opts: []unifitest.Option{unifitest.WithLegacyController()}, self: "GET /api/self"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := unifitest.NewServer(tt.opts...)
+			defer server.Close()
+			store := &unifi.FileSessionStore{Path: filepath.Join(t.TempDir(), "session.json")}
+			ctx := context.Background()
+			newClient := func() *unifi.UniFiClient {
+				client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(),
+					unifi.WithSessionStore(store))
+				require.NoError(t, err)
+				require.NoError(t, client.Login(ctx))
+				return client
+			}
+
+			// The first run logs in and saves the session
+			newClient()
+			assert.Equal(t, 1, server.Logins())
+
+			// The next run resumes it, and it works for writes too
+			client := newClient()
+			assert.Equal(t, 1, server.Logins())
+			assert.Contains(t, server.Requests(), tt.self)
+			server.AddClient("default", unifi.Client{Mac: "11:22:33:44:55:66"})
+			require.NoError(t, client.BlockClient(ctx, "default", "11:22:33:44:55:66"))
+			assert.Equal(t, 1, server.Logins())
+
+			// An expired session is replaced by a new login
+			server.ExpireSessions()
+			client = newClient()
+			assert.Equal(t, 2, server.Logins())
+
+			// Logging out ends the session and deletes the saved copy
+			require.NoError(t, client.Logout(ctx))
+			assert.Zero(t, server.Sessions())
+			saved, err := store.Load(ctx)
+			require.NoError(t, err)
+			assert.Nil(t, saved)
+
+			newClient()
+			assert.Equal(t, 3, server.Logins())
+		})
+	}
+}
+
+func TestSessionStoreSavesRotatedCSRFToken(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	store := &unifi.FileSessionStore{Path: filepath.Join(t.TempDir(), "session.json")}
+	ctx := context.Background()
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(),
+		unifi.WithSessionStore(store))
+	require.NoError(t, err)
+	require.NoError(t, client.Login(ctx))
+	before, err := store.Load(ctx)
+	require.NoError(t, err)
+
+	// The rotated token arrives with the next response
+	server.RotateCSRFTokens()
+	_, err = client.ListSites(ctx)
+	require.NoError(t, err)
+	after, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, before.CSRFToken, after.CSRFToken)
+
+	// A resumed session can still write
+	resumed, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(),
+		unifi.WithSessionStore(store))
+	require.NoError(t, err)
+	require.NoError(t, resumed.Login(ctx))
+	server.AddClient("default", unifi.Client{Mac: "11:22:33:44:55:66"})
+	require.NoError(t, resumed.BlockClient(ctx, "default", "11:22:33:44:55:66"))
+	assert.Equal(t, 1, server.Logins())
+}
+
+func TestSessionStoreIgnoresOtherConsoles(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	store := &unifi.FileSessionStore{Path: filepath.Join(t.TempDir(), "session.json")}
+	ctx := context.Background()
+	require.NoError(t, store.Save(ctx, &unifi.Session{
+		BaseURL:  "https://elsewhere.example.com",
+		Username: unifitest.DefaultUsername,
+		Cookies:  map[string]string{"TOKEN": "stolen"},
+	}))
+
+	client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(),
+		unifi.WithSessionStore(store))
+	require.NoError(t, err)
+	require.NoError(t, client.Login(ctx))
+	assert.Equal(t, 1, server.Logins())
+	assert.NotContains(t, server.Requests(), "GET /api/users/self")
+
+	saved, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL, saved.BaseURL)
+}
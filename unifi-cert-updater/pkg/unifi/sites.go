@@ -1,23 +1,29 @@
 package unifi
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
-func (c *UniFiClient) ListSites() ([]Site, error) {
-	endpoint := "/api/self/sites"
+func (c *UniFiClient) ListSites(ctx context.Context) ([]Site, error) {
+	endpoint := c.networkEndpoint(EndpointListSites)
 	var sites []Site
-	err := c.doRequest("GET", endpoint, nil, &sites)
+	err := c.doRequest(ctx, "GET", endpoint, nil, &sites)
 	if err != nil {
 		return nil, err
 	}
 	return sites, nil
 }
 
-func (c *UniFiClient) ListSiteStats(site string) (SiteStats, error) {
-	endpoint := fmt.Sprintf("/api/s/%s/stat/site", site)
-	var stats SiteStats
-	err := c.doRequest("GET", endpoint, nil, &stats)
+func (c *UniFiClient) ListSiteStats(ctx context.Context, site string) (SiteStats, error) {
+	endpoint := c.networkEndpoint(EndpointSiteStats, site)
+	var stats []SiteStats
+	err := c.doRequest(ctx, "GET", endpoint, nil, &stats)
 	if err != nil {
-		return stats, err
+		return SiteStats{}, err
 	}
-	return stats, nil
+	if len(stats) == 0 {
+		return SiteStats{}, fmt.Errorf("stats for site %s: %w", site, ErrNotFound)
+	}
+	return stats[0], nil
 }
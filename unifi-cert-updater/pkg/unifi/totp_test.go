@@ -0,0 +1,107 @@
+package unifi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range tests {
+		code, err := unifi.TOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+
+	// Secrets are often shown in lowercase groups
+	code, err := unifi.TOTPCode("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	_, err = unifi.TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+}
+
+func TestLoginMFA(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	ctx := context.Background()
+	newServer := func(t *testing.T, opts ...unifitest.Option) *unifitest.Server {
+		server := unifitest.NewServer(opts...)
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	t.Run("without MFA", func(t *testing.T) {
+		server := newServer(t)
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithTOTPSecret(secret))
+		require.NoError(t, err)
+
+		require.NoError(t, client.Login(ctx))
+		assert.Equal(t, []string{"POST /api/auth/login"}, server.Requests())
+	})
+
+	t.Run("TOTP secret", func(t *testing.T) {
+		server := newServer(t, unifitest.WithTOTP(secret))
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithTOTPSecret(secret))
+		require.NoError(t, err)
+
+		require.NoError(t, client.Login(ctx))
+		assert.Equal(t, []string{"POST /api/auth/login", "POST /api/auth/login"}, server.Requests())
+		_, err = client.ListCertificates(ctx)
+		assert.NoError(t, err, "expected a UniFi OS session")
+	})
+
+	t.Run("TOTP secret on a legacy controller", func(t *testing.T) {
+		server := newServer(t, unifitest.WithLegacyController(), unifitest.WithTOTP(secret))
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithTOTPSecret(secret))
+		require.NoError(t, err)
+
+		require.NoError(t, client.Login(ctx))
+		assert.Equal(t, 1, server.Logins())
+	})
+
+	t.Run("pre-supplied token", func(t *testing.T) {
+		server := newServer(t, unifitest.WithTOTP(secret))
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithMFAToken(server.TOTPCode()))
+		require.NoError(t, err)
+
+		require.NoError(t, client.Login(ctx))
+		assert.Equal(t, 1, server.Logins())
+		assert.Empty(t, client.MFAToken, "a one-time token must only be used once")
+	})
+
+	t.Run("no second factor configured", func(t *testing.T) {
+		server := newServer(t, unifitest.WithTOTP(secret))
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client())
+		require.NoError(t, err)
+
+		err = client.Login(ctx)
+		assert.True(t, errors.Is(err, unifi.ErrMFARequired), "unexpected error: %v", err)
+		assert.Len(t, server.Requests(), 1, "other endpoints must not be tried")
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		server := newServer(t, unifitest.WithTOTP(secret))
+		client, err := unifi.NewClient(server.URL, unifitest.DefaultUsername, unifitest.DefaultPassword, server.Client(), unifi.WithMFAToken("12345"))
+		require.NoError(t, err)
+
+		err = client.Login(ctx)
+		assert.ErrorContains(t, err, "two-factor login failed")
+		assert.True(t, errors.Is(err, unifi.ErrUnauthorized))
+		assert.Zero(t, server.Logins())
+	})
+}
@@ -1,6 +1,10 @@
 package unifi
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // Common Types
 
@@ -55,18 +59,76 @@ type Site struct {
 
 // Device represents a UniFi device object.
 type Device struct {
-	ID            string  `json:"_id"`
-	Name          string  `json:"name"`
-	Model         string  `json:"model"`
-	MAC           string  `json:"mac"`
-	IP            string  `json:"ip"`
-	Adopted       bool    `json:"adopted"`
-	LastSeen      int64   `json:"last_seen"`
-	Firmware      string  `json:"firmware"`
-	Uptime        int64   `json:"uptime"`
-	Status        string  `json:"status"`
-	DownlinkCount int     `json:"num_sta"`
-	Uplink        *Uplink `json:"uplink,omitempty"` // Optional, only for devices with uplinks
+	ID            string      `json:"_id"`
+	Name          string      `json:"name"`
+	Model         string      `json:"model"`
+	MAC           string      `json:"mac"`
+	IP            string      `json:"ip"`
+	Adopted       bool        `json:"adopted"`
+	LastSeen      int64       `json:"last_seen"`
+	Firmware      string      `json:"firmware"`
+	Version       string      `json:"version,omitempty"` // Running firmware version
+	Upgradable    bool        `json:"upgradable,omitempty"`
+	Uptime        int64       `json:"uptime"`
+	ProvisionedAt int64       `json:"provisioned_at,omitempty"` // Unix time the configuration was last pushed
+	Status        string      `json:"status"`
+	State         DeviceState `json:"state"`
+	Locating      bool        `json:"locating,omitempty"`
+	DownlinkCount int         `json:"num_sta"`
+	Uplink        *Uplink     `json:"uplink,omitempty"`     // Optional, only for devices with uplinks
+	PortTable     []Port      `json:"port_table,omitempty"` // Optional, only for switches and gateways
+}
+
+// DeviceState is the lifecycle state the controller reports for a device.
+type DeviceState int
+
+const (
+	DeviceStateDisconnected    DeviceState = 0
+	DeviceStateConnected       DeviceState = 1
+	DeviceStatePendingAdoption DeviceState = 2
+	DeviceStateUpgrading       DeviceState = 4
+	DeviceStateProvisioning    DeviceState = 5
+	DeviceStateHeartbeatMissed DeviceState = 6
+	DeviceStateAdopting        DeviceState = 7
+	DeviceStateAdoptionError   DeviceState = 9
+	DeviceStateAdoptionFailed  DeviceState = 10
+	DeviceStateIsolated        DeviceState = 11
+)
+
+func (s DeviceState) String() string {
+	switch s {
+	case DeviceStateDisconnected:
+		return "disconnected"
+	case DeviceStateConnected:
+		return "connected"
+	case DeviceStatePendingAdoption:
+		return "pending adoption"
+	case DeviceStateUpgrading:
+		return "upgrading"
+	case DeviceStateProvisioning:
+		return "provisioning"
+	case DeviceStateHeartbeatMissed:
+		return "heartbeat missed"
+	case DeviceStateAdopting:
+		return "adopting"
+	case DeviceStateAdoptionError:
+		return "adoption error"
+	case DeviceStateAdoptionFailed:
+		return "adoption failed"
+	case DeviceStateIsolated:
+		return "isolated"
+	}
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+// Port represents a switch port of a device.
+type Port struct {
+	PortIdx int    `json:"port_idx"`
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`
+	Speed   int    `json:"speed"`
+	Uptime  int64  `json:"uptime,omitempty"` // Seconds the link has been up
+	PoEMode string `json:"poe_mode,omitempty"`
 }
 
 // Uplink represents the uplink information for a device.
@@ -115,12 +177,30 @@ type SubjectAlt struct {
 
 // Client represents a UniFi client object.
 type Client struct {
-	ID        string `json:"_id"`
-	Mac       string `json:"mac"`
-	IP        string `json:"ip"`
-	Hostname  string `json:"hostname"`
-	Connected bool   `json:"connected"`
-	SiteID    string `json:"site_id"`
+	ID         string `json:"_id"`
+	UserID     string `json:"user_id"` // ID of the stored client, used to set its name or note
+	Mac        string `json:"mac"`
+	IP         string `json:"ip"`
+	Hostname   string `json:"hostname"`
+	Name       string `json:"name"`
+	Note       string `json:"note"`
+	Connected  bool   `json:"connected"`
+	Blocked    bool   `json:"blocked"`
+	IsGuest    bool   `json:"is_guest"`
+	Authorized bool   `json:"authorized"` // Guest has been let through the hotspot
+	SiteID     string `json:"site_id"`
+}
+
+// GuestAuthorizePayload represents the payload to authorize a guest. Zero
+// limits leave the guest unrestricted.
+type GuestAuthorizePayload struct {
+	Cmd     string `json:"cmd"`
+	MAC     string `json:"mac"`
+	Minutes int    `json:"minutes"`
+	Up      int    `json:"up,omitempty"`     // Upload limit in Kbps
+	Down    int    `json:"down,omitempty"`   // Download limit in Kbps
+	Bytes   int    `json:"bytes,omitempty"`  // Data quota in MB
+	APMAC   string `json:"ap_mac,omitempty"` // AP the guest is connected to
 }
 
 // Health Metrics
@@ -146,22 +226,38 @@ type DashboardMetric struct {
 
 // Voucher represents a guest voucher.
 type Voucher struct {
-	Code      string `json:"code"`
-	Duration  int    `json:"duration"`
-	Quota     int    `json:"quota"`
-	Note      string `json:"note"`
-	CreatedAt int64  `json:"created_at"`
-	Status    string `json:"status"`
+	ID            string `json:"_id"`
+	Code          string `json:"code"`
+	Duration      int    `json:"duration"` // Minutes of access once redeemed
+	Quota         int    `json:"quota"`    // Number of uses, 0 for unlimited
+	Used          int    `json:"used"`     // Number of times redeemed
+	Note          string `json:"note"`
+	CreatedAt     int64  `json:"create_time"` // Unix time, shared by a batch
+	Status        string `json:"status"`
+	StatusExpires int64  `json:"status_expires,omitempty"` // Seconds left once in use
+	AdminName     string `json:"admin_name,omitempty"`
+	Up            int    `json:"qos_rate_max_up,omitempty"`   // Upload limit in Kbps
+	Down          int    `json:"qos_rate_max_down,omitempty"` // Download limit in Kbps
+	Bytes         int    `json:"qos_usage_quota,omitempty"`   // Data quota in MB
 }
 
-// VoucherCreatePayload represents the payload to create a voucher.
+// Voucher statuses reported by the console
+const (
+	VoucherStatusValidOnce    = "VALID_ONE"     // Single-use voucher, not redeemed yet
+	VoucherStatusValidMulti   = "VALID_MULTI"   // Multi-use voucher, not redeemed yet
+	VoucherStatusUsedMultiple = "USED_MULTIPLE" // Multi-use voucher with uses left
+)
+
+// VoucherCreatePayload represents the payload to create vouchers.
 type VoucherCreatePayload struct {
 	Cmd       string `json:"cmd"`
-	Minutes   int    `json:"minutes"`
-	Quota     int    `json:"quota"`
-	Note      string `json:"note"`
-	UpLimit   int    `json:"up_limit,omitempty"`
-	DownLimit int    `json:"down_limit,omitempty"`
+	Count     int    `json:"n"`      // Number of vouchers, defaults to 1
+	Minutes   int    `json:"expire"` // Minutes of access once redeemed
+	Quota     int    `json:"quota"`  // Number of uses per voucher, 0 for unlimited
+	Note      string `json:"note,omitempty"`
+	UpLimit   int    `json:"up,omitempty"`    // Upload limit in Kbps
+	DownLimit int    `json:"down,omitempty"`  // Download limit in Kbps
+	ByteLimit int    `json:"bytes,omitempty"` // Data quota in MB
 }
 
 // SiteStats represents the statistics for a specific site.
@@ -185,3 +281,150 @@ type Health struct {
 	SubSystem string `json:"subsystem"`
 	NumErrors int    `json:"num_errors"`
 }
+
+// Network Configuration
+
+// NetworkPurpose is what a network configuration is used for.
+type NetworkPurpose string
+
+const (
+	NetworkPurposeCorporate     NetworkPurpose = "corporate" // Routed LAN or VLAN
+	NetworkPurposeGuest         NetworkPurpose = "guest"     // Isolated guest network
+	NetworkPurposeVLANOnly      NetworkPurpose = "vlan-only" // VLAN the gateway does not route
+	NetworkPurposeWAN           NetworkPurpose = "wan"
+	NetworkPurposeRemoteUserVPN NetworkPurpose = "remote-user-vpn"
+	NetworkPurposeSiteVPN       NetworkPurpose = "site-vpn"
+)
+
+// Network represents a network configuration (networkconf). Settings this
+// type does not cover are kept in Extra and sent back unchanged on update.
+// Empty settings are sent too, as the console keeps what an update leaves out.
+type Network struct {
+	ID           string         `json:"_id,omitempty"`
+	SiteID       string         `json:"site_id,omitempty"`
+	Name         string         `json:"name"`
+	Purpose      NetworkPurpose `json:"purpose"`
+	NetworkGroup string         `json:"networkgroup"` // "LAN" for local networks
+	Enabled      bool           `json:"enabled"`
+	VLANEnabled  bool           `json:"vlan_enabled"`
+	VLAN         int            `json:"vlan"`
+	Subnet       string         `json:"ip_subnet"` // Gateway address and prefix, e.g. 192.168.10.1/24
+	DomainName   string         `json:"domain_name"`
+	NetworkDHCP
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// NetworkDHCP is the DHCP server configuration of a network, including the
+// options it hands out.
+type NetworkDHCP struct {
+	DHCPEnabled   bool   `json:"dhcpd_enabled"`
+	DHCPStart     string `json:"dhcpd_start"`
+	DHCPStop      string `json:"dhcpd_stop"`
+	DHCPLeaseTime int    `json:"dhcpd_leasetime"` // Seconds
+
+	DHCPDNSEnabled      bool   `json:"dhcpd_dns_enabled"` // Hand out DHCPDNS1-4 instead of the gateway
+	DHCPDNS1            string `json:"dhcpd_dns_1"`
+	DHCPDNS2            string `json:"dhcpd_dns_2"`
+	DHCPDNS3            string `json:"dhcpd_dns_3"`
+	DHCPDNS4            string `json:"dhcpd_dns_4"`
+	DHCPGatewayEnabled  bool   `json:"dhcpd_gateway_enabled"` // Hand out DHCPGateway instead of the gateway
+	DHCPGateway         string `json:"dhcpd_gateway"`
+	DHCPNTPEnabled      bool   `json:"dhcpd_ntp_enabled"`
+	DHCPNTP1            string `json:"dhcpd_ntp_1"`
+	DHCPNTP2            string `json:"dhcpd_ntp_2"`
+	DHCPBootEnabled     bool   `json:"dhcpd_boot_enabled"`
+	DHCPBootServer      string `json:"dhcpd_boot_server"`      // Option 66
+	DHCPBootFilename    string `json:"dhcpd_boot_filename"`    // Option 67
+	DHCPTFTPServer      string `json:"dhcpd_tftp_server"`      // Option 150
+	DHCPUniFiController string `json:"dhcpd_unifi_controller"` // Option 43
+}
+
+// Wireless Networks
+
+// Security modes of a WLAN
+const (
+	WLANSecurityOpen   = "open"
+	WLANSecurityWPAPSK = "wpapsk" // Shared passphrase
+	WLANSecurityWPAEAP = "wpaeap" // Enterprise, RADIUS
+)
+
+// WLAN represents a wireless network configuration (wlanconf). Settings this
+// type does not cover are kept in Extra and sent back unchanged on update.
+// Empty settings are sent too, as the console keeps what an update leaves out.
+type WLAN struct {
+	ID              string         `json:"_id,omitempty"`
+	SiteID          string         `json:"site_id,omitempty"`
+	Name            string         `json:"name"` // SSID
+	Enabled         bool           `json:"enabled"`
+	Security        string         `json:"security"`
+	WPAMode         string         `json:"wpa_mode"` // e.g. "wpa2"
+	Passphrase      string         `json:"x_passphrase"`
+	IsGuest         bool           `json:"is_guest"`
+	HideSSID        bool           `json:"hide_ssid"`
+	NetworkID       string         `json:"networkconf_id"`
+	ScheduleEnabled bool           `json:"schedule_enabled"`
+	Schedule        []WLANSchedule `json:"schedule_with_duration"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// WLANSchedule is a weekly window in which a scheduled WLAN is broadcast.
+type WLANSchedule struct {
+	Name            string   `json:"name,omitempty"`
+	StartDaysOfWeek []string `json:"start_days_of_week"` // "mon" to "sun"
+	StartHour       int      `json:"start_hour"`
+	StartMinute     int      `json:"start_minute"`
+	DurationMinutes int      `json:"duration_minutes"`
+}
+
+// Integration API
+
+// Page is one page of a paginated integration API listing.
+type Page[T any] struct {
+	Offset     int `json:"offset"`
+	Limit      int `json:"limit"`
+	Count      int `json:"count"`
+	TotalCount int `json:"totalCount"`
+	Data       []T `json:"data"`
+}
+
+// PageRequest selects a page of a listing. A zero Limit uses the console's
+// default page size.
+type PageRequest struct {
+	Offset int
+	Limit  int
+}
+
+// IntegrationSite is a site as seen by the integration API. Its ID, not its
+// internal reference, is used in integration API paths.
+type IntegrationSite struct {
+	ID                string `json:"id"`
+	InternalReference string `json:"internalReference"` // The legacy site name, e.g. "default"
+	Name              string `json:"name"`
+}
+
+// IntegrationDevice is an adopted device as seen by the integration API.
+type IntegrationDevice struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	Model             string   `json:"model"`
+	MACAddress        string   `json:"macAddress"`
+	IPAddress         string   `json:"ipAddress"`
+	State             string   `json:"state"` // e.g. "ONLINE", "OFFLINE"
+	FirmwareVersion   string   `json:"firmwareVersion,omitempty"`
+	FirmwareUpdatable bool     `json:"firmwareUpdatable,omitempty"`
+	Features          []string `json:"features,omitempty"`
+	Interfaces        []string `json:"interfaces,omitempty"`
+}
+
+// IntegrationClient is a connected client as seen by the integration API.
+type IntegrationClient struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"` // "WIRED", "WIRELESS" or "VPN"
+	Name           string    `json:"name"`
+	ConnectedAt    time.Time `json:"connectedAt"`
+	IPAddress      string    `json:"ipAddress"`
+	MACAddress     string    `json:"macAddress"`
+	UplinkDeviceID string    `json:"uplinkDeviceId,omitempty"`
+}
@@ -0,0 +1,207 @@
+package unifitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+)
+
+// certificate is an uploaded certificate with its key pair.
+type certificate struct {
+	unifi.Certificate
+	keyPair tls.Certificate
+}
+
+// AddCertificate stores a certificate as if it had been uploaded, and
+// activates it if active is set.
+func (s *Server) AddCertificate(name, certPEM, keyPEM string, active bool) (unifi.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert, err := s.storeCertificate(name, certPEM, keyPEM)
+	if err != nil {
+		return unifi.Certificate{}, err
+	}
+	if active {
+		s.activate(cert)
+	}
+	return cert.Certificate, nil
+}
+
+// Certificates returns the certificate store.
+func (s *Server) Certificates() []unifi.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listCertificates()
+}
+
+// serveCertificates serves /api/userCertificates and the paths below it.
+func (s *Server) serveCertificates(w http.ResponseWriter, r *http.Request, rest string) {
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.listCertificates())
+	case rest == "" && r.Method == http.MethodPost:
+		var payload struct {
+			Name string `json:"name"`
+			Cert string `json:"cert"`
+			Key  string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		cert, err := s.storeCertificate(payload.Name, payload.Cert, payload.Key)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, cert.Certificate)
+	case strings.HasSuffix(rest, "/status") && r.Method == http.MethodPut:
+		cert := s.findCertificate(strings.TrimSuffix(strings.TrimPrefix(rest, "/"), "/status"))
+		if cert == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "certificate not found"})
+			return
+		}
+		var payload struct {
+			Active bool `json:"active"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Active {
+			s.activate(cert)
+		}
+		writeJSON(w, http.StatusOK, cert.Certificate)
+	case r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(rest, "/")
+		cert := s.findCertificate(id)
+		if cert == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "certificate not found"})
+			return
+		}
+		if cert.Active {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the active certificate cannot be deleted"})
+			return
+		}
+		for i, c := range s.certificates {
+			if c == cert {
+				s.certificates = append(s.certificates[:i], s.certificates[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) listCertificates() []unifi.Certificate {
+	certificates := make([]unifi.Certificate, 0, len(s.certificates))
+	for _, cert := range s.certificates {
+		certificates = append(certificates, cert.Certificate)
+	}
+	return certificates
+}
+
+func (s *Server) findCertificate(id string) *certificate {
+	for _, cert := range s.certificates {
+		if cert.ID == id {
+			return cert
+		}
+	}
+	return nil
+}
+
+// storeCertificate validates an uploaded key pair and adds it to the store.
+func (s *Server) storeCertificate(name, certPEM, keyPEM string) (*certificate, error) {
+	keyPair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate or key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	seq := s.nextSeq()
+	cert := &certificate{
+		Certificate: unifi.Certificate{
+			ID:           fmt.Sprintf("%08x-0000-4000-8000-%012x", seq, seq),
+			Name:         name,
+			SerialNumber: leaf.SerialNumber.Text(16),
+			Fingerprint:  fingerprint(leaf),
+			Subject:      unifi.Subject{CN: leaf.Subject.CommonName},
+			Issuer:       unifi.Issuer{CN: leaf.Issuer.CommonName, O: strings.Join(leaf.Issuer.Organization, ", "), C: strings.Join(leaf.Issuer.Country, ", ")},
+			SubjectAlt:   unifi.SubjectAlt{DNS: leaf.DNSNames},
+			ValidFrom:    leaf.NotBefore.UTC(),
+			ValidTo:      leaf.NotAfter.UTC(),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		},
+		keyPair: keyPair,
+	}
+	s.certificates = append(s.certificates, cert)
+	return cert, nil
+}
+
+// activate makes cert the only active certificate.
+func (s *Server) activate(cert *certificate) {
+	for _, c := range s.certificates {
+		c.Active = c == cert
+	}
+	cert.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+}
+
+// fingerprint formats the SHA-1 fingerprint the way the console does.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// GenerateCertificate returns a self-signed certificate and key, valid from an
+// hour ago for a day, for uploading to a Server.
+func GenerateCertificate(commonName string, dnsNames ...string) (certPEM, keyPEM string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, nil
+}
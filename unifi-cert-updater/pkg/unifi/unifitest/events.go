@@ -0,0 +1,109 @@
+package unifitest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"golang.org/x/net/websocket"
+)
+
+// SendEvents sends messages, as the raw JSON the console sends, on the event
+// stream of a site. Messages sent while no stream is open are delivered to
+// the next one.
+func (s *Server) SendEvents(site string, messages ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[site] = append(s.events[site], messages...)
+	s.eventsCond.Broadcast()
+}
+
+// CloseEventStreams drops every open event stream, as a console restart
+// would.
+func (s *Server) CloseEventStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.eventStreams {
+		s.closeEventStream(conn)
+	}
+}
+
+// eventsSite returns the site whose event stream path is requested, if any.
+func (s *Server) eventsSite(path string) (string, bool) {
+	if !s.legacy {
+		var ok bool
+		if path, ok = strings.CutPrefix(path, unifi.NetworkPathPrefix); !ok {
+			return "", false
+		}
+	}
+	site, ok := strings.CutPrefix(path, "/wss/s/")
+	if !ok {
+		return "", false
+	}
+	site, ok = strings.CutSuffix(site, "/events")
+	return site, ok && site != "" && !strings.Contains(site, "/")
+}
+
+// serveEvents serves the event stream of a site. Unlike other requests it is
+// served without holding s.mu, since the stream stays open.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, site string) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	sess := s.authenticate(w, r)
+	s.mu.Unlock()
+	if sess == nil {
+		return
+	}
+
+	websocket.Handler(func(conn *websocket.Conn) {
+		s.mu.Lock()
+		s.eventStreams[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			// The client sends nothing, so this returns once it goes away
+			var discard []byte
+			_ = websocket.Message.Receive(conn, &discard)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.closeEventStream(conn)
+		}()
+
+		for {
+			s.mu.Lock()
+			_, open := s.eventStreams[conn]
+			for open && len(s.events[site]) == 0 {
+				s.eventsCond.Wait()
+				_, open = s.eventStreams[conn]
+			}
+			messages := s.events[site]
+			if open {
+				s.events[site] = nil
+			}
+			s.mu.Unlock()
+			if !open {
+				return
+			}
+
+			for _, msg := range messages {
+				if err := websocket.Message.Send(conn, msg); err != nil {
+					s.mu.Lock()
+					s.closeEventStream(conn)
+					s.mu.Unlock()
+					return
+				}
+			}
+		}
+	}).ServeHTTP(w, r)
+}
+
+// closeEventStream closes an event stream if it is still open. s.mu must be
+// held.
+func (s *Server) closeEventStream(conn *websocket.Conn) {
+	if _, open := s.eventStreams[conn]; !open {
+		return
+	}
+	delete(s.eventStreams, conn)
+	_ = conn.Close()
+	s.eventsCond.Broadcast()
+}
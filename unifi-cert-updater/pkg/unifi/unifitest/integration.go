@@ -0,0 +1,150 @@
+package unifitest
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+)
+
+// integrationPathPrefix is where UniFi OS serves the integration API.
+const integrationPathPrefix = "/proxy/network/integration/v1"
+
+// defaultPageSize is the integration API's page size when none is asked for.
+const defaultPageSize = 25
+
+// WithAPIKey enables the integration API, which only accepts requests with
+// this key in the X-API-Key header. It is served by UniFi OS consoles only.
+func WithAPIKey(apiKey string) Option {
+	return func(s *Server) {
+		s.apiKey = apiKey
+	}
+}
+
+// WithPageSize replaces the integration API's default page size of 25.
+func WithPageSize(pageSize int) Option {
+	return func(s *Server) {
+		s.pageSize = pageSize
+	}
+}
+
+// AddIntegrationClient adds a connected client to the integration API's view
+// of a site, which is kept apart from the Network API's clients since those
+// lack the connection details. The ID is generated if empty.
+func (s *Server) AddIntegrationClient(site string, client unifi.IntegrationClient) unifi.IntegrationClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client.ID == "" {
+		client.ID = s.newID()
+	}
+	s.integrationClients[site] = append(s.integrationClients[site], client)
+	return client
+}
+
+// serveIntegration serves the integration API. Sites and devices are the
+// Network API's, as the integration API presents them; path has
+// integrationPathPrefix removed.
+func (s *Server) serveIntegration(w http.ResponseWriter, r *http.Request, path string) {
+	if s.apiKey == "" || r.Header.Get("X-API-Key") != s.apiKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{
+			"statusCode": http.StatusUnauthorized,
+			"statusName": "UNAUTHORIZED",
+			"message":    "Missing or invalid API key",
+		})
+		return
+	}
+
+	// /sites[/{site ID}/{collection}[/{id}]]
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if parts[0] != "sites" || r.Method != http.MethodGet || len(parts) == 2 || len(parts) > 4 {
+		writeIntegrationNotFound(w)
+		return
+	}
+	if len(parts) == 1 {
+		sites := make([]unifi.IntegrationSite, 0, len(s.sites))
+		for _, site := range s.sites {
+			sites = append(sites, unifi.IntegrationSite{ID: site.ID, InternalReference: site.Name, Name: site.Description})
+		}
+		writePage(w, r, s.pageSize, sites)
+		return
+	}
+
+	i := slices.IndexFunc(s.sites, func(site unifi.Site) bool { return site.ID == parts[1] })
+	if i < 0 {
+		writeIntegrationNotFound(w)
+		return
+	}
+	site := s.sites[i].Name
+
+	switch {
+	case parts[2] == "devices":
+		devices := make([]unifi.IntegrationDevice, 0, len(s.devices[site]))
+		for _, device := range s.devices[site] {
+			if len(parts) == 4 && device.ID == parts[3] {
+				writeJSON(w, http.StatusOK, integrationDevice(device))
+				return
+			}
+			devices = append(devices, integrationDevice(device))
+		}
+		if len(parts) == 4 {
+			writeIntegrationNotFound(w)
+			return
+		}
+		writePage(w, r, s.pageSize, devices)
+	case parts[2] == "clients" && len(parts) == 3:
+		writePage(w, r, s.pageSize, s.integrationClients[site])
+	default:
+		writeIntegrationNotFound(w)
+	}
+}
+
+// integrationDevice presents a device the way the integration API does.
+func integrationDevice(device unifi.Device) unifi.IntegrationDevice {
+	result := unifi.IntegrationDevice{
+		ID:                device.ID,
+		Name:              device.Name,
+		Model:             device.Model,
+		MACAddress:        device.MAC,
+		IPAddress:         device.IP,
+		State:             "OFFLINE",
+		FirmwareVersion:   device.Version,
+		FirmwareUpdatable: device.Upgradable,
+	}
+	if device.State == unifi.DeviceStateConnected {
+		result.State = "ONLINE"
+	}
+	if len(device.PortTable) > 0 {
+		result.Features = []string{"switching"}
+		result.Interfaces = []string{"ports"}
+	}
+	return result
+}
+
+// writePage writes the page of items selected by the offset and limit query
+// parameters.
+func writePage[T any](w http.ResponseWriter, r *http.Request, pageSize int, items []T) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit := pageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		limit = l
+	}
+	offset = min(max(offset, 0), len(items))
+	end := min(offset+limit, len(items))
+	writeJSON(w, http.StatusOK, unifi.Page[T]{
+		Offset:     offset,
+		Limit:      limit,
+		Count:      end - offset,
+		TotalCount: len(items),
+		Data:       nonNil(items)[offset:end],
+	})
+}
+
+func writeIntegrationNotFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]any{
+		"statusCode": http.StatusNotFound,
+		"statusName": "NOT_FOUND",
+		"message":    "Not found",
+	})
+}
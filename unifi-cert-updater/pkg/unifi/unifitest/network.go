@@ -0,0 +1,374 @@
+package unifitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+)
+
+// AddSite adds a site. The ID is generated if empty.
+func (s *Server) AddSite(site unifi.Site) unifi.Site {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if site.ID == "" {
+		site.ID = s.newID()
+	}
+	s.sites = append(s.sites, site)
+	return site
+}
+
+// AddDevice adds a device to a site. The ID is generated if empty.
+func (s *Server) AddDevice(site string, device unifi.Device) unifi.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if device.ID == "" {
+		device.ID = s.newID()
+	}
+	s.devices[site] = append(s.devices[site], device)
+	return device
+}
+
+// QueueDeviceStates scripts how a device of a site changes, e.g. while it
+// restarts: each request for the device moves it to the next state, and it
+// then stays in the last one. Commands leave a scripted device as it is.
+func (s *Server) QueueDeviceStates(site, mac string, states ...unifi.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceStates[site+"/"+mac] = states
+}
+
+// Devices returns the devices of a site.
+func (s *Server) Devices(site string) []unifi.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.devices[site])
+}
+
+// AddClient adds a client to a site. The IDs are generated if empty.
+func (s *Server) AddClient(site string, client unifi.Client) unifi.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client.ID == "" {
+		client.ID = s.newID()
+	}
+	if client.UserID == "" {
+		client.UserID = client.ID
+	}
+	s.clients[site] = append(s.clients[site], client)
+	return client
+}
+
+// Clients returns the clients of a site.
+func (s *Server) Clients(site string) []unifi.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.clients[site])
+}
+
+// Vouchers returns the vouchers of a site.
+func (s *Server) Vouchers(site string) []unifi.Voucher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.vouchers[site])
+}
+
+// AddVoucher adds a voucher to a site. The ID, code, status and creation time
+// are filled in if empty.
+func (s *Server) AddVoucher(site string, voucher unifi.Voucher) unifi.Voucher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if voucher.CreatedAt == 0 {
+		voucher.CreatedAt = time.Now().Unix()
+	}
+	voucher = s.newVoucher(voucher)
+	s.vouchers[site] = append(s.vouchers[site], voucher)
+	return voucher
+}
+
+// serveNetwork serves the Network application's API. path has the UniFi OS
+// prefix removed.
+func (s *Server) serveNetwork(w http.ResponseWriter, r *http.Request, path string) {
+	if path == unifi.EndpointListSites && r.Method == http.MethodGet {
+		writeData(w, s.sites)
+		return
+	}
+
+	// /api/s/{site}/{kind}/{name}[/{id}]
+	parts := strings.Split(strings.TrimPrefix(path, "/api/s/"), "/")
+	if !strings.HasPrefix(path, "/api/s/") || len(parts) < 3 || len(parts) > 4 {
+		http.NotFound(w, r)
+		return
+	}
+	site, route := parts[0], parts[1]+"/"+parts[2]
+	if !slices.ContainsFunc(s.sites, func(st unifi.Site) bool { return st.Name == site }) {
+		writeError(w, http.StatusBadRequest, "api.err.NoSiteContext")
+		return
+	}
+	var id string
+	if len(parts) == 4 {
+		id = parts[3]
+	}
+
+	switch {
+	case route == "stat/site" && id == "":
+		s.siteStats(w, site)
+	case route == "stat/device" && r.Method == http.MethodGet:
+		devices := s.devices[site]
+		if id != "" {
+			s.nextDeviceState(site, id)
+			devices = slices.DeleteFunc(slices.Clone(devices), func(d unifi.Device) bool { return d.MAC != id })
+		}
+		writeData(w, nonNil(devices))
+	case route == "cmd/devmgr" && r.Method == http.MethodPost:
+		s.deviceCommand(w, r, site)
+	case route == "stat/sta" && r.Method == http.MethodGet:
+		writeData(w, nonNil(s.clients[site]))
+	case route == "cmd/stamgr" && r.Method == http.MethodPost:
+		s.clientCommand(w, r, site)
+	case route == "rest/user" && id != "" && r.Method == http.MethodPut:
+		s.updateClient(w, r, site, id)
+	case route == "stat/voucher":
+		s.listVouchers(w, r, site)
+	case route == "cmd/hotspot" && r.Method == http.MethodPost:
+		s.hotspotCommand(w, r, site)
+	case parts[1] == "rest" && restValidators[parts[2]] != nil:
+		s.serveREST(w, r, site, parts[2], id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) siteStats(w http.ResponseWriter, site string) {
+	for _, st := range s.sites {
+		if st.Name != site {
+			continue
+		}
+		var guests int
+		for _, client := range s.clients[site] {
+			if client.IsGuest {
+				guests++
+			}
+		}
+		writeData(w, []unifi.SiteStats{{
+			ID:              st.ID,
+			Name:            st.Name,
+			Description:     st.Description,
+			NumClients:      len(s.clients[site]),
+			NumDevices:      len(s.devices[site]),
+			NumGuestDevices: guests,
+		}})
+		return
+	}
+}
+
+// command is the body of a cmd/ request.
+type command struct {
+	Cmd     string   `json:"cmd"`
+	MAC     string   `json:"mac"`
+	MACs    []string `json:"macs"`
+	ID      string   `json:"_id"`
+	PortIdx int      `json:"port_idx"`
+}
+
+func decodeCommand(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "api.err.InvalidPayload")
+		return false
+	}
+	return true
+}
+
+// nextDeviceState moves a device to its next queued state, if it has any.
+func (s *Server) nextDeviceState(site, mac string) {
+	states := s.deviceStates[site+"/"+mac]
+	i := slices.IndexFunc(s.devices[site], func(d unifi.Device) bool { return d.MAC == mac })
+	if len(states) == 0 || i < 0 {
+		return
+	}
+	state := states[0]
+	state.ID = s.devices[site][i].ID
+	s.devices[site][i] = state
+	if len(states) > 1 {
+		s.deviceStates[site+"/"+mac] = states[1:]
+	}
+}
+
+func (s *Server) deviceCommand(w http.ResponseWriter, r *http.Request, site string) {
+	var cmd command
+	if !decodeCommand(w, r, &cmd) {
+		return
+	}
+	i := slices.IndexFunc(s.devices[site], func(d unifi.Device) bool { return d.MAC == cmd.MAC })
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "api.err.UnknownDevice")
+		return
+	}
+	device := &s.devices[site][i]
+
+	switch cmd.Cmd {
+	case "restart":
+		device.Uptime = 0
+		device.State = unifi.DeviceStateConnected
+	case "set-locate", "unset-locate":
+		device.Locating = cmd.Cmd == "set-locate"
+	case "adopt":
+		device.Adopted = true
+		device.State = unifi.DeviceStateConnected
+	case "force-provision":
+		device.ProvisionedAt = max(time.Now().Unix(), device.ProvisionedAt+1)
+	case "power-cycle":
+		for i := range device.PortTable {
+			if device.PortTable[i].PortIdx == cmd.PortIdx {
+				device.PortTable[i].Uptime = 0
+			}
+		}
+	case "upgrade", "upgrade-external":
+		device.Upgradable = false
+	default:
+		writeError(w, http.StatusBadRequest, "api.err.InvalidCommand")
+		return
+	}
+	writeData(w, []any{})
+}
+
+func (s *Server) clientCommand(w http.ResponseWriter, r *http.Request, site string) {
+	var cmd command
+	if !decodeCommand(w, r, &cmd) {
+		return
+	}
+
+	if cmd.Cmd == "forget-sta" {
+		s.clients[site] = slices.DeleteFunc(s.clients[site], func(c unifi.Client) bool { return slices.Contains(cmd.MACs, c.Mac) })
+		writeData(w, []any{})
+		return
+	}
+
+	i := slices.IndexFunc(s.clients[site], func(c unifi.Client) bool { return c.Mac == cmd.MAC })
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "api.err.UnknownStation")
+		return
+	}
+	client := &s.clients[site][i]
+
+	switch cmd.Cmd {
+	case "block-sta":
+		client.Blocked = true
+		client.Connected = false
+	case "unblock-sta":
+		client.Blocked = false
+	case "kick-sta":
+	case "authorize-guest":
+		client.Authorized = true
+	case "unauthorize-guest":
+		client.Authorized = false
+	default:
+		writeError(w, http.StatusBadRequest, "api.err.InvalidCommand")
+		return
+	}
+	writeData(w, []any{})
+}
+
+func (s *Server) updateClient(w http.ResponseWriter, r *http.Request, site, userID string) {
+	var update struct {
+		Name *string `json:"name"`
+		Note *string `json:"note"`
+	}
+	if !decodeCommand(w, r, &update) {
+		return
+	}
+	i := slices.IndexFunc(s.clients[site], func(c unifi.Client) bool { return c.UserID == userID })
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "api.err.UnknownUser")
+		return
+	}
+	client := &s.clients[site][i]
+	if update.Name != nil {
+		client.Name = *update.Name
+	}
+	if update.Note != nil {
+		client.Note = *update.Note
+	}
+	writeData(w, []unifi.Client{*client})
+}
+
+func (s *Server) hotspotCommand(w http.ResponseWriter, r *http.Request, site string) {
+	var cmd struct {
+		command
+		N      int    `json:"n"`
+		Expire int    `json:"expire"`
+		Quota  int    `json:"quota"`
+		Note   string `json:"note"`
+		Up     int    `json:"up"`
+		Down   int    `json:"down"`
+		Bytes  int    `json:"bytes"`
+	}
+	if !decodeCommand(w, r, &cmd) {
+		return
+	}
+
+	switch cmd.Cmd {
+	case "create-voucher":
+		createTime := time.Now().Unix()
+		for range max(cmd.N, 1) {
+			s.vouchers[site] = append(s.vouchers[site], s.newVoucher(unifi.Voucher{
+				Duration:  cmd.Expire,
+				Quota:     cmd.Quota,
+				Note:      cmd.Note,
+				CreatedAt: createTime,
+				Up:        cmd.Up,
+				Down:      cmd.Down,
+				Bytes:     cmd.Bytes,
+			}))
+		}
+		writeData(w, []map[string]int64{{"create_time": createTime}})
+	case "delete-voucher":
+		s.vouchers[site] = slices.DeleteFunc(s.vouchers[site], func(v unifi.Voucher) bool { return v.ID == cmd.ID })
+		writeData(w, []any{})
+	default:
+		writeError(w, http.StatusBadRequest, "api.err.InvalidCommand")
+	}
+}
+
+func (s *Server) newVoucher(voucher unifi.Voucher) unifi.Voucher {
+	if voucher.ID == "" {
+		voucher.ID = s.newID()
+	}
+	if voucher.Code == "" {
+		voucher.Code = fmt.Sprintf("%010d", s.nextSeq())
+	}
+	if voucher.Status == "" {
+		voucher.Status = unifi.VoucherStatusValidMulti
+		if voucher.Quota == 1 {
+			voucher.Status = unifi.VoucherStatusValidOnce
+		}
+	}
+	return voucher
+}
+
+// listVouchers lists a site's vouchers, optionally only those created by one
+// create-voucher command.
+func (s *Server) listVouchers(w http.ResponseWriter, r *http.Request, site string) {
+	var filter struct {
+		CreateTime int64 `json:"create_time"`
+	}
+	if r.Method == http.MethodPost {
+		_ = json.NewDecoder(r.Body).Decode(&filter)
+	}
+	vouchers := slices.DeleteFunc(slices.Clone(s.vouchers[site]), func(v unifi.Voucher) bool {
+		return filter.CreateTime != 0 && v.CreatedAt != filter.CreateTime
+	})
+	writeData(w, nonNil(vouchers))
+}
+
+// nonNil makes empty listings encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package unifitest
+
+import (
+	"encoding/json"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+)
+
+// AddNetwork adds a network configuration to a site. The ID is generated.
+func (s *Server) AddNetwork(site string, network unifi.Network) unifi.Network {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeObject[unifi.Network](s.addObject(site, "networkconf", network))
+}
+
+// Networks returns the network configurations of a site. The default site
+// starts out with a "Default" LAN, which cannot be deleted.
+func (s *Server) Networks(site string) []unifi.Network {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return objects[unifi.Network](s, site, "networkconf")
+}
+
+// validateNetwork rejects a new network without a name, and networks whose
+// VLAN is already used by another network of the site.
+func (s *Server) validateNetwork(site, id string, fields map[string]json.RawMessage) string {
+	network := decodeObject[unifi.Network](fields)
+	if id == "" && network.Name == "" {
+		return "api.err.NameRequired"
+	}
+	if network.VLAN == 0 {
+		return ""
+	}
+	for _, other := range objects[unifi.Network](s, site, "networkconf") {
+		if other.ID != id && other.VLANEnabled && other.VLAN == network.VLAN {
+			return "api.err.VlanUsed"
+		}
+	}
+	return ""
+}
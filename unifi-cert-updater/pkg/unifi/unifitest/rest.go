@@ -0,0 +1,117 @@
+package unifitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// restValidators check a new (id == "") or updated object of a rest/
+// collection, returning the console's error message if it is rejected.
+var restValidators = map[string]func(s *Server, site, id string, fields map[string]json.RawMessage) string{
+	"networkconf": (*Server).validateNetwork,
+	"wlanconf":    (*Server).validateWLAN,
+}
+
+// serveREST serves a rest/ collection. Updates are merged into the stored
+// object, so settings left out of a PUT keep their values.
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request, site, collection, id string) {
+	key := site + "/" + collection
+	i := slices.IndexFunc(s.rest[key], func(stored map[string]json.RawMessage) bool {
+		return id != "" && objectID(stored) == id
+	})
+	if id != "" && i < 0 && r.Method != http.MethodGet {
+		writeError(w, http.StatusBadRequest, "api.err.IdInvalid")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		writeData(w, nonNil(s.rest[key]))
+	case r.Method == http.MethodGet && i < 0:
+		writeData(w, []any{})
+	case r.Method == http.MethodGet:
+		writeData(w, s.rest[key][i:i+1])
+	case r.Method == http.MethodPost && id == "", r.Method == http.MethodPut:
+		var fields map[string]json.RawMessage
+		if !decodeCommand(w, r, &fields) {
+			return
+		}
+		if msg := restValidators[collection](s, site, id, fields); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if id == "" {
+			stored := s.newObject(s.siteID(site), fields)
+			s.rest[key] = append(s.rest[key], stored)
+			writeData(w, []any{stored})
+			return
+		}
+		stored := s.rest[key][i]
+		for field, value := range fields {
+			if field != "_id" && field != "site_id" {
+				stored[field] = value
+			}
+		}
+		writeData(w, []any{stored})
+	case r.Method == http.MethodDelete:
+		if string(s.rest[key][i]["attr_no_delete"]) == "true" {
+			writeError(w, http.StatusBadRequest, "api.err.NotAllowed")
+			return
+		}
+		s.rest[key] = slices.Delete(s.rest[key], i, i+1)
+		writeData(w, []any{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// addObject stores the JSON fields of v in a rest/ collection with a new ID.
+func (s *Server) addObject(site, collection string, v any) map[string]json.RawMessage {
+	stored := s.newObject(s.siteID(site), v)
+	key := site + "/" + collection
+	s.rest[key] = append(s.rest[key], stored)
+	return stored
+}
+
+// newObject returns the JSON fields of v with a new ID.
+func (s *Server) newObject(siteID string, v any) map[string]json.RawMessage {
+	data, _ := json.Marshal(v)
+	var stored map[string]json.RawMessage
+	_ = json.Unmarshal(data, &stored)
+	stored["_id"], _ = json.Marshal(s.newID())
+	stored["site_id"], _ = json.Marshal(siteID)
+	return stored
+}
+
+// objects decodes the objects of a rest/ collection.
+func objects[T any](s *Server, site, collection string) []T {
+	stored := s.rest[site+"/"+collection]
+	decoded := make([]T, 0, len(stored))
+	for _, fields := range stored {
+		decoded = append(decoded, decodeObject[T](fields))
+	}
+	return decoded
+}
+
+func decodeObject[T any](fields map[string]json.RawMessage) T {
+	data, _ := json.Marshal(fields)
+	var v T
+	_ = json.Unmarshal(data, &v)
+	return v
+}
+
+func objectID(fields map[string]json.RawMessage) string {
+	var id string
+	_ = json.Unmarshal(fields["_id"], &id)
+	return id
+}
+
+func (s *Server) siteID(site string) string {
+	for _, st := range s.sites {
+		if st.Name == site {
+			return st.ID
+		}
+	}
+	return ""
+}
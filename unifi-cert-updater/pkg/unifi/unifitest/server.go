@@ -0,0 +1,459 @@
+// Package unifitest provides an in-memory UniFi console for tests. It models
+// either a UniFi OS console or a legacy Network controller closely enough to
+// run multi-step flows end to end: logins with an optional second factor,
+// logouts and session cookies, CSRF tokens, the certificate store, sites,
+// devices, clients, vouchers, network and WLAN configurations, the event
+// stream and the integration API.
+package unifitest
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"golang.org/x/net/websocket"
+)
+
+// statusMFARequired is the status UniFi OS prompts for a second factor with.
+const statusMFARequired = 499
+
+// Default credentials accepted by a Server.
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "password"
+)
+
+// Server is a fake UniFi console listening on a local address.
+type Server struct {
+	URL string // Base URL of the console, e.g. https://127.0.0.1:41234
+
+	server      *httptest.Server
+	legacy      bool
+	tls         bool
+	username    string
+	password    string
+	totpSecret  string
+	apiKey      string
+	pageSize    int
+	defaultCert tls.Certificate
+
+	mu                 sync.Mutex
+	logins             int
+	nextID             int
+	sessions           map[string]*session // Session cookie value -> session
+	requests           []string
+	bodies             map[string]string  // "METHOD /path" -> body of the last such request
+	failures           map[string]failure // "METHOD /path" -> the error to answer with
+	certificates       []*certificate
+	sites              []unifi.Site
+	devices            map[string][]unifi.Device
+	deviceStates       map[string][]unifi.Device // "site/mac" -> states queued by QueueDeviceStates
+	clients            map[string][]unifi.Client
+	vouchers           map[string][]unifi.Voucher
+	integrationClients map[string][]unifi.IntegrationClient
+	rest               map[string][]map[string]json.RawMessage // "site/collection" -> objects, stored as sent like the console
+
+	// Open event streams wait on eventsCond for new events or to be closed
+	events       map[string][]string // Site -> messages not yet sent on its event stream
+	eventStreams map[*websocket.Conn]struct{}
+	eventsCond   *sync.Cond
+}
+
+// failure is an error the console answers a request with.
+type failure struct {
+	status int
+	msg    string
+}
+
+type session struct {
+	csrf string
+	// The CSRF token was rotated and is announced on the next response
+	announce bool
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLegacyController makes the server behave like a standalone Network
+// controller: logins go to /api/login, the session cookie is unifises, the
+// Network API is served at the root and there is no certificate store.
+func WithLegacyController() Option {
+	return func(s *Server) {
+		s.legacy = true
+	}
+}
+
+// WithCredentials replaces the default username and password.
+func WithCredentials(username, password string) Option {
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// WithTLS serves the console over HTTPS, presenting the active certificate of
+// the certificate store once one has been activated.
+func WithTLS() Option {
+	return func(s *Server) {
+		s.tls = true
+	}
+}
+
+// NewServer starts a console with a single "default" site. Callers should
+// call Close when finished.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		username:           DefaultUsername,
+		password:           DefaultPassword,
+		sessions:           map[string]*session{},
+		failures:           map[string]failure{},
+		bodies:             map[string]string{},
+		devices:            map[string][]unifi.Device{},
+		deviceStates:       map[string][]unifi.Device{},
+		clients:            map[string][]unifi.Client{},
+		vouchers:           map[string][]unifi.Voucher{},
+		pageSize:           defaultPageSize,
+		integrationClients: map[string][]unifi.IntegrationClient{},
+		events:             map[string][]string{},
+		eventStreams:       map[*websocket.Conn]struct{}{},
+		rest:               map[string][]map[string]json.RawMessage{},
+	}
+	s.eventsCond = sync.NewCond(&s.mu)
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.sites = []unifi.Site{{ID: s.newID(), Name: "default", Description: "Default", Role: "admin"}}
+	s.rest["default/networkconf"] = []map[string]json.RawMessage{s.newObject(s.sites[0].ID, map[string]any{
+		"name":            "Default",
+		"purpose":         unifi.NetworkPurposeCorporate,
+		"networkgroup":    "LAN",
+		"enabled":         true,
+		"ip_subnet":       "192.168.1.1/24",
+		"dhcpd_enabled":   true,
+		"dhcpd_start":     "192.168.1.6",
+		"dhcpd_stop":      "192.168.1.254",
+		"dhcpd_leasetime": 86400,
+		"attr_no_delete":  true,
+		"attr_hidden_id":  "LAN",
+	})}
+
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	if s.tls {
+		s.server.TLS = &tls.Config{GetCertificate: s.getCertificate}
+		s.server.StartTLS()
+		// Clients do not send SNI for IP addresses, and crypto/tls only asks
+		// GetCertificate when there is no static certificate or SNI was sent
+		s.defaultCert = s.server.TLS.Certificates[0]
+		s.server.TLS.Certificates = nil
+	} else {
+		s.server.Start()
+	}
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.CloseEventStreams()
+	s.server.Close()
+}
+
+// Client returns an HTTP client for the server. Over TLS it skips
+// verification, since the served certificate changes on activation.
+func (s *Server) Client() *http.Client {
+	if !s.tls {
+		return &http.Client{}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+}
+
+// Logins returns the number of successful logins.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Sessions returns the number of sessions that are logged in.
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Requests returns every request received so far as "METHOD /path", with the
+// query string if there is one.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// RequestBody returns the body of the last request matching "METHOD /path",
+// or "" if there was none.
+func (s *Server) RequestBody(request string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies[request]
+}
+
+// SetCredentials replaces the username and password logins must use, as an
+// admin changing them would. Sessions already logged in stay valid.
+func (s *Server) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username = username
+	s.password = password
+}
+
+// ExpireSessions logs every session out, as a console restart would.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.sessions)
+}
+
+// RotateCSRFTokens gives every session a new CSRF token. The new token is sent
+// in the X-CSRF-Token header of the session's next response; until then
+// writes with the old token are rejected.
+func (s *Server) RotateCSRFTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.csrf = fmt.Sprintf("csrf-%d", s.nextSeq())
+		sess.announce = true
+	}
+}
+
+// FailRequests makes the server answer every later request matching
+// "METHOD /path" with status and msg, e.g. to fail deleting one certificate.
+// Requests are still authenticated first. A zero status serves the request
+// normally again.
+func (s *Server) FailRequests(request string, status int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, request)
+		return
+	}
+	s.failures[request] = failure{status: status, msg: msg}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if site, ok := s.eventsSite(r.URL.Path); ok {
+		s.serveEvents(w, r, site)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	body, _ := io.ReadAll(r.Body)
+	s.bodies[r.Method+" "+r.URL.Path] = string(body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	path := r.URL.Path
+	if !s.legacy && strings.HasPrefix(path, integrationPathPrefix+"/") {
+		// Authenticated by API key rather than a session
+		s.serveIntegration(w, r, strings.TrimPrefix(path, integrationPathPrefix))
+		return
+	}
+	if s.legacy {
+		if path == "/api/login" && r.Method == http.MethodPost {
+			s.handleLogin(w, r)
+			return
+		}
+	} else {
+		if path == unifi.EndpointLogin && r.Method == http.MethodPost {
+			s.handleLogin(w, r)
+			return
+		}
+	}
+
+	sess := s.authenticate(w, r)
+	if sess == nil {
+		return
+	}
+	if f, ok := s.failures[r.Method+" "+path]; ok {
+		if s.legacy || strings.HasPrefix(path, unifi.NetworkPathPrefix) {
+			writeError(w, f.status, f.msg)
+		} else {
+			writeJSON(w, f.status, map[string]string{"error": f.msg})
+		}
+		return
+	}
+
+	switch {
+	case !s.legacy && path == unifi.EndpointSelf && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"username": s.username})
+	case s.legacy && path == unifi.EndpointLegacySelf && r.Method == http.MethodGet:
+		writeData(w, []map[string]any{{"name": s.username}})
+	case (!s.legacy && path == unifi.EndpointAuthLogout || s.legacy && path == unifi.EndpointLogout) && r.Method == http.MethodPost:
+		s.handleLogout(w, sess)
+	case !s.legacy && strings.HasPrefix(path, unifi.NetworkPathPrefix+"/api/"):
+		s.serveNetwork(w, r, strings.TrimPrefix(path, unifi.NetworkPathPrefix))
+	case !s.legacy && strings.HasPrefix(path, unifi.EndpointListCertificates):
+		s.serveCertificates(w, r, strings.TrimPrefix(path, unifi.EndpointListCertificates))
+	case s.legacy && strings.HasPrefix(path, "/api/"):
+		s.serveNetwork(w, r, path)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Username     string  `json:"username"`
+		Password     string  `json:"password"`
+		Token        *string `json:"token"`          // Second factor, UniFi OS
+		Ubic2faToken *string `json:"ubic_2fa_token"` // Second factor, legacy controller
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if credentials.Username != s.username || credentials.Password != s.password {
+		if s.legacy {
+			writeError(w, http.StatusBadRequest, "api.err.Invalid")
+		} else {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"code":    "AUTHENTICATION_FAILED_INVALID_CREDENTIALS",
+				"message": "Invalid username or password",
+			})
+		}
+		return
+	}
+	if s.totpSecret != "" && !s.checkSecondFactor(w, cmp.Or(credentials.Token, credentials.Ubic2faToken)) {
+		return
+	}
+
+	s.logins++
+	id := fmt.Sprintf("session-%d", s.nextSeq())
+	sess := &session{csrf: fmt.Sprintf("csrf-%d", s.nextSeq())}
+	s.sessions[id] = sess
+	http.SetCookie(w, &http.Cookie{Name: s.sessionCookie(), Value: id, Path: "/", HttpOnly: true})
+
+	if s.legacy {
+		writeData(w, []any{})
+		return
+	}
+	w.Header().Set("X-CSRF-Token", sess.csrf)
+	writeJSON(w, http.StatusOK, map[string]any{"unique_id": id, "username": s.username})
+}
+
+// checkSecondFactor answers a login without a valid TOTP code the way the
+// console prompts for or rejects one, and reports whether the code is valid.
+func (s *Server) checkSecondFactor(w http.ResponseWriter, code *string) bool {
+	switch {
+	case code != nil && s.validTOTP(*code):
+		return true
+	case s.legacy && code == nil:
+		writeError(w, http.StatusBadRequest, "api.err.Ubic2faTokenRequired")
+	case s.legacy:
+		writeError(w, http.StatusBadRequest, "api.err.Invalid2faToken")
+	case code == nil:
+		writeJSON(w, statusMFARequired, map[string]any{
+			"code":    "MFA_AUTH_REQUIRED",
+			"message": "MFA authentication required",
+			"data":    map[string]any{"authenticators": []map[string]string{{"id": "1", "type": "totp"}}},
+		})
+	default:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"code": "MFA_AUTH_FAILED", "message": "Invalid MFA token"})
+	}
+	return false
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, sess *session) {
+	maps.DeleteFunc(s.sessions, func(_ string, other *session) bool { return other == sess })
+	http.SetCookie(w, &http.Cookie{Name: s.sessionCookie(), Path: "/", MaxAge: -1})
+	if s.legacy {
+		writeData(w, []any{})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *Server) sessionCookie() string {
+	if s.legacy {
+		return "unifises"
+	}
+	return "TOKEN"
+}
+
+// authenticate returns the request's session, or writes the console's
+// rejection and returns nil.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) *session {
+	var sess *session
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == s.sessionCookie() && s.sessions[cookie.Value] != nil {
+			sess = s.sessions[cookie.Value]
+			break
+		}
+	}
+	if sess == nil {
+		if s.legacy {
+			writeError(w, http.StatusUnauthorized, "api.err.LoginRequired")
+		} else {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": map[string]any{"code": 401, "message": "Unauthorized"}})
+		}
+		return nil
+	}
+
+	if sess.announce {
+		w.Header().Set("X-CSRF-Token", sess.csrf)
+		sess.announce = false
+	}
+	if !s.legacy && r.Method != http.MethodGet && r.Header.Get("X-CSRF-Token") != sess.csrf {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid csrf token"})
+		return nil
+	}
+	return sess
+}
+
+// getCertificate presents the active certificate, if any.
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cert := range s.certificates {
+		if cert.Active {
+			return &cert.keyPair, nil
+		}
+	}
+	return &s.defaultCert, nil
+}
+
+// nextSeq returns a number unique within the server.
+func (s *Server) nextSeq() int {
+	s.nextID++
+	return s.nextID
+}
+
+// newID returns an object ID shaped like the controller's.
+func (s *Server) newID() string {
+	return fmt.Sprintf("%024x", s.nextSeq())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeData writes a successful meta/data envelope.
+func writeData(w http.ResponseWriter, data any) {
+	writeJSON(w, http.StatusOK, map[string]any{"meta": map[string]string{"rc": "ok"}, "data": data})
+}
+
+// writeError writes a failed meta/data envelope.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"meta": map[string]string{"rc": "error", "msg": msg}, "data": []any{}})
+}
@@ -0,0 +1,57 @@
+package unifitest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// totpStep is the RFC 6238 time step authenticator apps default to.
+const totpStep = 30 * time.Second
+
+// WithTOTP requires a second factor at login: a TOTP code for the base32
+// secret, as an authenticator app enrolled with it would show. Like real
+// consoles, codes of the previous and next time step are accepted too, so
+// that clock skew and step boundaries do not fail logins.
+func WithTOTP(secret string) Option {
+	return func(s *Server) {
+		s.totpSecret = secret
+	}
+}
+
+// TOTPCode returns the code currently expected for the secret set with
+// WithTOTP.
+func (s *Server) TOTPCode() string {
+	return totpCode(s.totpSecret, time.Now())
+}
+
+// validTOTP reports whether code is the TOTP code of the current, previous or
+// next time step.
+func (s *Server) validTOTP(code string) bool {
+	now := time.Now()
+	for _, t := range []time.Time{now.Add(-totpStep), now, now.Add(totpStep)} {
+		if code == totpCode(s.totpSecret, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes a 6 digit HMAC-SHA1 TOTP code, independently of the
+// client's implementation.
+func totpCode(secret string, t time.Time) string {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpStep/time.Second)))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1_000_000)
+}
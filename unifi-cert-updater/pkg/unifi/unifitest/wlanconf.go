@@ -0,0 +1,42 @@
+package unifitest
+
+import (
+	"encoding/json"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+)
+
+// AddWLAN adds a WLAN configuration to a site. The ID is generated.
+func (s *Server) AddWLAN(site string, wlan unifi.WLAN) unifi.WLAN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeObject[unifi.WLAN](s.addObject(site, "wlanconf", wlan))
+}
+
+// WLANs returns the WLAN configurations of a site.
+func (s *Server) WLANs(site string) []unifi.WLAN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return objects[unifi.WLAN](s, site, "wlanconf")
+}
+
+// validateWLAN rejects a new WLAN without an SSID, and passphrases WPA does
+// not allow on WPA-PSK WLANs.
+func (s *Server) validateWLAN(site, id string, fields map[string]json.RawMessage) string {
+	wlan := decodeObject[unifi.WLAN](fields)
+	if id == "" && wlan.Name == "" {
+		return "api.err.NameRequired"
+	}
+	if _, ok := fields["security"]; !ok && id != "" {
+		for _, other := range objects[unifi.WLAN](s, site, "wlanconf") {
+			if other.ID == id {
+				wlan.Security = other.Security
+			}
+		}
+	}
+	_, ok := fields["x_passphrase"]
+	if ok && wlan.Security == unifi.WLANSecurityWPAPSK && (len(wlan.Passphrase) < 8 || len(wlan.Passphrase) > 63) {
+		return "api.err.InvalidPassphrase"
+	}
+	return ""
+}
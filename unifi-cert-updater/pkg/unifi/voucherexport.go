@@ -0,0 +1,123 @@
+package unifi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteVouchersCSV writes vouchers as CSV with a header row, one voucher per
+// row.
+func WriteVouchersCSV(w io.Writer, vouchers []Voucher) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"code", "duration_minutes", "quota", "used", "status", "note", "created"})
+	for _, v := range vouchers {
+		_ = cw.Write([]string{
+			v.FormattedCode(),
+			strconv.Itoa(v.Duration),
+			strconv.Itoa(v.Quota),
+			strconv.Itoa(v.Used),
+			v.Status,
+			v.Note,
+			v.Created().UTC().Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// VoucherCardOptions customises the printable voucher cards.
+type VoucherCardOptions struct {
+	Title string // Heading on every card, defaults to "Guest Wi-Fi"
+	SSID  string // Network to join, left off the cards if empty
+}
+
+// WriteVoucherCards writes vouchers as an HTML page of cards, sized to be
+// printed on A4 or Letter and cut apart.
+func WriteVoucherCards(w io.Writer, vouchers []Voucher, opts VoucherCardOptions) error {
+	if opts.Title == "" {
+		opts.Title = "Guest Wi-Fi"
+	}
+	return voucherCardsTemplate.Execute(w, struct {
+		VoucherCardOptions
+		Vouchers []Voucher
+	}{opts, vouchers})
+}
+
+var voucherCardsTemplate = template.Must(template.New("cards").Funcs(template.FuncMap{
+	"duration": formatVoucherDuration,
+	"uses":     formatVoucherQuota,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+.sheet { display: grid; grid-template-columns: repeat(3, 1fr); gap: 4mm; padding: 8mm; }
+.card { border: 1px dashed #888; padding: 4mm; text-align: center; page-break-inside: avoid; break-inside: avoid; }
+.card h1 { font-size: 12pt; margin: 0 0 2mm; }
+.code { font-family: monospace; font-size: 18pt; letter-spacing: 1pt; margin: 2mm 0; }
+.details { font-size: 9pt; color: #444; }
+@media print { .sheet { padding: 0; } }
+</style>
+</head>
+<body>
+<div class="sheet">
+{{- range .Vouchers}}
+<div class="card">
+<h1>{{$.Title}}</h1>
+{{- if $.SSID}}
+<div class="details">Network: {{$.SSID}}</div>
+{{- end}}
+<div class="code">{{.FormattedCode}}</div>
+<div class="details">Valid for {{duration .Duration}}, {{uses .Quota}}</div>
+{{- if .Note}}
+<div class="details">{{.Note}}</div>
+{{- end}}
+</div>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+// formatVoucherDuration spells out a duration in minutes, e.g. "1 day 6 hours".
+func formatVoucherDuration(minutes int) string {
+	units := []struct {
+		name    string
+		minutes int
+	}{{"day", 24 * 60}, {"hour", 60}, {"minute", 1}}
+
+	var parts []string
+	for _, unit := range units {
+		n := minutes / unit.minutes
+		minutes %= unit.minutes
+		switch {
+		case n == 1:
+			parts = append(parts, "1 "+unit.name)
+		case n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit.name))
+		}
+	}
+	if len(parts) == 0 {
+		return "0 minutes"
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatVoucherQuota describes how often a voucher can be redeemed.
+func formatVoucherQuota(quota int) string {
+	switch quota {
+	case 0:
+		return "unlimited uses"
+	case 1:
+		return "single use"
+	default:
+		return fmt.Sprintf("up to %d uses", quota)
+	}
+}
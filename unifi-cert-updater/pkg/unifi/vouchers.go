@@ -1,9 +1,134 @@
 package unifi
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
 
-func (c *UniFiClient) CreateVoucher(site string, payload VoucherCreatePayload) error {
-	endpoint := fmt.Sprintf("/api/s/%s/cmd/hotspot", site)
+// CreateVoucher creates payload.Count vouchers and returns them, codes
+// included. The vouchers of one call share a creation time, which is how
+// they are found again. Vouchers another admin created in the same second are
+// told apart by their settings; if they cannot be, an error is returned
+// rather than codes that may not be ours.
+func (c *UniFiClient) CreateVoucher(ctx context.Context, site string, payload VoucherCreatePayload) ([]Voucher, error) {
+	endpoint := c.networkEndpoint(EndpointCreateVoucher, site)
 	payload.Cmd = "create-voucher" // Mandatory command
-	return c.doRequest("POST", endpoint, payload, nil)
+	payload.Count = max(payload.Count, 1)
+
+	var created []struct {
+		CreateTime int64 `json:"create_time"`
+	}
+	if err := c.doRequest(ctx, "POST", endpoint, payload, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 || created[0].CreateTime == 0 {
+		return nil, fmt.Errorf("create-voucher response has no create_time")
+	}
+
+	vouchers, err := c.listVouchers(ctx, site, created[0].CreateTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list created vouchers: %w", err)
+	}
+	vouchers = slices.DeleteFunc(vouchers, func(v Voucher) bool {
+		return v.Duration != payload.Minutes || v.Quota != payload.Quota || v.Note != payload.Note ||
+			v.Up != payload.UpLimit || v.Down != payload.DownLimit || v.Bytes != payload.ByteLimit
+	})
+	if len(vouchers) != payload.Count {
+		return nil, fmt.Errorf("found %d vouchers created at %d with the requested settings, expected %d", len(vouchers), created[0].CreateTime, payload.Count)
+	}
+	return vouchers, nil
+}
+
+// ListVouchers lists a site's vouchers with their usage status. Vouchers that
+// are used up or expired are no longer listed.
+func (c *UniFiClient) ListVouchers(ctx context.Context, site string) ([]Voucher, error) {
+	return c.listVouchers(ctx, site, 0)
+}
+
+// listVouchers lists the vouchers created at createTime, or all of them if it
+// is zero.
+func (c *UniFiClient) listVouchers(ctx context.Context, site string, createTime int64) ([]Voucher, error) {
+	endpoint := c.networkEndpoint(EndpointListVouchers, site)
+	var vouchers []Voucher
+	var err error
+	if createTime == 0 {
+		err = c.doRequest(ctx, "GET", endpoint, nil, &vouchers)
+	} else {
+		err = c.doRequest(ctx, "POST", endpoint, map[string]int64{"create_time": createTime}, &vouchers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// RevokeVoucher deletes a voucher by ID. Guests already authorized with it
+// keep their access until it runs out.
+func (c *UniFiClient) RevokeVoucher(ctx context.Context, site, id string) error {
+	endpoint := c.networkEndpoint(EndpointDeleteVoucher, site)
+	payload := map[string]interface{}{
+		"cmd": "delete-voucher",
+		"_id": id,
+	}
+	return c.doRequest(ctx, "POST", endpoint, payload, nil)
+}
+
+// VoucherFilter selects vouchers by note and creation time. Every set field
+// must match.
+type VoucherFilter struct {
+	Note          string    // Exact note
+	CreatedBefore time.Time // Created strictly before this time
+}
+
+// Matches reports whether v is selected by the filter.
+func (f VoucherFilter) Matches(v Voucher) bool {
+	if f.Note != "" && v.Note != f.Note {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !v.Created().Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// ExpireVouchers revokes every voucher selected by filter and returns them.
+// An empty filter is rejected rather than revoking every voucher. If a
+// revocation fails, the vouchers revoked so far are returned with the error.
+func (c *UniFiClient) ExpireVouchers(ctx context.Context, site string, filter VoucherFilter) ([]Voucher, error) {
+	if filter == (VoucherFilter{}) {
+		return nil, errors.New("voucher filter needs a note or a creation time")
+	}
+	vouchers, err := c.ListVouchers(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Voucher
+	for _, v := range vouchers {
+		if !filter.Matches(v) {
+			continue
+		}
+		if err := c.RevokeVoucher(ctx, site, v.ID); err != nil {
+			return expired, fmt.Errorf("failed to revoke voucher %s: %w", v.FormattedCode(), err)
+		}
+		expired = append(expired, v)
+	}
+	return expired, nil
+}
+
+// Created returns the voucher's creation time.
+func (v Voucher) Created() time.Time {
+	return time.Unix(v.CreatedAt, 0)
+}
+
+// FormattedCode returns the code the way the console prints it, as two groups
+// of five digits.
+func (v Voucher) FormattedCode() string {
+	if len(v.Code) != 10 {
+		return v.Code
+	}
+	return v.Code[:5] + "-" + v.Code[5:]
 }
@@ -0,0 +1,110 @@
+package unifi_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testVouchers = []unifi.Voucher{
+	{ID: "1", Code: "1234567890", Duration: 1440, Quota: 1, Note: "lobby", CreatedAt: 1714564800, Status: unifi.VoucherStatusValidOnce},
+	{ID: "2", Code: "0987654321", Duration: 90, Quota: 0, Used: 2, Note: `Bob's "party" <b>`, CreatedAt: 1714564800, Status: unifi.VoucherStatusUsedMultiple},
+}
+
+func TestWriteVouchersCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, unifi.WriteVouchersCSV(&buf, testVouchers))
+	assert.Equal(t, "code,duration_minutes,quota,used,status,note,created\n"+
+		"12345-67890,1440,1,0,VALID_ONE,lobby,2024-05-01T12:00:00Z\n"+
+		`09876-54321,90,0,2,USED_MULTIPLE,"Bob's ""party"" <b>",2024-05-01T12:00:00Z`+"\n", buf.String())
+}
+
+func TestWriteVoucherCards(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, unifi.WriteVoucherCards(&buf, testVouchers, unifi.VoucherCardOptions{SSID: "Guests"}))
+	html := buf.String()
+
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte(`<div class="card">`)))
+	assert.Contains(t, html, "<h1>Guest Wi-Fi</h1>")
+	assert.Contains(t, html, "Network: Guests")
+	assert.Contains(t, html, `<div class="code">12345-67890</div>`)
+	assert.Contains(t, html, "Valid for 1 day, single use")
+	assert.Contains(t, html, "Valid for 1 hour 30 minutes, unlimited uses")
+	assert.Contains(t, html, "Bob&#39;s &#34;party&#34; &lt;b&gt;", "notes must be escaped")
+}
+
+func TestFormatVoucherDuration(t *testing.T) {
+	for minutes, want := range map[int]string{
+		0:     "0 minutes",
+		1:     "1 minute",
+		45:    "45 minutes",
+		60:    "1 hour",
+		480:   "8 hours",
+		1500:  "1 day 1 hour",
+		10080: "7 days",
+	} {
+		assert.Equal(t, want, unifi.FormatVoucherDuration(minutes), "%d minutes", minutes)
+	}
+	assert.Equal(t, "up to 5 uses", unifi.FormatVoucherQuota(5))
+}
+
+func TestVoucherLifecycle(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+	lastWeek := time.Now().AddDate(0, 0, -7)
+	old := server.AddVoucher("default", unifi.Voucher{Duration: 60, Quota: 1, Note: "conference", CreatedAt: lastWeek.Unix()})
+	server.AddVoucher("default", unifi.Voucher{Duration: 60, Quota: 1, Note: "lobby", CreatedAt: lastWeek.Unix()})
+
+	// Creating a batch returns only that batch, codes included
+	batch, err := client.CreateVoucher(ctx, "default", unifi.VoucherCreatePayload{Count: 3, Minutes: 480, Quota: 0, Note: "lobby", DownLimit: 10000})
+	require.NoError(t, err)
+	require.Len(t, batch, 3)
+	for _, v := range batch {
+		assert.Len(t, v.Code, 10)
+		assert.Equal(t, 480, v.Duration)
+		assert.Equal(t, 10000, v.Down)
+		assert.Equal(t, unifi.VoucherStatusValidMulti, v.Status)
+		assert.Equal(t, batch[0].CreatedAt, v.CreatedAt)
+	}
+
+	vouchers, err := client.ListVouchers(ctx, "default")
+	require.NoError(t, err)
+	assert.Len(t, vouchers, 5)
+
+	require.NoError(t, client.RevokeVoucher(ctx, "default", batch[0].ID))
+	assert.Len(t, server.Vouchers("default"), 4)
+
+	// Only last week's lobby voucher is both a lobby voucher and old
+	expired, err := client.ExpireVouchers(ctx, "default", unifi.VoucherFilter{Note: "lobby", CreatedBefore: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "lobby", expired[0].Note)
+
+	expired, err = client.ExpireVouchers(ctx, "default", unifi.VoucherFilter{CreatedBefore: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, []unifi.Voucher{old}, expired)
+	assert.Equal(t, batch[1:], server.Vouchers("default"))
+
+	_, err = client.ExpireVouchers(ctx, "default", unifi.VoucherFilter{})
+	assert.EqualError(t, err, "voucher filter needs a note or a creation time")
+}
+
+func TestCreateVoucherIgnoresOtherBatches(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+
+	// Created by another admin, most likely in the same second
+	other := server.AddVoucher("default", unifi.Voucher{Duration: 60, Quota: 1, Note: "front desk", CreatedAt: time.Now().Unix()})
+	batch, err := client.CreateVoucher(ctx, "default", unifi.VoucherCreatePayload{Count: 2, Minutes: 480, Quota: 1, Note: "lobby"})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.NotContains(t, batch, other)
+	for _, v := range batch {
+		assert.Equal(t, "lobby", v.Note)
+	}
+}
@@ -0,0 +1,119 @@
+package unifi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ListWLANs returns the WLAN configurations of a site, including the
+// passphrases of WPA-PSK networks.
+func (c *UniFiClient) ListWLANs(ctx context.Context, site string) ([]WLAN, error) {
+	endpoint := c.networkEndpoint(EndpointListWLANs, site)
+	var wlans []WLAN
+	err := c.doRequest(ctx, "GET", endpoint, nil, &wlans)
+	if err != nil {
+		return nil, err
+	}
+	return wlans, nil
+}
+
+// UpdateWLAN replaces the settings of the WLAN with wlan.ID and returns it as
+// stored.
+func (c *UniFiClient) UpdateWLAN(ctx context.Context, site string, wlan WLAN) (WLAN, error) {
+	if wlan.ID == "" {
+		return WLAN{}, fmt.Errorf("WLAN %s has no ID", wlan.Name)
+	}
+	endpoint := c.networkEndpoint(EndpointUpdateWLAN, site, wlan.ID)
+	var wlans []WLAN
+	if err := c.doRequest(ctx, "PUT", endpoint, wlan, &wlans); err != nil {
+		return WLAN{}, err
+	}
+	if len(wlans) == 0 {
+		return WLAN{}, fmt.Errorf("WLAN %s: console returned no WLAN", wlan.Name)
+	}
+	return wlans[0], nil
+}
+
+// SetWLANPassphrase changes the WPA passphrase of a WLAN. Connected clients
+// are disconnected and must join again with the new passphrase.
+func (c *UniFiClient) SetWLANPassphrase(ctx context.Context, site, id, passphrase string) error {
+	if err := validatePassphrase(passphrase); err != nil {
+		return err
+	}
+	return c.updateWLANFields(ctx, site, id, map[string]interface{}{"x_passphrase": passphrase})
+}
+
+// SetWLANEnabled turns broadcasting of a WLAN on or off.
+func (c *UniFiClient) SetWLANEnabled(ctx context.Context, site, id string, enabled bool) error {
+	return c.updateWLANFields(ctx, site, id, map[string]interface{}{"enabled": enabled})
+}
+
+// SetWLANSchedule limits a WLAN to the given weekly windows. An empty schedule
+// broadcasts it around the clock.
+func (c *UniFiClient) SetWLANSchedule(ctx context.Context, site, id string, schedule []WLANSchedule) error {
+	if schedule == nil {
+		schedule = []WLANSchedule{}
+	}
+	return c.updateWLANFields(ctx, site, id, map[string]interface{}{
+		"schedule_enabled":       len(schedule) > 0,
+		"schedule_with_duration": schedule,
+	})
+}
+
+// updateWLANFields changes only the given settings of a WLAN.
+func (c *UniFiClient) updateWLANFields(ctx context.Context, site, id string, fields map[string]interface{}) error {
+	endpoint := c.networkEndpoint(EndpointUpdateWLAN, site, id)
+	return c.doRequest(ctx, "PUT", endpoint, fields, nil)
+}
+
+// validatePassphrase applies the WPA rule: 8 to 63 printable ASCII characters.
+func validatePassphrase(passphrase string) error {
+	if len(passphrase) < 8 || len(passphrase) > 63 {
+		return fmt.Errorf("WPA passphrase must be 8 to 63 characters, got %d", len(passphrase))
+	}
+	for _, r := range passphrase {
+		if r < ' ' || r > '~' {
+			return fmt.Errorf("WPA passphrase must only contain printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+// WiFiQRPayload returns the text of a QR code that joins a WLAN when scanned,
+// in the WIFI: format phone cameras understand. An empty passphrase describes
+// an open network.
+func WiFiQRPayload(ssid, passphrase string, hidden bool) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+	var b strings.Builder
+	if passphrase == "" {
+		b.WriteString("WIFI:T:nopass;S:" + escape.Replace(ssid) + ";")
+	} else {
+		b.WriteString("WIFI:T:WPA;S:" + escape.Replace(ssid) + ";P:" + escape.Replace(passphrase) + ";")
+	}
+	if hidden {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String()
+}
+
+func (w WLAN) MarshalJSON() ([]byte, error) {
+	type plain WLAN
+	if w.Schedule == nil {
+		w.Schedule = []WLANSchedule{} // Like the console, an empty list rather than null
+	}
+	return marshalWithExtra(plain(w), w.Extra)
+}
+
+func (w *WLAN) UnmarshalJSON(data []byte) error {
+	type plain WLAN
+	if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+		return err
+	}
+	extra, err := unmarshalExtra(data, reflect.TypeFor[WLAN]())
+	w.Extra = extra
+	return err
+}
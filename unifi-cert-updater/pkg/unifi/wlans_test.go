@@ -0,0 +1,74 @@
+package unifi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWiFiQRPayload(t *testing.T) {
+	assert.Equal(t, "WIFI:T:WPA;S:Guests;P:correct-horse;;", unifi.WiFiQRPayload("Guests", "correct-horse", false))
+	assert.Equal(t, `WIFI:T:WPA;S:Caf\;e\:\\2;P:a\,b\"c;H:true;;`, unifi.WiFiQRPayload(`Caf;e:\2`, `a,b"c`, true))
+	assert.Equal(t, "WIFI:T:nopass;S:Lobby;;", unifi.WiFiQRPayload("Lobby", "", false))
+}
+
+func TestValidatePassphrase(t *testing.T) {
+	assert.NoError(t, unifi.ValidatePassphrase("12345678"))
+	assert.NoError(t, unifi.ValidatePassphrase(strings.Repeat("a", 63)))
+	assert.EqualError(t, unifi.ValidatePassphrase("1234567"), "WPA passphrase must be 8 to 63 characters, got 7")
+	assert.EqualError(t, unifi.ValidatePassphrase(strings.Repeat("a", 64)), "WPA passphrase must be 8 to 63 characters, got 64")
+	assert.EqualError(t, unifi.ValidatePassphrase("pässwörd"), "WPA passphrase must only contain printable ASCII characters")
+}
+
+func TestWLANConfiguration(t *testing.T) {
+	server, client := newConsoleClient(t)
+	ctx := context.Background()
+	guests := server.AddWLAN("default", unifi.WLAN{Name: "Guests", Enabled: true, Security: unifi.WLANSecurityWPAPSK, Passphrase: "old-passphrase", IsGuest: true})
+	server.AddWLAN("default", unifi.WLAN{Name: "Office", Enabled: true, Security: unifi.WLANSecurityWPAEAP})
+
+	wlans, err := client.ListWLANs(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, wlans, 2)
+	assert.Equal(t, guests, wlans[0])
+
+	require.NoError(t, client.SetWLANPassphrase(ctx, "default", guests.ID, "new-passphrase"))
+	assert.ErrorContains(t, client.SetWLANPassphrase(ctx, "default", guests.ID, "short"), "8 to 63 characters")
+	require.NoError(t, client.SetWLANEnabled(ctx, "default", guests.ID, false))
+	schedule := []unifi.WLANSchedule{{StartDaysOfWeek: []string{"mon", "tue", "wed", "thu", "fri"}, StartHour: 8, DurationMinutes: 600}}
+	require.NoError(t, client.SetWLANSchedule(ctx, "default", guests.ID, schedule))
+
+	got := server.WLANs("default")[0]
+	assert.Equal(t, "new-passphrase", got.Passphrase)
+	assert.False(t, got.Enabled)
+	assert.True(t, got.ScheduleEnabled)
+	assert.Equal(t, schedule, got.Schedule)
+	assert.True(t, got.IsGuest, "settings not being changed must be kept")
+
+	require.NoError(t, client.SetWLANSchedule(ctx, "default", guests.ID, nil))
+	got = server.WLANs("default")[0]
+	assert.False(t, got.ScheduleEnabled)
+	assert.Empty(t, got.Schedule)
+
+	got.Name = "Visitors"
+	updated, err := client.UpdateWLAN(ctx, "default", got)
+	require.NoError(t, err)
+	assert.Equal(t, "Visitors", updated.Name)
+
+	// Cleared settings are sent, not left to the console to keep
+	lobby := server.AddWLAN("default", unifi.WLAN{Name: "Lobby", Enabled: true, Security: unifi.WLANSecurityWPAPSK, Passphrase: "lobby-passphrase", ScheduleEnabled: true, Schedule: schedule})
+	lobby.Security = unifi.WLANSecurityOpen
+	lobby.Passphrase = ""
+	lobby.ScheduleEnabled = false
+	lobby.Schedule = nil
+	_, err = client.UpdateWLAN(ctx, "default", lobby)
+	require.NoError(t, err)
+	got = server.WLANs("default")[2]
+	assert.Equal(t, unifi.WLANSecurityOpen, got.Security)
+	assert.Empty(t, got.Passphrase)
+	assert.False(t, got.ScheduleEnabled)
+	assert.Empty(t, got.Schedule)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newCertificateID stands in for the ID of a certificate that has not been
+// uploaded yet.
+const newCertificateID = "(new)"
+
+// Plan describes what a sync would change on a console without changing it.
+type Plan struct {
+	Console     string            `json:"console"`
+	URL         string            `json:"url"`
+	Fingerprint string            `json:"fingerprint,omitempty"` // Empty if the certificate would be issued first
+	Issue       string            `json:"issue,omitempty"`
+	Upload      bool              `json:"upload"`
+	ActivateID  string            `json:"activate_id,omitempty"` // Empty if the certificate is already active
+	ActiveID    string            `json:"active_id,omitempty"`
+	Delete      []PlannedDeletion `json:"delete"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// plan logs in, reads the console's certificates and works out what a sync
+// would do. Only read calls are made against the console.
+func (c *console) plan(ctx context.Context, k8sClient client.Client) Plan {
+	plan := Plan{Console: c.config.Name, URL: c.config.URL, Delete: []PlannedDeletion{}}
+
+	if err := c.login(ctx, k8sClient); err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+
+	var cert, key string
+	var err error
+	if source, ok := c.source.(dryRunSource); ok {
+		cert, key, plan.Issue, err = source.FetchDryRun(ctx, k8sClient)
+	} else {
+		cert, key, err = c.source.Fetch(ctx, k8sClient)
+	}
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+
+	// A certificate that is yet to be issued is assumed to be valid from now
+	newCert := unifi.Certificate{ID: newCertificateID, ValidFrom: time.Now(), Active: true}
+	if cert != "" {
+		if err := c.validate(cert, key); err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+
+		plan.Fingerprint, err = calculateFingerprint(cert)
+		if err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+		chain, err := parseCertificateChain(cert)
+		if err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+		newCert.ValidFrom, newCert.ValidTo = chain[0].NotBefore, chain[0].NotAfter
+	}
+
+	existingCerts, err := c.client.ListCertificates(ctx)
+	if err != nil {
+		plan.Error = fmt.Sprintf("failed to list certificates: %v", err)
+		return plan
+	}
+
+	// Work out the certificate list as it would look after upload and activation
+	targetID := newCertificateID
+	for _, existing := range existingCerts {
+		if plan.Fingerprint != "" && existing.Fingerprint == plan.Fingerprint {
+			targetID = existing.ID
+		}
+		if existing.Active {
+			plan.ActiveID = existing.ID
+		}
+	}
+	plan.Upload = targetID == newCertificateID
+
+	afterSync := make([]unifi.Certificate, 0, len(existingCerts)+1)
+	for _, existing := range existingCerts {
+		existing.Active = existing.ID == targetID
+		afterSync = append(afterSync, existing)
+	}
+	if plan.Upload {
+		afterSync = append(afterSync, newCert)
+	}
+
+	if plan.ActiveID != targetID {
+		plan.ActivateID = targetID
+	}
+	deletions, err := planCertificateDeletions(afterSync, c.config.retentionPolicy(), time.Now())
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	plan.Delete = append(plan.Delete, deletions...)
+	return plan
+}
+
+// writePlansText prints plans in a human-readable form.
+func writePlansText(w io.Writer, plans []Plan) {
+	for _, plan := range plans {
+		fmt.Fprintf(w, "Console %s (%s)\n", plan.Console, plan.URL)
+		if plan.Error != "" {
+			fmt.Fprintf(w, "  ! cannot plan: %s\n\n", plan.Error)
+			continue
+		}
+
+		if plan.Issue != "" {
+			fmt.Fprintf(w, "  * %s\n", plan.Issue)
+		}
+		if plan.Fingerprint != "" {
+			fmt.Fprintf(w, "  Certificate fingerprint: %s\n", plan.Fingerprint)
+		}
+		if plan.Upload {
+			fmt.Fprintln(w, "  + upload new certificate")
+		} else {
+			fmt.Fprintln(w, "  = certificate already uploaded")
+		}
+		if plan.ActivateID != "" {
+			fmt.Fprintf(w, "  ~ activate certificate %s (currently active: %s)\n", plan.ActivateID, displayID(plan.ActiveID))
+		} else {
+			fmt.Fprintf(w, "  = certificate %s already active\n", plan.ActiveID)
+		}
+		for _, deletion := range plan.Delete {
+			fmt.Fprintf(w, "  - delete certificate %s (%s) [%s]: %s\n", deletion.ID, deletion.Name, deletion.Rule, deletion.Reason)
+		}
+		if len(plan.Delete) == 0 {
+			fmt.Fprintln(w, "  = nothing to delete")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writePlansJSON prints plans as a JSON array.
+func writePlansJSON(w io.Writer, plans []Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plans)
+}
+
+func displayID(id string) string {
+	if id == "" {
+		return "none"
+	}
+	return id
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConsolePlan(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "Test CA", nil, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	existing := []unifi.Certificate{
+		{ID: "old", Name: "old", ValidFrom: now.Add(-72 * time.Hour), Active: true},
+		{ID: "older", Name: "older", ValidFrom: now.Add(-96 * time.Hour)},
+	}
+
+	var writes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/auth/login":
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/userCertificates":
+			_ = json.NewEncoder(w).Encode(existing)
+		default:
+			writes++
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data: map[string][]byte{
+			"tls.crt": []byte(leaf.certPEM + ca.certPEM),
+			"tls.key": []byte(leaf.keyPEM),
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+	unifiClient, err := unifi.NewClient(server.URL, "admin", "secret", server.Client())
+	require.NoError(t, err)
+	c := &console{
+		config: ConsoleConfig{
+			Name:      "default",
+			URL:       server.URL,
+			TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"},
+			MaxCerts:  2,
+		},
+		client: unifiClient,
+		source: &secretSource{ref: SecretReference{Namespace: "certs", Name: "unifi-tls"}, logger: logrus.New()},
+		logger: logrus.NewEntry(logrus.New()),
+	}
+
+	plan := c.plan(context.Background(), k8sClient)
+	assert.Empty(t, plan.Error)
+	assert.Zero(t, writes, "planning must not make write calls")
+	assert.Equal(t, fingerprintCertificate(leaf.cert), plan.Fingerprint)
+	assert.True(t, plan.Upload)
+	assert.Equal(t, newCertificateID, plan.ActivateID)
+	assert.Equal(t, "old", plan.ActiveID)
+	require.Len(t, plan.Delete, 1)
+	assert.Equal(t, "older", plan.Delete[0].ID)
+
+	var text bytes.Buffer
+	writePlansText(&text, []Plan{plan})
+	assert.Contains(t, text.String(), "  + upload new certificate\n")
+	assert.Contains(t, text.String(), "  ~ activate certificate (new) (currently active: old)\n")
+	assert.Contains(t, text.String(), "  - delete certificate older (older) [max-certs]: exceeds limit of 2 certificates")
+
+	var out bytes.Buffer
+	require.NoError(t, writePlansJSON(&out, []Plan{plan}))
+	var decoded []Plan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, []Plan{plan}, decoded)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ConsoleReconciler keeps the certificate of each configured console in sync
+// with its TLS secret. Requests are keyed by console name, so every console is
+// retried and backed off independently of the others.
+type ConsoleReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	Consoles map[string]*console
+	Config   Config
+	Logger   *logrus.Logger
+}
+
+// Reconcile pushes the console's certificate. It always requeues after the
+// resync interval so that changes made directly on the console are corrected.
+func (r *ConsoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	c, ok := r.Consoles[req.Name]
+	if !ok {
+		r.Logger.Warnf("Ignoring request for unknown console %s", req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	c.logger.Info("Reconciling console certificate.")
+	if err := c.reconcile(ctx, r.Client, r.Recorder); err != nil {
+		c.logger.WithError(err).Error("Certificate sync failed.")
+		return ctrl.Result{}, err
+	}
+
+	c.logger.Infof("Certificate in sync, next resync in %s.", r.Config.ResyncInterval)
+	return ctrl.Result{RequeueAfter: r.Config.ResyncInterval}, nil
+}
+
+// SetupWithManager watches the TLS secrets of all consoles and maps each
+// secret to the consoles that use it. Updates that only touch the status
+// annotations are filtered out by secretDataChangedPredicate. cert-manager
+// Certificates and files on disk are watched for consoles that use them.
+func (r *ConsoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isTLSSecret := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return len(r.consolesForSecret(context.Background(), obj)) > 0
+	})
+
+	// Changes reported by watchable sources are fed in as generic events
+	// named after the console
+	sourceEvents := make(chan event.GenericEvent)
+	consoles := make([]*console, 0, len(r.Consoles))
+	usesCertManager := false
+	for _, c := range r.Consoles {
+		consoles = append(consoles, c)
+		usesCertManager = usesCertManager || c.config.Source.CertManager != nil
+	}
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		watchSources(ctx, consoles, func(c *console) {
+			select {
+			case sourceEvents <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: c.config.Name}}}:
+			case <-ctx.Done():
+			}
+		})
+		return nil
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to add source watcher: %w", err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("unifi-cert-updater").
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.consolesForSecret),
+			builder.WithPredicates(isTLSSecret, secretDataChangedPredicate())).
+		WatchesRawSource(source.Channel(sourceEvents, &handler.EnqueueRequestForObject{}))
+	if usesCertManager {
+		b = b.Watches(newCertificateObject(), handler.EnqueueRequestsFromMapFunc(r.consolesForCertificate))
+	}
+	return b.WithOptions(controller.Options{MaxConcurrentReconciles: len(r.Consoles)}).
+		Complete(r)
+}
+
+func (r *ConsoleReconciler) consolesForSecret(_ context.Context, obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for name, c := range r.Consoles {
+		if c.config.TLSSecret.Namespace == obj.GetNamespace() && c.config.TLSSecret.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		}
+	}
+	return requests
+}
+
+func (r *ConsoleReconciler) consolesForCertificate(_ context.Context, obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for name, c := range r.Consoles {
+		if cm := c.config.Source.CertManager; cm != nil && cm.Namespace == obj.GetNamespace() && cm.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		}
+	}
+	return requests
+}
+
+// secretDataChangedPredicate triggers on creation of the secret and on updates
+// that change its data. Metadata-only updates and deletions are ignored.
+func secretDataChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return true },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			newSecret, ok := e.ObjectNew.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			return !maps.EqualFunc(oldSecret.Data, newSecret.Data, bytes.Equal)
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// runDaemon starts a controller-runtime manager that watches the TLS secrets
+// of all consoles until the context is cancelled.
+func runDaemon(ctx context.Context, config Config, scheme *runtime.Scheme, consoles []*console) error {
+	ctrl.SetLogger(logr.FromSlogHandler(slog.NewTextHandler(os.Stderr, nil)))
+
+	byName := map[string]*console{}
+	for _, c := range consoles {
+		byName[c.config.Name] = c
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:  scheme,
+		Cache:   cache.Options{DefaultNamespaces: cachedNamespaces(consoles)},
+		Metrics: metricsserver.Options{BindAddress: config.MetricsAddress},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+
+	reconciler := &ConsoleReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("unifi-cert-updater"),
+		Consoles: byName,
+		Config:   config,
+		Logger:   logger,
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("failed to set up reconciler: %w", err)
+	}
+
+	logger.Infof("Watching certificate sources for %d consoles, resyncing every %s.", len(consoles), config.ResyncInterval)
+	return mgr.Start(ctx)
+}
+
+// cachedNamespaces returns the namespaces of every secret and Certificate the
+// consoles read or write. The manager's client only sees objects in these
+// namespaces.
+func cachedNamespaces(consoles []*console) map[string]cache.Config {
+	namespaces := map[string]cache.Config{}
+	for _, c := range consoles {
+		if c.config.TLSSecret.Name != "" {
+			namespaces[c.config.TLSSecret.Namespace] = cache.Config{}
+		}
+		if cm := c.config.Source.CertManager; cm != nil {
+			namespaces[cm.Namespace] = cache.Config{}
+		}
+		if p12 := c.config.Source.PKCS12; p12 != nil && p12.PasswordSecret != nil {
+			namespaces[p12.PasswordSecret.Namespace] = cache.Config{}
+		}
+		if creds := c.config.CredentialsSecret; creds != nil {
+			namespaces[creds.Namespace] = cache.Config{}
+		}
+		if acme := c.config.ACME; acme != nil && acme.DNS01 != nil && acme.DNS01.TSIGSecret != nil {
+			namespaces[acme.DNS01.TSIGSecret.Namespace] = cache.Config{}
+		}
+		if sc := c.config.SessionCache; sc != nil && sc.Secret != nil {
+			namespaces[sc.Secret.Namespace] = cache.Config{}
+		}
+	}
+	return namespaces
+}
+
+// watchSources runs the watchers of all consoles with watchable sources until
+// ctx is cancelled, calling changed with the console whose source changed.
+func watchSources(ctx context.Context, consoles []*console, changed func(*console)) {
+	var wg sync.WaitGroup
+	for _, c := range consoles {
+		watchable, ok := c.source.(watchableSource)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := watchable.Watch(ctx, func() { changed(c) }); err != nil {
+				c.logger.WithError(err).Error("Failed to watch certificate source, relying on periodic resync.")
+			}
+		}()
+	}
+	wg.Wait()
+}
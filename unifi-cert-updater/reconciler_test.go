@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestConsoleReconcilerReconcile(t *testing.T) {
+	defer func(d time.Duration) { activationCheckInterval = d }(activationCheckInterval)
+	activationCheckInterval = 10 * time.Millisecond
+
+	server := unifitest.NewServer(unifitest.WithTLS())
+	defer server.Close()
+
+	newSecretData := func() map[string][]byte {
+		cert, key, err := unifitest.GenerateCertificate("unifi.example.com", "unifi.example.com")
+		require.NoError(t, err)
+		return map[string][]byte{"tls.crt": []byte(cert), "tls.key": []byte(key)}
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data:       newSecretData(),
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+	newTestConsole := func(name, secretName string) *console {
+		c, err := newConsole(ConsoleConfig{
+			Name:              name,
+			URL:               server.URL,
+			Username:          unifitest.DefaultUsername,
+			Password:          unifitest.DefaultPassword,
+			TLSSecret:         SecretReference{Namespace: "certs", Name: secretName},
+			MaxCerts:          1,
+			ActivationTimeout: metav1.Duration{Duration: 5 * time.Second},
+		}, logrus.New())
+		require.NoError(t, err)
+		return c
+	}
+	r := &ConsoleReconciler{
+		Client:   k8sClient,
+		Recorder: record.NewFakeRecorder(100),
+		Consoles: map[string]*console{
+			"office":  newTestConsole("office", "unifi-tls"),
+			"missing": newTestConsole("missing", "missing-tls"),
+		},
+		Config: Config{ResyncInterval: time.Hour},
+		Logger: logrus.New(),
+	}
+	ctx := context.Background()
+	activeFingerprint := func() string {
+		for _, cert := range server.Certificates() {
+			if cert.Active {
+				return cert.Fingerprint
+			}
+		}
+		return ""
+	}
+	fingerprint := func(data map[string][]byte) string {
+		f, err := calculateFingerprint(string(data["tls.crt"]))
+		require.NoError(t, err)
+		return f
+	}
+
+	// A successful sync requeues for the periodic resync
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "office"}})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Hour}, result)
+	assert.Equal(t, fingerprint(secret.Data), activeFingerprint())
+
+	// A renewed certificate in the secret is pushed on the next reconcile
+	current := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), current))
+	updated := current.DeepCopy()
+	updated.Data = newSecretData()
+	require.NoError(t, k8sClient.Update(ctx, updated))
+	assert.True(t, secretDataChangedPredicate().Update(event.UpdateEvent{ObjectOld: current, ObjectNew: updated}))
+	result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "office"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, result.RequeueAfter)
+	assert.Equal(t, fingerprint(updated.Data), activeFingerprint())
+	assert.Len(t, server.Certificates(), 1)
+
+	// A failed sync is returned, so that the controller retries with backoff
+	result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "missing"}})
+	assert.ErrorContains(t, err, "error fetching certificate and key")
+	assert.Zero(t, result)
+
+	result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "unknown"}})
+	assert.NoError(t, err)
+	assert.Zero(t, result)
+}
+
+func TestConsolesForSecret(t *testing.T) {
+	r := &ConsoleReconciler{Consoles: map[string]*console{
+		"office": {config: ConsoleConfig{Name: "office", TLSSecret: SecretReference{Namespace: "certs", Name: "wildcard-tls"}}},
+		"home":   {config: ConsoleConfig{Name: "home", TLSSecret: SecretReference{Namespace: "certs", Name: "wildcard-tls"}}},
+		"lab":    {config: ConsoleConfig{Name: "lab", TLSSecret: SecretReference{Namespace: "lab", Name: "lab-tls"}}},
+		"cm": {config: ConsoleConfig{Name: "cm", Source: SourceConfig{
+			CertManager: &CertManagerSourceConfig{Namespace: "certs", Name: "cm-cert"},
+		}}},
+	}}
+	secret := func(namespace, name string) client.Object {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}
+	}
+
+	assert.ElementsMatch(t, []reconcile.Request{request("office"), request("home")}, r.consolesForSecret(context.Background(), secret("certs", "wildcard-tls")))
+	assert.Equal(t, []reconcile.Request{request("lab")}, r.consolesForSecret(context.Background(), secret("lab", "lab-tls")))
+
+	// Unrelated secrets, including a same-named one elsewhere, trigger nothing
+	assert.Empty(t, r.consolesForSecret(context.Background(), secret("certs", "other")))
+	assert.Empty(t, r.consolesForSecret(context.Background(), secret("lab", "wildcard-tls")))
+
+	certificate := newCertificateObject()
+	certificate.SetNamespace("certs")
+	certificate.SetName("cm-cert")
+	assert.Equal(t, []reconcile.Request{request("cm")}, r.consolesForCertificate(context.Background(), certificate))
+	certificate.SetName("other")
+	assert.Empty(t, r.consolesForCertificate(context.Background(), certificate))
+}
+
+func TestCachedNamespaces(t *testing.T) {
+	consoles := []*console{
+		{config: ConsoleConfig{
+			Name:              "office",
+			TLSSecret:         SecretReference{Namespace: "certs", Name: "office-tls"},
+			CredentialsSecret: &CredentialsSecretReference{SecretReference: SecretReference{Namespace: "unifi", Name: "office-credentials"}},
+			SessionCache:      &SessionCacheConfig{Secret: &SecretKeyReference{SecretReference: SecretReference{Namespace: "sessions", Name: "unifi-sessions"}, Key: "office"}},
+		}},
+		{config: ConsoleConfig{
+			Name:   "lab",
+			Source: SourceConfig{CertManager: &CertManagerSourceConfig{Namespace: "lab", Name: "lab-cert"}},
+		}},
+		{config: ConsoleConfig{Name: "home", SessionCache: &SessionCacheConfig{File: "/var/cache/unifi/home.json"}}},
+	}
+	assert.Equal(t, map[string]cache.Config{"certs": {}, "unifi": {}, "sessions": {}, "lab": {}}, cachedNamespaces(consoles))
+}
+
+func TestSecretDataChangedPredicate(t *testing.T) {
+	p := secretDataChangedPredicate()
+	old := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+	}
+
+	// Writing the sync status annotations must not trigger another sync
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{statusAnnotationKey("office", "last-sync"): "2025-06-01T12:00:00Z"}
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: annotated}))
+
+	renewed := old.DeepCopy()
+	renewed.Data["tls.crt"] = []byte("renewed")
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: renewed}))
+
+	assert.True(t, p.Create(event.CreateEvent{Object: old}))
+	assert.False(t, p.Delete(event.DeleteEvent{Object: old}))
+	assert.False(t, p.Generic(event.GenericEvent{Object: old}))
+}
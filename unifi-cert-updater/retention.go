@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Retention rules that can cause a certificate to be deleted
+const (
+	RuleDeleteExpired = "delete-expired"
+	RuleKeepPerSANs   = "keep-last-per-san"
+	RuleKeepPerCN     = "keep-last-per-cn"
+	RuleMaxCerts      = "max-certs"
+)
+
+// RetentionPolicy decides which certificates are pruned from a console. The
+// rules combine: expired certificates are deleted first, then the per-SAN and
+// per-CN limits, then the overall MaxCerts limit. The active certificate and
+// certificates whose name matches ProtectNamePattern are never deleted, and
+// certificates younger than KeepYoungerThan are exempt from the count limits.
+// DeleteExpired is off unless DELETE_EXPIRED_CERTS or the config file turns it
+// on. It is a pointer so that a console that leaves it unset inherits that
+// default, while an explicit false on a console overrides a default of true.
+type RetentionPolicy struct {
+	MaxCerts           int             `json:"-"` // Set from ConsoleConfig.MaxCerts
+	DeleteExpired      *bool           `json:"deleteExpired,omitempty"`
+	KeepPerSANs        int             `json:"keepPerSANs,omitempty"`
+	KeepPerCN          int             `json:"keepPerCN,omitempty"`
+	KeepYoungerThan    metav1.Duration `json:"keepYoungerThan,omitempty"`
+	ProtectNamePattern string          `json:"protectNamePattern,omitempty"`
+}
+
+// PlannedDeletion is a certificate that would be deleted, the retention rule
+// that selected it and why.
+type PlannedDeletion struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// retentionPolicy returns the console's retention policy with its MaxCerts.
+func (c ConsoleConfig) retentionPolicy() RetentionPolicy {
+	policy := c.Retention
+	policy.MaxCerts = c.MaxCerts
+	return policy
+}
+
+// mergeRetentionPolicy fills unset fields of policy from defaults.
+func mergeRetentionPolicy(policy, defaults RetentionPolicy) RetentionPolicy {
+	if policy.DeleteExpired == nil {
+		policy.DeleteExpired = defaults.DeleteExpired
+	}
+	if policy.KeepPerSANs == 0 {
+		policy.KeepPerSANs = defaults.KeepPerSANs
+	}
+	if policy.KeepPerCN == 0 {
+		policy.KeepPerCN = defaults.KeepPerCN
+	}
+	if policy.KeepYoungerThan.Duration == 0 {
+		policy.KeepYoungerThan = defaults.KeepYoungerThan
+	}
+	if policy.ProtectNamePattern == "" {
+		policy.ProtectNamePattern = defaults.ProtectNamePattern
+	}
+	return policy
+}
+
+// planCertificateDeletions applies the retention policy to the certificates on
+// a console and returns the ones to delete, oldest first.
+func planCertificateDeletions(certificates []unifi.Certificate, policy RetentionPolicy, now time.Time) ([]PlannedDeletion, error) {
+	var protectName *regexp.Regexp
+	if policy.ProtectNamePattern != "" {
+		var err error
+		if protectName, err = regexp.Compile(policy.ProtectNamePattern); err != nil {
+			return nil, fmt.Errorf("invalid protectNamePattern: %w", err)
+		}
+	}
+
+	// Sort certificates by `ValidFrom` (newest first)
+	sorted := append([]unifi.Certificate(nil), certificates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ValidFrom.After(sorted[j].ValidFrom)
+	})
+
+	protected := func(cert unifi.Certificate) bool {
+		return cert.Active || (protectName != nil && protectName.MatchString(cert.Name))
+	}
+	young := func(cert unifi.Certificate) bool {
+		if policy.KeepYoungerThan.Duration == 0 {
+			return false
+		}
+		created := cert.CreatedAt
+		if created.IsZero() {
+			created = cert.ValidFrom
+		}
+		return now.Sub(created) < policy.KeepYoungerThan.Duration
+	}
+
+	deletions := map[string]PlannedDeletion{}
+	deleteCert := func(cert unifi.Certificate, rule, reason string) {
+		deletions[cert.ID] = PlannedDeletion{ID: cert.ID, Name: cert.Name, Rule: rule, Reason: reason}
+	}
+
+	if policy.DeleteExpired != nil && *policy.DeleteExpired {
+		for _, cert := range sorted {
+			if !protected(cert) && now.After(cert.ValidTo) {
+				deleteCert(cert, RuleDeleteExpired, fmt.Sprintf("expired at %s", cert.ValidTo.Format(time.RFC3339)))
+			}
+		}
+	}
+
+	keepLastPerGroup := func(keep int, rule string, groupOf func(unifi.Certificate) string) {
+		if keep <= 0 {
+			return
+		}
+		seen := map[string]int{}
+		for _, cert := range sorted {
+			if _, ok := deletions[cert.ID]; ok {
+				continue
+			}
+			group := groupOf(cert)
+			seen[group]++
+			if seen[group] > keep && !protected(cert) && !young(cert) {
+				deleteCert(cert, rule, fmt.Sprintf("%d newer certificates for %s are already kept", keep, group))
+			}
+		}
+	}
+	keepLastPerGroup(policy.KeepPerSANs, RuleKeepPerSANs, func(cert unifi.Certificate) string {
+		sans := append([]string(nil), cert.SubjectAlt.DNS...)
+		sort.Strings(sans)
+		return fmt.Sprintf("SANs [%s]", strings.Join(sans, ", "))
+	})
+	keepLastPerGroup(policy.KeepPerCN, RuleKeepPerCN, func(cert unifi.Certificate) string {
+		return fmt.Sprintf("CN %q", cert.Subject.CN)
+	})
+
+	// Enforce the overall limit on what is left, deleting the oldest first
+	if policy.MaxCerts > 0 {
+		excessCount := len(sorted) - len(deletions) - policy.MaxCerts
+		for i := len(sorted) - 1; i >= 0 && excessCount > 0; i-- {
+			cert := sorted[i]
+			if _, ok := deletions[cert.ID]; ok {
+				continue
+			}
+			if protected(cert) || young(cert) {
+				continue
+			}
+			deleteCert(cert, RuleMaxCerts, fmt.Sprintf("exceeds limit of %d certificates (valid from %s)", policy.MaxCerts, cert.ValidFrom.Format(time.RFC3339)))
+			excessCount--
+		}
+	}
+
+	// Report deletions oldest first
+	var planned []PlannedDeletion
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if deletion, ok := deletions[sorted[i].ID]; ok {
+			planned = append(planned, deletion)
+		}
+	}
+	return planned, nil
+}
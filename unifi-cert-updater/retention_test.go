@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlanCertificateDeletions(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return now.AddDate(0, 0, d) }
+	cert := func(id string, validFrom, validTo time.Time, cn string, active bool) unifi.Certificate {
+		return unifi.Certificate{
+			ID:         id,
+			Name:       id,
+			ValidFrom:  validFrom,
+			ValidTo:    validTo,
+			Active:     active,
+			Subject:    unifi.Subject{CN: cn},
+			SubjectAlt: unifi.SubjectAlt{DNS: []string{cn}},
+		}
+	}
+
+	certificates := []unifi.Certificate{
+		cert("a-expired", day(-120), day(-30), "a.example.com", false),
+		cert("a-old", day(-60), day(30), "a.example.com", false),
+		cert("a-new", day(-10), day(80), "a.example.com", true),
+		cert("b-expired", day(-100), day(-10), "b.example.com", false),
+		cert("b-old", day(-50), day(40), "b.example.com", false),
+		cert("b-new", day(-2), day(88), "b.example.com", false),
+		cert("manual-pinned", day(-200), day(-100), "c.example.com", false),
+	}
+
+	deleteExpired := true
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		expected []PlannedDeletion
+	}{
+		{
+			name:     "within limit",
+			policy:   RetentionPolicy{MaxCerts: 7},
+			expected: nil,
+		},
+		{
+			name:   "max certs deletes oldest inactive",
+			policy: RetentionPolicy{MaxCerts: 5},
+			expected: []PlannedDeletion{
+				{ID: "manual-pinned", Name: "manual-pinned", Rule: RuleMaxCerts, Reason: "exceeds limit of 5 certificates (valid from 2024-11-13T00:00:00Z)"},
+				{ID: "a-expired", Name: "a-expired", Rule: RuleMaxCerts, Reason: "exceeds limit of 5 certificates (valid from 2025-02-01T00:00:00Z)"},
+			},
+		},
+		{
+			name:   "expired deleted unless protected",
+			policy: RetentionPolicy{DeleteExpired: &deleteExpired, ProtectNamePattern: "^manual-"},
+			expected: []PlannedDeletion{
+				{ID: "a-expired", Name: "a-expired", Rule: RuleDeleteExpired, Reason: "expired at 2025-05-02T00:00:00Z"},
+				{ID: "b-expired", Name: "b-expired", Rule: RuleDeleteExpired, Reason: "expired at 2025-05-22T00:00:00Z"},
+			},
+		},
+		{
+			name:   "keep last per SAN set",
+			policy: RetentionPolicy{KeepPerSANs: 1, ProtectNamePattern: "^manual-"},
+			expected: []PlannedDeletion{
+				{ID: "a-expired", Name: "a-expired", Rule: RuleKeepPerSANs, Reason: "1 newer certificates for SANs [a.example.com] are already kept"},
+				{ID: "b-expired", Name: "b-expired", Rule: RuleKeepPerSANs, Reason: "1 newer certificates for SANs [b.example.com] are already kept"},
+				{ID: "a-old", Name: "a-old", Rule: RuleKeepPerSANs, Reason: "1 newer certificates for SANs [a.example.com] are already kept"},
+				{ID: "b-old", Name: "b-old", Rule: RuleKeepPerSANs, Reason: "1 newer certificates for SANs [b.example.com] are already kept"},
+			},
+		},
+		{
+			name:   "combined rules with age protection",
+			policy: RetentionPolicy{DeleteExpired: &deleteExpired, KeepPerCN: 1, KeepYoungerThan: metav1.Duration{Duration: 55 * 24 * time.Hour}, MaxCerts: 3},
+			expected: []PlannedDeletion{
+				{ID: "manual-pinned", Name: "manual-pinned", Rule: RuleDeleteExpired, Reason: "expired at 2025-02-21T00:00:00Z"},
+				{ID: "a-expired", Name: "a-expired", Rule: RuleDeleteExpired, Reason: "expired at 2025-05-02T00:00:00Z"},
+				{ID: "b-expired", Name: "b-expired", Rule: RuleDeleteExpired, Reason: "expired at 2025-05-22T00:00:00Z"},
+				{ID: "a-old", Name: "a-old", Rule: RuleKeepPerCN, Reason: `1 newer certificates for CN "a.example.com" are already kept`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := planCertificateDeletions(certificates, tt.policy, now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+
+	_, err := planCertificateDeletions(certificates, RetentionPolicy{ProtectNamePattern: "("}, now)
+	assert.ErrorContains(t, err, "invalid protectNamePattern")
+}
+
+func TestMergeRetentionPolicy(t *testing.T) {
+	enabled, disabled := true, false
+	defaults := RetentionPolicy{DeleteExpired: &enabled, KeepPerCN: 2, ProtectNamePattern: "^manual-"}
+
+	merged := mergeRetentionPolicy(RetentionPolicy{KeepPerCN: 1}, defaults)
+	assert.Equal(t, RetentionPolicy{DeleteExpired: &enabled, KeepPerCN: 1, ProtectNamePattern: "^manual-"}, merged)
+
+	// An explicit false is kept rather than taken for unset
+	merged = mergeRetentionPolicy(RetentionPolicy{DeleteExpired: &disabled}, defaults)
+	assert.False(t, *merged.DeleteExpired)
+}
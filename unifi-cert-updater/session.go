@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SessionCacheConfig keeps a console's login session between runs, in a file
+// or in a key of a Kubernetes secret, so that short-lived runs resume it
+// instead of logging in every time. Exactly one of file and secret is set.
+type SessionCacheConfig struct {
+	File   string              `json:"file,omitempty"`
+	Secret *SecretKeyReference `json:"secret,omitempty"` // Key defaults to "session-<console name>"
+}
+
+// newSessionStore returns the store for a session cache configuration. The
+// secret store has no Kubernetes client until the console first logs in.
+func newSessionStore(config *SessionCacheConfig) unifi.SessionStore {
+	if config.Secret != nil {
+		return &secretSessionStore{ref: *config.Secret}
+	}
+	return &unifi.FileSessionStore{Path: config.File}
+}
+
+// secretSessionStore saves a session as JSON in a key of a secret, creating
+// the secret if it does not exist.
+type secretSessionStore struct {
+	ref    SecretKeyReference
+	client client.Client
+}
+
+func (s *secretSessionStore) get(ctx context.Context) (*corev1.Secret, error) {
+	if s.client == nil {
+		return nil, fmt.Errorf("no Kubernetes client for session cache secret %s/%s", s.ref.Namespace, s.ref.Name)
+	}
+	var secret corev1.Secret
+	if err := s.client.Get(ctx, client.ObjectKey{Namespace: s.ref.Namespace, Name: s.ref.Name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch session cache secret: %w", err)
+	}
+	return &secret, nil
+}
+
+func (s *secretSessionStore) Load(ctx context.Context) (*unifi.Session, error) {
+	secret, err := s.get(ctx)
+	if err != nil || secret == nil || len(secret.Data[s.ref.Key]) == 0 {
+		return nil, err
+	}
+	var session unifi.Session
+	if err := json.Unmarshal(secret.Data[s.ref.Key], &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session cache secret %s/%s: %w", s.ref.Namespace, s.ref.Name, err)
+	}
+	return &session, nil
+}
+
+func (s *secretSessionStore) Save(ctx context.Context, session *unifi.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	secret, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.ref.Namespace, Name: s.ref.Name},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{s.ref.Key: data},
+		}
+		if err := s.client.Create(ctx, secret); err != nil {
+			return fmt.Errorf("failed to create session cache secret: %w", err)
+		}
+		return nil
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[s.ref.Key] = data
+	if err := s.client.Update(ctx, secret); err != nil {
+		return fmt.Errorf("failed to update session cache secret: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the session's key and leaves the secret itself in place.
+func (s *secretSessionStore) Delete(ctx context.Context) error {
+	secret, err := s.get(ctx)
+	if err != nil || secret == nil {
+		return err
+	}
+	if _, ok := secret.Data[s.ref.Key]; !ok {
+		return nil
+	}
+	delete(secret.Data, s.ref.Key)
+	if err := s.client.Update(ctx, secret); err != nil {
+		return fmt.Errorf("failed to update session cache secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi"
+	"github.com/davidcollom/dockerfiles/unifi-cert-updater/pkg/unifi/unifitest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSecretSessionStore(t *testing.T) {
+	// The secret is shared with another key that must be left alone
+	k8sClient := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-sessions"},
+		Data:       map[string][]byte{"session-home": []byte(`{}`)},
+	}).Build()
+	ctx := context.Background()
+
+	for _, name := range []string{"unifi-sessions", "missing"} {
+		t.Run(name, func(t *testing.T) {
+			store := &secretSessionStore{
+				ref:    SecretKeyReference{SecretReference: SecretReference{Namespace: "certs", Name: name}, Key: "session-office"},
+				client: k8sClient,
+			}
+
+			saved, err := store.Load(ctx)
+			require.NoError(t, err)
+			assert.Nil(t, saved)
+
+			session := &unifi.Session{BaseURL: "https://unifi.example.com", Username: "admin", Cookies: map[string]string{"TOKEN": "token-1"}, UniFiOS: true}
+			require.NoError(t, store.Save(ctx, session))
+			saved, err = store.Load(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, session, saved)
+
+			require.NoError(t, store.Delete(ctx))
+			saved, err = store.Load(ctx)
+			require.NoError(t, err)
+			assert.Nil(t, saved)
+		})
+	}
+
+	var secret corev1.Secret
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: "certs", Name: "unifi-sessions"}, &secret))
+	assert.Equal(t, map[string][]byte{"session-home": []byte(`{}`)}, secret.Data)
+}
+
+func TestConsoleResumesCachedSession(t *testing.T) {
+	server := unifitest.NewServer()
+	defer server.Close()
+	k8sClient := fake.NewClientBuilder().Build()
+	ctx := context.Background()
+
+	config := ConsoleConfig{
+		Name:     "office",
+		URL:      server.URL,
+		Username: unifitest.DefaultUsername,
+		Password: unifitest.DefaultPassword,
+		SessionCache: &SessionCacheConfig{
+			Secret: &SecretKeyReference{SecretReference: SecretReference{Namespace: "certs", Name: "unifi-sessions"}, Key: "session-office"},
+		},
+	}
+
+	// Each run is a new process with a new console, and only the first logs in
+	for range 3 {
+		c, err := newConsole(config, logrus.New())
+		require.NoError(t, err)
+		require.NoError(t, c.login(ctx, k8sClient))
+		_, err = c.client.ListCertificates(ctx)
+		require.NoError(t, err)
+		c.logout(ctx)
+	}
+	assert.Equal(t, 1, server.Logins())
+	assert.Equal(t, 1, server.Sessions(), "a cached session should not be logged out")
+
+	// Without a cache, every run logs in and out again
+	config.SessionCache = nil
+	c, err := newConsole(config, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, c.login(ctx, k8sClient))
+	c.logout(ctx)
+	assert.Equal(t, 2, server.Logins())
+	assert.Equal(t, 1, server.Sessions())
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"software.sslmate.com/src/go-pkcs12"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CertificateSource provides the certificate chain and private key that
+// should be installed on a console.
+type CertificateSource interface {
+	// Fetch returns the PEM-encoded certificate chain, leaf first, and the
+	// PEM-encoded private key.
+	Fetch(ctx context.Context, k8sClient client.Client) (string, string, error)
+}
+
+// dryRunSource is implemented by sources that may change something when
+// fetched, such as issuing a new certificate. Plan mode uses it to read the
+// current certificate instead, along with a description of what Fetch would
+// do. The certificate is empty if there is none yet.
+type dryRunSource interface {
+	FetchDryRun(ctx context.Context, k8sClient client.Client) (string, string, string, error)
+}
+
+// readySource is implemented by sources that depend on something else, such
+// as a cert-manager Certificate being issued. Their Fetch fails at once while
+// that is pending, so that a daemon, which watches them, never holds a worker
+// waiting. WaitReady blocks until the source is ready or its wait times out,
+// for when the certificate is fetched only once.
+type readySource interface {
+	WaitReady(ctx context.Context, k8sClient client.Client) error
+}
+
+// watchableSource is implemented by sources outside Kubernetes. Watch calls
+// changed whenever the certificate may have changed, until ctx is cancelled.
+type watchableSource interface {
+	Watch(ctx context.Context, changed func()) error
+}
+
+// SourceConfig selects where a console's certificate comes from. At most one
+// field may be set; by default tls.crt and tls.key are read from tlsSecret.
+type SourceConfig struct {
+	Secret      *SecretSourceConfig      `json:"secret,omitempty"`
+	Directory   *DirectorySourceConfig   `json:"directory,omitempty"`
+	PKCS12      *PKCS12SourceConfig      `json:"pkcs12,omitempty"`
+	CertManager *CertManagerSourceConfig `json:"certManager,omitempty"`
+}
+
+// SecretSourceConfig reads tlsSecret with custom key names.
+type SecretSourceConfig struct {
+	CertificateKey string `json:"certificateKey,omitempty"` // Defaults to tls.crt
+	PrivateKeyKey  string `json:"privateKeyKey,omitempty"`  // Defaults to tls.key
+}
+
+// DirectorySourceConfig reads PEM files from a directory, such as a mounted
+// secret or a certbot live directory, and watches it for changes.
+type DirectorySourceConfig struct {
+	Path            string `json:"path"`
+	CertificateFile string `json:"certificateFile,omitempty"` // Defaults to tls.crt
+	PrivateKeyFile  string `json:"privateKeyFile,omitempty"`  // Defaults to tls.key
+}
+
+// PKCS12SourceConfig reads a password-protected PKCS#12 bundle and watches
+// it for changes. Without a password file or secret the password is empty.
+type PKCS12SourceConfig struct {
+	Path           string              `json:"path"`
+	PasswordFile   string              `json:"passwordFile,omitempty"`
+	PasswordSecret *SecretKeyReference `json:"passwordSecret,omitempty"`
+}
+
+// CertManagerSourceConfig reads the secret a cert-manager Certificate was
+// issued into once the Certificate is Ready. A one-off sync waits up to
+// WaitTimeout for it; a daemon syncs again when the Certificate changes.
+type CertManagerSourceConfig struct {
+	Namespace   string          `json:"namespace,omitempty"`
+	Name        string          `json:"name"`
+	WaitTimeout metav1.Duration `json:"waitTimeout,omitempty"` // Defaults to 5 minutes
+}
+
+// validateSourceConfig checks that at most one source is selected and that it
+// is complete.
+func validateSourceConfig(config SourceConfig) []error {
+	var errs []error
+	selected := 0
+	if config.Secret != nil {
+		selected++
+	}
+	if config.Directory != nil {
+		selected++
+		if config.Directory.Path == "" {
+			errs = append(errs, fmt.Errorf("source.directory.path is required"))
+		}
+	}
+	if config.PKCS12 != nil {
+		selected++
+		if config.PKCS12.Path == "" {
+			errs = append(errs, fmt.Errorf("source.pkcs12.path is required"))
+		}
+		if config.PKCS12.PasswordFile != "" && config.PKCS12.PasswordSecret != nil {
+			errs = append(errs, fmt.Errorf("only one of source.pkcs12.passwordFile and passwordSecret may be set"))
+		}
+		if ref := config.PKCS12.PasswordSecret; ref != nil && (ref.Name == "" || ref.Namespace == "" || ref.Key == "") {
+			errs = append(errs, fmt.Errorf("source.pkcs12.passwordSecret name, namespace and key are required"))
+		}
+	}
+	if config.CertManager != nil {
+		selected++
+		if config.CertManager.Name == "" || config.CertManager.Namespace == "" {
+			errs = append(errs, fmt.Errorf("source.certManager name and namespace are required"))
+		}
+	}
+	if selected > 1 {
+		errs = append(errs, fmt.Errorf("only one certificate source may be configured"))
+	}
+	return errs
+}
+
+// newCertificateSource returns the source configured for a console.
+func newCertificateSource(config ConsoleConfig, logger *logrus.Logger) (CertificateSource, error) {
+	switch source := config.Source; {
+	case config.ACME != nil:
+		return newACMESource(*config.ACME, config.TLSSecret, logger), nil
+	case source.Directory != nil:
+		return &directorySource{config: *source.Directory, logger: logger}, nil
+	case source.PKCS12 != nil:
+		return &pkcs12Source{config: *source.PKCS12, logger: logger}, nil
+	case source.CertManager != nil:
+		return &certManagerSource{config: *source.CertManager, logger: logger}, nil
+	case source.Secret != nil:
+		return &secretSource{ref: config.TLSSecret, certKey: source.Secret.CertificateKey, keyKey: source.Secret.PrivateKeyKey, logger: logger}, nil
+	default:
+		return &secretSource{ref: config.TLSSecret, logger: logger}, nil
+	}
+}
+
+// secretSource reads the certificate and key from a Kubernetes secret.
+type secretSource struct {
+	ref     SecretReference
+	certKey string // Defaults to tls.crt
+	keyKey  string // Defaults to tls.key
+	logger  *logrus.Logger
+}
+
+func (s *secretSource) Fetch(ctx context.Context, k8sClient client.Client) (string, string, error) {
+	certKey, keyKey := s.certKey, s.keyKey
+	if certKey == "" {
+		certKey = corev1.TLSCertKey
+	}
+	if keyKey == "" {
+		keyKey = corev1.TLSPrivateKeyKey
+	}
+
+	s.logger.Debugf("Fetching certificate from namespace '%s', secret '%s'", s.ref.Namespace, s.ref.Name)
+	return fetchCertAndKeyFromSecret(ctx, k8sClient, s.ref.Namespace, s.ref.Name, certKey, keyKey, s.logger)
+}
+
+// directorySource reads PEM files from a directory.
+type directorySource struct {
+	config DirectorySourceConfig
+	logger *logrus.Logger
+}
+
+func (s *directorySource) files() (string, string) {
+	certFile, keyFile := s.config.CertificateFile, s.config.PrivateKeyFile
+	if certFile == "" {
+		certFile = corev1.TLSCertKey
+	}
+	if keyFile == "" {
+		keyFile = corev1.TLSPrivateKeyKey
+	}
+	return filepath.Join(s.config.Path, certFile), filepath.Join(s.config.Path, keyFile)
+}
+
+func (s *directorySource) Fetch(context.Context, client.Client) (string, string, error) {
+	certFile, keyFile := s.files()
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	s.logger.Infof("Certificate and key read successfully from directory '%s'", s.config.Path)
+	return string(cert), string(key), nil
+}
+
+func (s *directorySource) Watch(ctx context.Context, changed func()) error {
+	return watchDirectory(ctx, s.config.Path, s.logger, changed)
+}
+
+// pkcs12Source decodes a PKCS#12 bundle into PEM.
+type pkcs12Source struct {
+	config PKCS12SourceConfig
+	logger *logrus.Logger
+}
+
+func (s *pkcs12Source) Fetch(ctx context.Context, k8sClient client.Client) (string, string, error) {
+	data, err := os.ReadFile(s.config.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read PKCS#12 file: %w", err)
+	}
+	password, err := s.password(ctx, k8sClient)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateKey, leaf, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode PKCS#12 file %s: %w", s.config.Path, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key from %s: %w", s.config.Path, err)
+	}
+
+	var cert strings.Builder
+	for _, c := range append([]*x509.Certificate{leaf}, caCerts...) {
+		_ = pem.Encode(&cert, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	s.logger.Infof("Certificate and key decoded successfully from PKCS#12 file '%s'", s.config.Path)
+	return cert.String(), string(key), nil
+}
+
+func (s *pkcs12Source) password(ctx context.Context, k8sClient client.Client) (string, error) {
+	if s.config.PasswordFile != "" {
+		password, err := os.ReadFile(s.config.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read PKCS#12 password file: %w", err)
+		}
+		return strings.TrimRight(string(password), "\r\n"), nil
+	}
+
+	if ref := s.config.PasswordSecret; ref != nil {
+		var secret corev1.Secret
+		if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+			return "", fmt.Errorf("failed to fetch PKCS#12 password secret: %w", err)
+		}
+		password, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("PKCS#12 password secret %s/%s is missing %s", ref.Namespace, ref.Name, ref.Key)
+		}
+		return string(password), nil
+	}
+	return "", nil
+}
+
+func (s *pkcs12Source) Watch(ctx context.Context, changed func()) error {
+	return watchDirectory(ctx, filepath.Dir(s.config.Path), s.logger, changed)
+}
+
+// watchDebounce groups the burst of events from replacing several files, or
+// from a Kubernetes volume swapping its ..data symlink, into one change.
+var watchDebounce = time.Second
+
+// watchDirectory calls changed after anything in dir changes. The directory
+// rather than the files is watched so that files replaced by rename are seen.
+func watchDirectory(ctx context.Context, dir string, logger *logrus.Logger, changed func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	logger.Infof("Watching '%s' for certificate changes.", dir)
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			logger.Debugf("File watcher event: %s", event)
+			timer.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.WithError(err).Warn("File watcher error.")
+		case <-timer.C:
+			changed()
+		}
+	}
+}
+
+// certificateGVK identifies cert-manager Certificate resources, which are read
+// as unstructured objects to avoid depending on cert-manager's API module.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// certManagerPollInterval is how often a Certificate is checked while waiting
+// for it to become Ready.
+var certManagerPollInterval = 5 * time.Second
+
+// newCertificateObject returns an empty cert-manager Certificate.
+func newCertificateObject() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(certificateGVK)
+	return obj
+}
+
+// certManagerSource reads the secret of a cert-manager Certificate once the
+// Certificate is Ready.
+type certManagerSource struct {
+	config CertManagerSourceConfig
+	logger *logrus.Logger
+}
+
+// readiness returns the secret name of the Certificate and, if it is not
+// Ready, why not.
+func (s *certManagerSource) readiness(ctx context.Context, k8sClient client.Client) (string, string, error) {
+	obj := newCertificateObject()
+	if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: s.config.Namespace, Name: s.config.Name}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", "Certificate does not exist", nil
+		}
+		return "", "", fmt.Errorf("failed to fetch Certificate %s/%s: %w", s.config.Namespace, s.config.Name, err)
+	}
+
+	secretName, _, _ := unstructured.NestedString(obj.Object, "spec", "secretName")
+	if secretName == "" {
+		return "", "", fmt.Errorf("cert-manager Certificate %s/%s has no spec.secretName", s.config.Namespace, s.config.Name)
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]any)
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		if condition["status"] == string(metav1.ConditionTrue) {
+			return secretName, "", nil
+		}
+		return secretName, fmt.Sprintf("Certificate is not Ready: %v", condition["message"]), nil
+	}
+	return secretName, "Certificate has no Ready condition", nil
+}
+
+func (s *certManagerSource) Fetch(ctx context.Context, k8sClient client.Client) (string, string, error) {
+	secretName, notReady, err := s.readiness(ctx, k8sClient)
+	if err != nil {
+		return "", "", err
+	}
+	if notReady != "" {
+		return "", "", fmt.Errorf("cert-manager Certificate %s/%s is not ready yet: %s", s.config.Namespace, s.config.Name, notReady)
+	}
+
+	return fetchCertAndKeyFromSecret(ctx, k8sClient, s.config.Namespace, secretName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey, s.logger)
+}
+
+func (s *certManagerSource) WaitReady(ctx context.Context, k8sClient client.Client) error {
+	timeout := s.config.WaitTimeout.Duration
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	var notReady string
+	err := wait.PollUntilContextTimeout(ctx, certManagerPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		_, notReady, err = s.readiness(ctx, k8sClient)
+		if err != nil {
+			return false, err
+		}
+		if notReady != "" {
+			s.logger.Infof("Waiting for Certificate %s/%s: %s", s.config.Namespace, s.config.Name, notReady)
+		}
+		return notReady == "", nil
+	})
+	if notReady != "" && wait.Interrupted(err) {
+		return fmt.Errorf("timed out waiting for Certificate %s/%s: %s", s.config.Namespace, s.config.Name, notReady)
+	}
+	return err
+}
+
+func (s *certManagerSource) FetchDryRun(ctx context.Context, k8sClient client.Client) (string, string, string, error) {
+	secretName, notReady, err := s.readiness(ctx, k8sClient)
+	if err != nil {
+		return "", "", "", err
+	}
+	if notReady != "" {
+		return "", "", fmt.Sprintf("wait for Certificate %s/%s (%s)", s.config.Namespace, s.config.Name, notReady), nil
+	}
+
+	cert, key, err := fetchCertAndKeyFromSecret(ctx, k8sClient, s.config.Namespace, secretName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey, s.logger)
+	if err != nil {
+		return "", "", "", err
+	}
+	return cert, key, "", nil
+}
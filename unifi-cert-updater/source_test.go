@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSecretSourceCustomKeys(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data: map[string][]byte{
+			"fullchain.pem": []byte("cert"),
+			"privkey.pem":   []byte("key"),
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+	source, err := newCertificateSource(ConsoleConfig{
+		TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"},
+		Source:    SourceConfig{Secret: &SecretSourceConfig{CertificateKey: "fullchain.pem", PrivateKeyKey: "privkey.pem"}},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	cert, key, err := source.Fetch(context.Background(), k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, "cert", cert)
+	assert.Equal(t, "key", key)
+
+	defaultSource, err := newCertificateSource(ConsoleConfig{TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"}}, logrus.New())
+	require.NoError(t, err)
+	_, _, err = defaultSource.Fetch(context.Background(), k8sClient)
+	assert.EqualError(t, err, "secret is missing tls.crt or tls.key")
+}
+
+func TestDirectorySource(t *testing.T) {
+	now := time.Now()
+	first := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	second := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(2*time.Hour), nil)
+
+	dir := t.TempDir()
+	writeFiles := func(c *testCertificate) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fullchain.pem"), []byte(c.certPEM), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "privkey.pem"), []byte(c.keyPEM), 0o600))
+	}
+	writeFiles(first)
+
+	source := &directorySource{
+		config: DirectorySourceConfig{Path: dir, CertificateFile: "fullchain.pem", PrivateKeyFile: "privkey.pem"},
+		logger: logrus.New(),
+	}
+	cert, key, err := source.Fetch(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.certPEM, cert)
+	assert.Equal(t, first.keyPEM, key)
+
+	defer func(d time.Duration) { watchDebounce = d }(watchDebounce)
+	watchDebounce = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- source.Watch(ctx, func() { changed <- struct{}{} })
+	}()
+
+	// Give the watcher time to start before replacing the files
+	time.Sleep(100 * time.Millisecond)
+	writeFiles(second)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported after files were replaced")
+	}
+
+	cert, _, err = source.Fetch(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.certPEM, cert)
+
+	cancel()
+	assert.NoError(t, <-watchErr)
+}
+
+func TestPKCS12Source(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "Test CA", nil, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	pfx, err := pkcs12.Encode(rand.Reader, leaf.key, leaf.cert, []*x509.Certificate{ca.cert}, "hunter2")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "unifi.p12")
+	require.NoError(t, os.WriteFile(path, pfx, 0o600))
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("hunter2\n"), 0o600))
+
+	source := &pkcs12Source{config: PKCS12SourceConfig{Path: path, PasswordFile: passwordFile}, logger: logrus.New()}
+	cert, key, err := source.Fetch(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.certPEM+ca.certPEM, cert)
+	assert.NoError(t, validateCertificate(cert, key, validationOptions{Now: now, Host: "unifi.example.com"}))
+
+	// The password can also come from a secret
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "p12-password"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	source.config.PasswordFile = ""
+	source.config.PasswordSecret = &SecretKeyReference{SecretReference: SecretReference{Namespace: "certs", Name: "p12-password"}, Key: "password"}
+	_, _, err = source.Fetch(context.Background(), k8sClient)
+	require.NoError(t, err)
+
+	source.config.PasswordSecret = nil
+	_, _, err = source.Fetch(context.Background(), nil)
+	assert.ErrorContains(t, err, "failed to decode PKCS#12 file")
+}
+
+func newTestCertificateResource(ready bool, message string) *unstructured.Unstructured {
+	obj := newCertificateObject()
+	obj.SetNamespace("certs")
+	obj.SetName("unifi")
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	obj.Object["spec"] = map[string]any{"secretName": "unifi-tls"}
+	obj.Object["status"] = map[string]any{
+		"conditions": []any{
+			map[string]any{"type": "Ready", "status": status, "message": message},
+		},
+	}
+	return obj
+}
+
+func newCertManagerTestClient(objs ...runtime.Object) *fake.ClientBuilder {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	scheme.AddKnownTypeWithName(certificateGVK, &unstructured.Unstructured{})
+	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...)
+}
+
+func TestCertManagerSource(t *testing.T) {
+	defer func(d time.Duration) { certManagerPollInterval = d }(certManagerPollInterval)
+	certManagerPollInterval = 10 * time.Millisecond
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "certs", Name: "unifi-tls"},
+		Data: map[string][]byte{
+			"tls.crt": []byte("cert"),
+			"tls.key": []byte("key"),
+		},
+	}
+	source := &certManagerSource{
+		config: CertManagerSourceConfig{Namespace: "certs", Name: "unifi", WaitTimeout: metav1.Duration{Duration: 100 * time.Millisecond}},
+		logger: logrus.New(),
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		k8sClient := newCertManagerTestClient(secret, newTestCertificateResource(true, "")).Build()
+		require.NoError(t, source.WaitReady(context.Background(), k8sClient))
+		cert, key, err := source.Fetch(context.Background(), k8sClient)
+		require.NoError(t, err)
+		assert.Equal(t, "cert", cert)
+		assert.Equal(t, "key", key)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		k8sClient := newCertManagerTestClient(secret, newTestCertificateResource(false, "Issuing certificate")).Build()
+
+		// Fetch does not wait, so that it never holds up a daemon's worker
+		start := time.Now()
+		_, _, err := source.Fetch(context.Background(), k8sClient)
+		assert.EqualError(t, err, "cert-manager Certificate certs/unifi is not ready yet: Certificate is not Ready: Issuing certificate")
+		assert.Less(t, time.Since(start), source.config.WaitTimeout.Duration)
+
+		err = source.WaitReady(context.Background(), k8sClient)
+		assert.EqualError(t, err, "timed out waiting for Certificate certs/unifi: Certificate is not Ready: Issuing certificate")
+
+		cert, _, issue, err := source.FetchDryRun(context.Background(), k8sClient)
+		require.NoError(t, err)
+		assert.Empty(t, cert)
+		assert.Equal(t, "wait for Certificate certs/unifi (Certificate is not Ready: Issuing certificate)", issue)
+	})
+
+	t.Run("becomes ready while waiting", func(t *testing.T) {
+		k8sClient := newCertManagerTestClient(secret, newTestCertificateResource(false, "Issuing certificate")).Build()
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			issued := newCertificateObject()
+			if err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "certs", Name: "unifi"}, issued); err == nil {
+				issued.Object["status"] = newTestCertificateResource(true, "").Object["status"]
+				_ = k8sClient.Update(context.Background(), issued)
+			}
+		}()
+		require.NoError(t, source.WaitReady(context.Background(), k8sClient))
+		_, _, err := source.Fetch(context.Background(), k8sClient)
+		assert.NoError(t, err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		k8sClient := newCertManagerTestClient(secret).Build()
+		_, _, err := source.Fetch(context.Background(), k8sClient)
+		assert.EqualError(t, err, "cert-manager Certificate certs/unifi is not ready yet: Certificate does not exist")
+		err = source.WaitReady(context.Background(), k8sClient)
+		assert.EqualError(t, err, "timed out waiting for Certificate certs/unifi: Certificate does not exist")
+	})
+
+	t.Run("no secret name", func(t *testing.T) {
+		certificate := newTestCertificateResource(true, "")
+		unstructured.RemoveNestedField(certificate.Object, "spec", "secretName")
+		k8sClient := newCertManagerTestClient(secret, certificate).Build()
+		_, _, err := source.Fetch(context.Background(), k8sClient)
+		assert.EqualError(t, err, "cert-manager Certificate certs/unifi has no spec.secretName")
+
+		// Waiting longer would not help
+		start := time.Now()
+		err = source.WaitReady(context.Background(), k8sClient)
+		assert.EqualError(t, err, "cert-manager Certificate certs/unifi has no spec.secretName")
+		assert.Less(t, time.Since(start), source.config.WaitTimeout.Duration)
+	})
+}
+
+func TestValidateSourceConfig(t *testing.T) {
+	assert.Empty(t, validateSourceConfig(SourceConfig{}))
+	assert.Empty(t, validateSourceConfig(SourceConfig{Directory: &DirectorySourceConfig{Path: "/etc/ssl/unifi"}}))
+
+	errs := validateSourceConfig(SourceConfig{
+		Directory: &DirectorySourceConfig{},
+		PKCS12:    &PKCS12SourceConfig{Path: "/etc/ssl/unifi.p12", PasswordFile: "/etc/ssl/password", PasswordSecret: &SecretKeyReference{}},
+	})
+	require.Len(t, errs, 4)
+	assert.EqualError(t, errs[3], "only one certificate source may be configured")
+}
+
+func TestConsoleUsesKubernetes(t *testing.T) {
+	assert.True(t, ConsoleConfig{TLSSecret: SecretReference{Namespace: "certs", Name: "unifi-tls"}}.usesKubernetes())
+	assert.True(t, ConsoleConfig{Source: SourceConfig{CertManager: &CertManagerSourceConfig{Name: "unifi"}}}.usesKubernetes())
+	assert.False(t, ConsoleConfig{Source: SourceConfig{Directory: &DirectorySourceConfig{Path: "/etc/ssl/unifi"}}}.usesKubernetes())
+	assert.False(t, ConsoleConfig{Source: SourceConfig{PKCS12: &PKCS12SourceConfig{Path: "/etc/ssl/unifi.p12"}}}.usesKubernetes())
+}
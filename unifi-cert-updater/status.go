@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// annotationPrefix namespaces the status annotations written to TLS secrets.
+// Each console gets its own set of keys so several consoles can share a secret.
+const annotationPrefix = "unifi-cert-updater.davidcollom.github.io/"
+
+// Values of the result annotation
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+// Event reasons
+const (
+	ReasonCertificateSynced     = "CertificateSynced"
+	ReasonCertificateSyncFailed = "CertificateSyncFailed"
+)
+
+// statusAnnotationKey returns the annotation key for a console's status field.
+func statusAnnotationKey(consoleName, field string) string {
+	return fmt.Sprintf("%s%s.%s", annotationPrefix, consoleName, field)
+}
+
+// statusAnnotations renders a console status as secret annotations. A nil
+// value means the annotation should be removed.
+func statusAnnotations(config ConsoleConfig, status ConsoleStatus) map[string]*string {
+	annotations := map[string]*string{
+		statusAnnotationKey(config.Name, "console-url"): &config.URL,
+	}
+
+	lastSync := status.LastAttempt.UTC().Format(time.RFC3339)
+	annotations[statusAnnotationKey(config.Name, "last-sync")] = &lastSync
+
+	if status.CertificateID != "" {
+		annotations[statusAnnotationKey(config.Name, "certificate-id")] = &status.CertificateID
+	}
+	if status.Fingerprint != "" {
+		annotations[statusAnnotationKey(config.Name, "fingerprint")] = &status.Fingerprint
+	}
+
+	result, message := ResultSuccess, ""
+	if status.Err != nil {
+		result, message = ResultFailed, status.Err.Error()
+	}
+	annotations[statusAnnotationKey(config.Name, "result")] = &result
+	if message != "" {
+		annotations[statusAnnotationKey(config.Name, "error")] = &message
+	} else {
+		annotations[statusAnnotationKey(config.Name, "error")] = nil
+	}
+
+	return annotations
+}
+
+// recordStatus writes the console's status onto its TLS secret and emits an
+// event for the outcome. A successful sync that uploaded and activated nothing
+// emits no event and only patches annotations whose value changed, so that
+// resyncs do not write to the secret. Failures are logged but never fail the
+// sync itself. Nothing is recorded for consoles without a TLS secret.
+func (c *console) recordStatus(ctx context.Context, k8sClient client.Client, recorder record.EventRecorder, status ConsoleStatus) {
+	if k8sClient == nil || c.config.TLSSecret.Name == "" {
+		return
+	}
+
+	var secret corev1.Secret
+	key := client.ObjectKey{Namespace: c.config.TLSSecret.Namespace, Name: c.config.TLSSecret.Name}
+	if err := k8sClient.Get(ctx, key, &secret); err != nil {
+		c.logger.WithError(err).Warn("Failed to fetch secret to record sync status.")
+		return
+	}
+
+	annotations := statusAnnotations(c.config, status)
+	// A sync that changed nothing keeps the time of the last one that did
+	lastSyncKey := statusAnnotationKey(c.config.Name, "last-sync")
+	if _, ok := secret.Annotations[lastSyncKey]; ok && status.Err == nil && !status.Changed {
+		delete(annotations, lastSyncKey)
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	modified := false
+	for k, v := range annotations {
+		old, ok := secret.Annotations[k]
+		switch {
+		case v == nil && ok:
+			delete(secret.Annotations, k)
+			modified = true
+		case v != nil && (!ok || old != *v):
+			secret.Annotations[k] = *v
+			modified = true
+		}
+	}
+	if modified {
+		if err := k8sClient.Patch(ctx, &secret, patch); err != nil {
+			c.logger.WithError(err).Warn("Failed to record sync status on secret.")
+		}
+	}
+
+	if recorder == nil || (status.Err == nil && !status.Changed) {
+		return
+	}
+	if status.Err != nil {
+		recorder.Eventf(&secret, corev1.EventTypeWarning, ReasonCertificateSyncFailed,
+			"Failed to sync certificate to UniFi console %s: %v", c.config.Name, status.Err)
+		return
+	}
+	recorder.Eventf(&secret, corev1.EventTypeNormal, ReasonCertificateSynced,
+		"Certificate %s (%s) is active on UniFi console %s", status.CertificateID, status.Fingerprint, c.config.Name)
+}
+
+// clientEventRecorder is a synchronous record.EventRecorder that creates
+// events with a controller-runtime client. It is used in once mode, where the
+// process exits before an asynchronous event broadcaster would flush.
+type clientEventRecorder struct {
+	client    client.Client
+	scheme    *runtime.Scheme
+	component string
+}
+
+func (r *clientEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+func (r *clientEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+func (r *clientEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	ref, err := reference.GetReference(r.scheme, object)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get reference for event.")
+		return
+	}
+
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    ref.Namespace,
+			Annotations:  annotations,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Type:           eventtype,
+		Source:         corev1.EventSource{Component: r.component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err := r.client.Create(context.Background(), event); err != nil {
+		logger.WithError(err).Warn("Failed to create event.")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordStatus(t *testing.T) {
+	lastSync := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := &console{
+		config: ConsoleConfig{
+			Name:      "office",
+			URL:       "https://office.example.com",
+			TLSSecret: SecretReference{Namespace: "certs", Name: "office-tls"},
+		},
+		logger: logrus.NewEntry(logrus.New()),
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "certs",
+		Name:        "office-tls",
+		Annotations: map[string]string{"unrelated": "kept"},
+	}}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	recorder := record.NewFakeRecorder(10)
+
+	getSecret := func() corev1.Secret {
+		var got corev1.Secret
+		require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(secret), &got))
+		return got
+	}
+	getAnnotations := func() map[string]string {
+		return getSecret().Annotations
+	}
+
+	c.recordStatus(context.Background(), k8sClient, recorder, ConsoleStatus{
+		LastAttempt: lastSync,
+		Err:         &syncError{Stage: StageLogin, Err: errors.New("login failed")},
+	})
+	assert.Equal(t, map[string]string{
+		"unrelated": "kept",
+		"unifi-cert-updater.davidcollom.github.io/office.console-url": "https://office.example.com",
+		"unifi-cert-updater.davidcollom.github.io/office.last-sync":   "2025-06-01T12:00:00Z",
+		"unifi-cert-updater.davidcollom.github.io/office.result":      "failed",
+		"unifi-cert-updater.davidcollom.github.io/office.error":       "login failed",
+	}, getAnnotations())
+	assert.Equal(t, "Warning CertificateSyncFailed Failed to sync certificate to UniFi console office: login failed", <-recorder.Events)
+
+	c.recordStatus(context.Background(), k8sClient, recorder, ConsoleStatus{
+		LastAttempt:   lastSync,
+		LastSuccess:   lastSync,
+		CertificateID: "1",
+		Fingerprint:   "AA:BB",
+		Changed:       true,
+	})
+	assert.Equal(t, map[string]string{
+		"unrelated": "kept",
+		"unifi-cert-updater.davidcollom.github.io/office.console-url":    "https://office.example.com",
+		"unifi-cert-updater.davidcollom.github.io/office.last-sync":      "2025-06-01T12:00:00Z",
+		"unifi-cert-updater.davidcollom.github.io/office.result":         "success",
+		"unifi-cert-updater.davidcollom.github.io/office.certificate-id": "1",
+		"unifi-cert-updater.davidcollom.github.io/office.fingerprint":    "AA:BB",
+	}, getAnnotations())
+	assert.Equal(t, "Normal CertificateSynced Certificate 1 (AA:BB) is active on UniFi console office", <-recorder.Events)
+
+	// A resync that changed nothing neither writes to the secret nor emits
+	// an event
+	synced := getSecret()
+	c.recordStatus(context.Background(), k8sClient, recorder, ConsoleStatus{
+		LastAttempt:   lastSync.Add(time.Hour),
+		LastSuccess:   lastSync.Add(time.Hour),
+		CertificateID: "1",
+		Fingerprint:   "AA:BB",
+	})
+	assert.Equal(t, synced, getSecret())
+	assert.Empty(t, recorder.Events)
+
+	// Failures are still recorded when they change nothing on the console
+	c.recordStatus(context.Background(), k8sClient, recorder, ConsoleStatus{
+		LastAttempt:   lastSync.Add(2 * time.Hour),
+		LastSuccess:   lastSync.Add(time.Hour),
+		CertificateID: "1",
+		Fingerprint:   "AA:BB",
+		Err:           &syncError{Stage: StageFetch, Err: errors.New("secret not found")},
+	})
+	assert.Equal(t, "2025-06-01T14:00:00Z", getAnnotations()["unifi-cert-updater.davidcollom.github.io/office.last-sync"])
+	assert.Equal(t, "Warning CertificateSyncFailed Failed to sync certificate to UniFi console office: secret not found", <-recorder.Events)
+}
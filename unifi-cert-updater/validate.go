@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Names of the pre-upload certificate checks
+const (
+	CheckParse      = "parse"
+	CheckKeyPair    = "key-pair"
+	CheckChainOrder = "chain-order"
+	CheckValidity   = "validity"
+	CheckHostname   = "hostname"
+)
+
+// CertificateValidationError reports which pre-upload check rejected a
+// certificate.
+type CertificateValidationError struct {
+	Check string
+	Err   error
+}
+
+func (e *CertificateValidationError) Error() string {
+	return fmt.Sprintf("certificate validation failed (%s): %v", e.Check, e.Err)
+}
+
+func (e *CertificateValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validationOptions controls the optional pre-upload checks.
+type validationOptions struct {
+	Now  time.Time // Defaults to time.Now()
+	Host string    // If set, the leaf certificate must be valid for this host
+}
+
+// validateCertificate checks that a certificate bundle is safe to upload: the
+// key matches the leaf, every certificate in the chain is signed by the one
+// that follows it, all certificates are currently valid and, optionally, the
+// leaf covers the console's hostname.
+func validateCertificate(certPEM, keyPEM string, opts validationOptions) error {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	chain, err := parseCertificateChain(certPEM)
+	if err != nil {
+		return &CertificateValidationError{Check: CheckParse, Err: err}
+	}
+	leaf := chain[0]
+
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return &CertificateValidationError{Check: CheckKeyPair, Err: err}
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return &CertificateValidationError{
+				Check: CheckChainOrder,
+				Err:   fmt.Errorf("certificate %d (%s) is not signed by certificate %d (%s): %w", i, chain[i].Subject, i+1, chain[i+1].Subject, err),
+			}
+		}
+	}
+
+	for i, cert := range chain {
+		if opts.Now.Before(cert.NotBefore) {
+			return &CertificateValidationError{
+				Check: CheckValidity,
+				Err:   fmt.Errorf("certificate %d (%s) is not valid before %s", i, cert.Subject, cert.NotBefore.Format(time.RFC3339)),
+			}
+		}
+		if opts.Now.After(cert.NotAfter) {
+			return &CertificateValidationError{
+				Check: CheckValidity,
+				Err:   fmt.Errorf("certificate %d (%s) expired at %s", i, cert.Subject, cert.NotAfter.Format(time.RFC3339)),
+			}
+		}
+	}
+
+	if opts.Host != "" {
+		if err := leaf.VerifyHostname(opts.Host); err != nil {
+			return &CertificateValidationError{Check: CheckHostname, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// parseCertificateChain decodes every CERTIFICATE block in a PEM bundle, leaf
+// first.
+func parseCertificateChain(certPEM string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d: %w", len(chain), err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found in PEM data")
+	}
+	return chain, nil
+}
+
+// hostFromURL returns the hostname part of a console URL.
+func hostFromURL(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL %s: %w", rawURL, err)
+	}
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("URL %s has no host", rawURL)
+	}
+	return parsed.Hostname(), nil
+}
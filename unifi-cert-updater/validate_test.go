@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+// newTestCertificate issues a certificate signed by parent, or a self-signed
+// CA if parent is nil.
+func newTestCertificate(t *testing.T, cn string, dnsNames []string, notBefore, notAfter time.Time, parent *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	ca := newTestCertificate(t, "Test CA", nil, now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0), nil)
+	leaf := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.AddDate(0, -1, 0), now.AddDate(0, 2, 0), ca)
+	expired := newTestCertificate(t, "unifi.example.com", []string{"unifi.example.com"}, now.AddDate(0, -3, 0), now.AddDate(0, 0, -1), ca)
+	other := newTestCertificate(t, "other.example.com", []string{"other.example.com"}, now.AddDate(0, -1, 0), now.AddDate(0, 2, 0), ca)
+
+	tests := []struct {
+		name          string
+		cert          string
+		key           string
+		host          string
+		expectedCheck string
+	}{
+		{
+			name: "valid chain",
+			cert: leaf.certPEM + ca.certPEM,
+			key:  leaf.keyPEM,
+			host: "unifi.example.com",
+		},
+		{
+			name:          "not PEM",
+			cert:          "cert_data",
+			key:           leaf.keyPEM,
+			expectedCheck: CheckParse,
+		},
+		{
+			name:          "mismatched key",
+			cert:          leaf.certPEM + ca.certPEM,
+			key:           other.keyPEM,
+			expectedCheck: CheckKeyPair,
+		},
+		{
+			name:          "reordered chain",
+			cert:          ca.certPEM + leaf.certPEM,
+			key:           ca.keyPEM,
+			expectedCheck: CheckChainOrder,
+		},
+		{
+			name:          "expired leaf",
+			cert:          expired.certPEM + ca.certPEM,
+			key:           expired.keyPEM,
+			expectedCheck: CheckValidity,
+		},
+		{
+			name:          "hostname mismatch",
+			cert:          other.certPEM + ca.certPEM,
+			key:           other.keyPEM,
+			host:          "unifi.example.com",
+			expectedCheck: CheckHostname,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCertificate(tt.cert, tt.key, validationOptions{Now: now, Host: tt.host})
+			if tt.expectedCheck == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *CertificateValidationError
+			require.True(t, errors.As(err, &validationErr), "expected a CertificateValidationError, got %v", err)
+			assert.Equal(t, tt.expectedCheck, validationErr.Check)
+		})
+	}
+}
+
+func TestHostFromURL(t *testing.T) {
+	host, err := hostFromURL("https://unifi.example.com:8443/")
+	assert.NoError(t, err)
+	assert.Equal(t, "unifi.example.com", host)
+
+	_, err = hostFromURL("unifi.example.com")
+	assert.EqualError(t, err, "URL unifi.example.com has no host")
+}
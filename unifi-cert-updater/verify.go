@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// activationCheckInterval is how long to wait between TLS probes while the
+// console restarts its web server with the new certificate.
+var activationCheckInterval = 5 * time.Second
+
+// servedCertificateFingerprint dials the console over TLS and returns the
+// fingerprint of the leaf certificate it presents.
+func servedCertificateFingerprint(ctx context.Context, address, serverName string) (string, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config: &tls.Config{
+			ServerName: serverName,
+			// We compare fingerprints ourselves, the chain may not be trusted yet
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	peerCerts := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peerCerts) == 0 {
+		return "", fmt.Errorf("%s presented no certificate", address)
+	}
+	return fingerprintCertificate(peerCerts[0]), nil
+}
+
+// verifyServedCertificate polls the console until it serves the certificate
+// with the expected fingerprint, or the timeout expires.
+func verifyServedCertificate(ctx context.Context, consoleURL, expectedFingerprint string, timeout time.Duration, logger *logrus.Entry) error {
+	parsed, err := url.Parse(consoleURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse console URL: %w", err)
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "443"
+	}
+	address := net.JoinHostPort(parsed.Hostname(), port)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		fingerprint, err := servedCertificateFingerprint(ctx, address, parsed.Hostname())
+		switch {
+		case err != nil:
+			lastErr = err
+		case fingerprint != expectedFingerprint:
+			lastErr = fmt.Errorf("console serves certificate %s, expected %s", fingerprint, expectedFingerprint)
+		default:
+			logger.Infof("Console at %s is serving certificate %s.", address, fingerprint)
+			return nil
+		}
+		logger.WithError(lastErr).Debug("Activated certificate not served yet.")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("console did not serve the activated certificate within %s: %w", timeout, lastErr)
+		case <-time.After(activationCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyServedCertificate(t *testing.T) {
+	activationCheckInterval = 10 * time.Millisecond
+
+	now := time.Now()
+	ca := newTestCertificate(t, "Test CA", nil, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	served := newTestCertificate(t, "127.0.0.1", nil, now.Add(-time.Hour), now.Add(time.Hour), ca)
+	other := newTestCertificate(t, "127.0.0.1", nil, now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	keyPair, err := tls.X509KeyPair([]byte(served.certPEM), []byte(served.keyPEM))
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	server.StartTLS()
+	defer server.Close()
+
+	log := logrus.NewEntry(logrus.New())
+
+	t.Run("served certificate matches", func(t *testing.T) {
+		err := verifyServedCertificate(context.Background(), server.URL, fingerprintCertificate(served.cert), time.Second, log)
+		assert.NoError(t, err)
+	})
+
+	t.Run("served certificate differs", func(t *testing.T) {
+		err := verifyServedCertificate(context.Background(), server.URL, fingerprintCertificate(other.cert), 50*time.Millisecond, log)
+		assert.ErrorContains(t, err, "console did not serve the activated certificate within 50ms")
+	})
+
+	t.Run("console unreachable", func(t *testing.T) {
+		err := verifyServedCertificate(context.Background(), "https://127.0.0.1:1", fingerprintCertificate(served.cert), 50*time.Millisecond, log)
+		assert.ErrorContains(t, err, "failed to connect to 127.0.0.1:1")
+	})
+}